@@ -5,23 +5,35 @@ Copyright 2021 Adevinta
 package main
 
 import (
+	"fmt"
 	"io"
 	"os"
 
 	"github.com/BurntSushi/toml"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/adevinta/vulcan-reports-generator/pkg/events"
 	"github.com/adevinta/vulcan-reports-generator/pkg/notify"
 	"github.com/adevinta/vulcan-reports-generator/pkg/queue"
+	"github.com/adevinta/vulcan-reports-generator/pkg/report"
 )
 
 type config struct {
-	Log        logConfig
-	API        apiConfig
-	DB         dbConfig
-	SQS        sqsConfig
-	SES        notify.SESConfig
-	Generators map[string]interface{}
+	Log         logConfig
+	API         apiConfig
+	DB          dbConfig
+	Queue       queueConfig
+	SQS         sqsConfig
+	Processor   report.ProcessorConfig
+	Notifier    notifierConfig
+	SES         notify.SESConfig
+	Unsubscribe notify.UnsubscribeConfig
+	Caps        notify.CapsConfig
+	Reaper      report.ReaperConfig
+	Outbox      report.DispatcherConfig
+	Events      events.Config
+	Feedback    feedbackConfig
+	Generators  map[string]interface{}
 }
 
 type logConfig struct {
@@ -31,6 +43,9 @@ type logConfig struct {
 
 type apiConfig struct {
 	Port int `toml:"port"`
+	// AdminToken protects the admin, suppressions and preferences
+	// endpoints, which are disabled if it is not set.
+	AdminToken string `toml:"admin_token"`
 }
 
 type dbConfig struct {
@@ -43,9 +58,72 @@ type dbConfig struct {
 	Name    string `toml:"name"`
 }
 
+// queueConfig selects the backend to consume report generation
+// requests from: "sqs" (default) or "spool".
+type queueConfig struct {
+	Backend string            `toml:"backend"`
+	Spool   queue.SpoolConfig `toml:"spool"`
+}
+
+// sqsConfig holds the configuration of the queues to consume
+// report generation requests from. The queue configured at the
+// top level is only used when no queues list is configured.
 type sqsConfig struct {
 	queue.SQSConfig
-	NProcessors uint8 `toml:"number_of_processors"`
+	NProcessors uint8                 `toml:"number_of_processors"`
+	Autoscale   queue.AutoscaleConfig `toml:"autoscale"`
+	Queues      []sqsQueueConfig      `toml:"queues"`
+}
+
+// sqsQueueConfig is the configuration for each one of the queues.
+// ReportTypes restricts the report types that can be requested
+// through the queue. If empty, every report type is allowed.
+type sqsQueueConfig struct {
+	queue.SQSQueueConfig
+	ReportTypes []string `toml:"report_types"`
+}
+
+// notifierConfig holds the configuration of the notifier that
+// sends the report notifications. Backend is one of: ses (default)
+// or file, which writes the notifications to a local directory.
+type notifierConfig struct {
+	Backend string               `toml:"backend"`
+	File    notify.FileConfig    `toml:"file"`
+	Sandbox notify.SandboxConfig `toml:"sandbox"`
+}
+
+// feedbackConfig holds the configuration of the queue to
+// consume the SES bounce, complaint and delivery notifications from.
+type feedbackConfig struct {
+	Enabled bool                 `toml:"enabled"`
+	SQS     queue.SQSQueueConfig `toml:"sqs"`
+}
+
+// queues returns the configured queues.
+func (c sqsConfig) queues() []sqsQueueConfig {
+	if len(c.Queues) > 0 {
+		return c.Queues
+	}
+	return []sqsQueueConfig{
+		{
+			SQSQueueConfig: queue.SQSQueueConfig{
+				SQSConfig:  c.SQSConfig,
+				NConsumers: c.NProcessors,
+				Autoscale:  c.Autoscale,
+			},
+		},
+	}
+}
+
+// defaultQueue returns the first configured queue which
+// allows every report type, if any.
+func (c sqsConfig) defaultQueue() (sqsQueueConfig, bool) {
+	for _, q := range c.queues() {
+		if len(q.ReportTypes) == 0 {
+			return q, true
+		}
+	}
+	return sqsQueueConfig{}, false
 }
 
 func parseConfig(cfgFilePath string) (*config, error) {
@@ -64,10 +142,30 @@ func parseConfig(cfgFilePath string) (*config, error) {
 	if _, err := toml.Decode(string(cfgData[:]), &conf); err != nil {
 		return nil, err
 	}
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
 
 	return &conf, nil
 }
 
+// validate checks the settings which can not be combined.
+func (c config) validate() error {
+	sesBackend := c.Notifier.Backend == "" || c.Notifier.Backend == notify.BackendSES
+	// With unsubscribe links each recipient is sent its own email,
+	// so the CC addresses would get a copy of every one of them,
+	// including the personal links of the other recipients.
+	if sesBackend && c.Unsubscribe.Enabled && len(c.SES.CC) > 0 {
+		return fmt.Errorf("%w: unsubscribe links can not be enabled along with ses cc", notify.ErrInvalidConfig)
+	}
+	// The sandbox only rewrites the recipients, so
+	// the CC addresses would still be notified.
+	if sesBackend && c.Notifier.Sandbox.Enabled && len(c.SES.CC) > 0 {
+		return fmt.Errorf("%w: sandbox mode can not be enabled along with ses cc", notify.ErrInvalidConfig)
+	}
+	return nil
+}
+
 // parseLogLevel parses a configured string log level
 // and returns the correspondent logrus log level.
 // If log level is invalid, default level is Info.
@@ -10,7 +10,9 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 
 	metrics "github.com/adevinta/vulcan-metrics-client"
 	"github.com/aws/aws-sdk-go/aws"
@@ -20,6 +22,7 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/adevinta/vulcan-reports-generator/pkg/api"
+	"github.com/adevinta/vulcan-reports-generator/pkg/events"
 	"github.com/adevinta/vulcan-reports-generator/pkg/model"
 	"github.com/adevinta/vulcan-reports-generator/pkg/notify"
 	"github.com/adevinta/vulcan-reports-generator/pkg/queue"
@@ -51,15 +54,14 @@ func main() {
 	awsSess := session.Must(session.NewSession())
 
 	// Build notifier.
-	if conf.SES.Region == "" {
-		conf.SES.Region = defRegion
-	}
-	notifier, err := notify.NewSESNotifier(conf.SES, ses.New(awsSess, &aws.Config{
-		Region: aws.String(conf.SES.Region),
-	}))
+	baseNotifier, err := buildNotifier(*conf, awsSess)
 	if err != nil {
 		logger.WithError(err).Fatal("Error creating notifier")
 	}
+	sender, err := notify.NewSandboxNotifier(baseNotifier, conf.Notifier.Sandbox, logger)
+	if err != nil {
+		logger.WithError(err).Fatal("Error creating sandbox notifier")
+	}
 	// Build metrics client.
 	metricsClient, err := metrics.NewClient()
 	if err != nil {
@@ -75,6 +77,18 @@ func main() {
 	}
 	defer db.Close()
 
+	// Recipient preferences are honoured, and suppressed recipients
+	// and those over the daily caps are stripped from every notification.
+	preferences := storage.NewPreferencesRepository(db)
+	prefNotifier, err := notify.NewPreferencesNotifier(sender, preferences, conf.Unsubscribe, logger)
+	if err != nil {
+		logger.WithError(err).Fatal("Error creating preferences notifier")
+	}
+	suppressions := storage.NewSuppressionsRepository(db)
+	deliveries := storage.NewDeliveriesRepository(db)
+	notifier := notify.NewCapsNotifier(notify.NewSuppressionNotifier(prefNotifier, suppressions, logger),
+		deliveries, conf.Caps, logger)
+
 	// Build generate Use Cases.
 	generateUCC := map[model.ReportType]report.GenerateUC{}
 	repositories := map[model.ReportType]storage.ReportsRepository{}
@@ -106,27 +120,177 @@ func main() {
 	}
 
 	// Build processor.
-	processor, err := report.NewProcessor(logger, generateUCC, notifier, metricsClient)
+	var queues []queue.SQSQueueConfig
+	conf.Processor.QueueReportTypes = map[string][]model.ReportType{}
+	for _, q := range conf.SQS.queues() {
+		queues = append(queues, q.SQSQueueConfig)
+		for _, t := range q.ReportTypes {
+			conf.Processor.QueueReportTypes[q.Name()] = append(conf.Processor.QueueReportTypes[q.Name()], model.ReportType(t))
+		}
+		conf.Reaper.QueueTimeout = max(conf.Reaper.QueueTimeout, q.Timeout)
+	}
+	conf.Processor.Recipients = conf.SES.Recipients
+
+	publisher, err := events.NewPublisher(conf.Events)
+	if err != nil {
+		logger.WithError(err).Fatal("Error creating events publisher")
+	}
+
+	// Notifications are written to the outbox, if enabled,
+	// and sent by the dispatcher.
+	var outbox storage.OutboxRepository
+	if conf.Outbox.Enabled {
+		outbox = storage.NewOutboxRepository(db)
+	}
+
+	processedMssgs := storage.NewProcessedMessagesRepository(db)
+	processor, err := report.NewProcessor(logger, conf.Processor, generateUCC, notifier, metricsClient, publisher, processedMssgs, deliveries, outbox)
 	if err != nil {
 		logger.WithError(err).Fatal("Error creating queue processor")
 	}
 
-	sqsConsumerGroup, err := queue.NewSQSConsumerGroup(conf.SQS.NProcessors, conf.SQS.SQSConfig, processor, logger)
+	// Replay requests instead of consuming them from the queue.
+	if flag.Arg(0) == replayCmd {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		if err := runReplay(ctx, flag.Args()[1:], logger, processor, processedMssgs); err != nil {
+			logger.WithError(err).Fatal("Error replaying requests")
+		}
+		return
+	}
+
+	consumer, err := buildConsumer(*conf, queues, processor, logger)
 	if err != nil {
-		logger.WithError(err).Fatal("Error creating queue consumer group")
+		logger.WithError(err).Fatal("Error creating queue consumer")
 	}
 
 	// Build and start API.
-	api := api.NewReportsAPI(api.NewReportsService(logger, notifier, repositories))
+	// Admin, suppressions and preferences endpoints are only available
+	// if a token to protect them is configured. Admin endpoints also
+	// require a consumer that can be controlled.
+	var adminService *api.AdminService
+	if conf.API.AdminToken == "" {
+		logger.Warn("Admin, suppressions and preferences endpoints disabled, no admin token configured")
+	} else if controller, ok := consumer.(queue.Controller); ok {
+		adminService = api.NewAdminService(logger, controller, conf.API.AdminToken)
+	}
+	var reportTypes []model.ReportType
+	for t := range generateUCC {
+		reportTypes = append(reportTypes, t)
+	}
+	api := api.NewReportsAPI(api.NewReportsService(logger, notifier, repositories, deliveries, conf.SES.Recipients),
+		api.NewSuppressionsService(logger, suppressions), api.NewPreferencesService(logger, preferences, conf.Unsubscribe, reportTypes),
+		adminService, conf.API.AdminToken)
 	go api.Start(conf.API.Port)
 
-	// Start Consumer group.
+	// Processing is aborted on shutdown.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	var wg sync.WaitGroup
-	sqsConsumerGroup.Start(context.Background(), &wg)
+
+	// Build and start reaper.
+	if conf.Reaper.Enabled {
+		reaper, err := buildReaper(*conf, logger, db, generateUCC, repositories, processedMssgs)
+		if err != nil {
+			logger.WithError(err).Fatal("Error creating reaper")
+		}
+		reaper.Start(ctx, &wg)
+	}
+
+	// Build and start outbox dispatcher.
+	if conf.Outbox.Enabled {
+		dispatcher, err := report.NewDispatcher(logger, conf.Outbox, generateUCC, notifier, metricsClient,
+			publisher, deliveries, outbox)
+		if err != nil {
+			logger.WithError(err).Fatal("Error creating outbox dispatcher")
+		}
+		dispatcher.Start(ctx, &wg)
+	}
+
+	// Build and start SES feedback consumer.
+	if conf.Feedback.Enabled {
+		feedback, err := queue.NewSQSConsumerGroup([]queue.SQSQueueConfig{conf.Feedback.SQS},
+			report.NewSESFeedbackProcessor(logger, deliveries, suppressions), logger)
+		if err != nil {
+			logger.WithError(err).Fatal("Error creating SES feedback consumer")
+		}
+		feedback.Start(ctx, &wg)
+	}
+
+	// Start consumer.
+	consumer.Start(ctx, &wg)
 	logger.Info("Started")
 	wg.Wait()
 }
 
+func buildReaper(conf config, logger *log.Logger, db *sql.DB, generateUCC map[model.ReportType]report.GenerateUC,
+	repositories map[model.ReportType]storage.ReportsRepository, processedMssgs storage.ProcessedMessagesRepository) (*report.Reaper, error) {
+	var producer queue.Producer
+	if conf.Reaper.Action == report.ReaperActionRequeue {
+		p, err := buildProducer(conf)
+		if err != nil {
+			return nil, err
+		}
+		producer = p
+	}
+
+	return report.NewReaper(logger, conf.Reaper, generateUCC, repositories, processedMssgs,
+		producer, storage.NewPGAdvisoryLocker(db))
+}
+
+func buildConsumer(conf config, queues []queue.SQSQueueConfig, processor queue.Processor, logger *log.Logger) (queue.Consumer, error) {
+	switch conf.Queue.Backend {
+	case "", queue.BackendSQS:
+		return queue.NewSQSConsumerGroup(queues, processor, logger)
+	case queue.BackendSpool:
+		return queue.NewSpoolConsumer(conf.Queue.Spool, processor, logger)
+	default:
+		return nil, queue.ErrInvalidBackend
+	}
+}
+
+// buildNotifier builds the notifier for the configured backend.
+func buildNotifier(conf config, awsSess *session.Session) (notify.Notifier, error) {
+	switch conf.Notifier.Backend {
+	case "", notify.BackendSES:
+		if conf.SES.Region == "" {
+			conf.SES.Region = defRegion
+		}
+		n, err := notify.NewSESNotifier(conf.SES, ses.New(awsSess, &aws.Config{
+			Region: aws.String(conf.SES.Region),
+		}))
+		if err != nil {
+			return nil, err
+		}
+		return n, nil
+	case notify.BackendFile:
+		n, err := notify.NewFileNotifier(conf.Notifier.File)
+		if err != nil {
+			return nil, err
+		}
+		return n, nil
+	default:
+		return nil, notify.ErrInvalidConfig
+	}
+}
+
+// buildProducer builds the producer used to requeue requests.
+func buildProducer(conf config) (queue.Producer, error) {
+	if conf.Queue.Backend == queue.BackendSpool {
+		return queue.NewSpoolProducer(conf.Queue.Spool), nil
+	}
+
+	// Requests are requeued to the first queue that
+	// allows every report type. They are sent raw, so
+	// they would be rejected if SNS signatures are verified.
+	q, ok := conf.SQS.defaultQueue()
+	if !ok || q.SNSVerification.Enabled || q.Envelope == queue.EnvelopeSNS {
+		return nil, report.ErrInvalidReaperConfig
+	}
+	return queue.NewSQSProducer(q.SQSConfig)
+}
+
 func setupLogger(cfg config) *log.Logger {
 	var logger = log.New()
 
@@ -0,0 +1,112 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+	"github.com/adevinta/vulcan-reports-generator/pkg/queue"
+	"github.com/adevinta/vulcan-reports-generator/pkg/report"
+	"github.com/adevinta/vulcan-reports-generator/pkg/storage"
+)
+
+const replayCmd = "replay"
+
+var errInvalidReplaySource = errors.New("exactly one of -file, -dlq or -failed must be specified")
+
+// runReplay runs the replay subcommand, which feeds report generation
+// requests from a JSONL file, a DLQ or the failed requests stored in
+// the DB through the reports processor.
+func runReplay(ctx context.Context, args []string, logger *log.Logger, processor queue.Processor,
+	processedMssgs storage.ProcessedMessagesRepository) error {
+	flags := flag.NewFlagSet(replayCmd, flag.ContinueOnError)
+	file := flags.String("file", "", "JSONL file with a report generation request per line")
+	dlq := flags.String("dlq", "", "ARN of the dead letter queue to read the requests from")
+	failed := flags.Bool("failed", false, "replay the requests stored as failed or left unfinished in the DB")
+	staleAfter := flags.Duration("stale-after", time.Hour, "with -failed, also replay unfinished requests not updated for this long")
+	teamID := flags.String("team", "", "only replay requests for this team ID")
+	typ := flags.String("type", "", "only replay requests for this report type")
+	from := flags.String("from", "", "only replay requests sent after this time (RFC 3339)")
+	to := flags.String("to", "", "only replay requests sent before this time (RFC 3339)")
+	dryRun := flags.Bool("dry-run", false, "only log the requests that would be replayed")
+	rate := flags.Float64("rate", 0, "max requests replayed per second (0 means unlimited)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cfg := report.ReplayConfig{
+		TeamID: *teamID,
+		Type:   model.ReportType(*typ),
+		DryRun: *dryRun,
+		Rate:   *rate,
+	}
+	var err error
+	if cfg.From, err = parseReplayTime(*from); err != nil {
+		return err
+	}
+	if cfg.To, err = parseReplayTime(*to); err != nil {
+		return err
+	}
+
+	var source report.ReplaySource
+	var nSources int
+	if *file != "" {
+		nSources++
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		source = report.NewFileReplaySource(f)
+	}
+	if *dlq != "" {
+		nSources++
+		// Messages are read from the DLQ in the same way
+		// they were delivered to the source queue.
+		reader, err := queue.NewSQSReader(queue.SQSConfig{
+			QueueArn: *dlq,
+			Envelope: queue.EnvelopeAuto,
+		}, logger)
+		if err != nil {
+			return err
+		}
+		source = reader
+	}
+	if *failed {
+		nSources++
+		before := cfg.To
+		if before.IsZero() {
+			before = time.Now()
+		}
+		source = report.NewFailedReplaySource(processedMssgs, before, *staleAfter)
+	}
+	if nSources != 1 {
+		return errInvalidReplaySource
+	}
+
+	result, err := report.NewReplayer(logger, cfg, processor).Replay(ctx, source)
+	logger.WithFields(log.Fields{
+		"read":     result.Read,
+		"matched":  result.Matched,
+		"replayed": result.Replayed,
+		"failed":   result.Failed,
+		"dryRun":   cfg.DryRun,
+	}).Info("Replay finished")
+	return err
+}
+
+func parseReplayTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1 @@
+ALTER TABLE processed_messages ADD COLUMN locked_until TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS live_reports_status_update_status_at_idx ON live_reports (status, update_status_at);
@@ -0,0 +1 @@
+ALTER TABLE processed_messages ADD COLUMN notified TEXT[];
@@ -0,0 +1 @@
+ALTER TABLE notifications_outbox ADD COLUMN delivered_to TEXT[];
@@ -0,0 +1,4 @@
+ALTER TABLE processed_messages ADD COLUMN payload TEXT NOT NULL DEFAULT '';
+ALTER TABLE processed_messages ADD COLUMN requeues INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX processed_messages_stage_updated_at_idx ON processed_messages (stage, updated_at);
@@ -0,0 +1,11 @@
+CREATE TABLE report_revisions (
+    report_id TEXT NOT NULL,
+    revision INTEGER NOT NULL,
+    report_type TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL,
+    email_subject TEXT NOT NULL DEFAULT '',
+    email_body TEXT NOT NULL DEFAULT '',
+    delivered_to TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (report_id, revision)
+);
@@ -0,0 +1,15 @@
+CREATE TABLE report_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    report_id TEXT NOT NULL,
+    report_type TEXT NOT NULL DEFAULT '',
+    recipient TEXT NOT NULL,
+    channel TEXT NOT NULL,
+    provider_message_id TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX report_deliveries_report_id_idx ON report_deliveries (report_id);
+CREATE INDEX report_deliveries_provider_message_id_idx ON report_deliveries (provider_message_id);
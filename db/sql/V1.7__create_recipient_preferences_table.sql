@@ -0,0 +1,11 @@
+CREATE TABLE recipient_preferences (
+    email TEXT NOT NULL,
+    report_type TEXT NOT NULL,
+    unsubscribed BOOLEAN NOT NULL DEFAULT FALSE,
+    -- NULL keeps the format of the report.
+    format SMALLINT,
+    -- Empty for the default channel.
+    channel TEXT NOT NULL DEFAULT '',
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (email, report_type)
+);
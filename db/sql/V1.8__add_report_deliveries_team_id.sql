@@ -0,0 +1,4 @@
+ALTER TABLE report_deliveries ADD COLUMN team_id TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX report_deliveries_team_id_created_at_idx ON report_deliveries (team_id, created_at);
+CREATE INDEX report_deliveries_recipient_created_at_idx ON report_deliveries (lower(recipient), created_at);
@@ -0,0 +1,22 @@
+CREATE TABLE notifications_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    report_id TEXT NOT NULL,
+    report_type TEXT NOT NULL,
+    team_id TEXT NOT NULL DEFAULT '',
+    idempotency_key TEXT NOT NULL DEFAULT '',
+    subject TEXT NOT NULL,
+    body TEXT NOT NULL,
+    format SMALLINT NOT NULL,
+    recipients TEXT[] NOT NULL,
+    status TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- A report has at most one notification pending to be sent.
+CREATE UNIQUE INDEX notifications_outbox_pending_report_id_idx ON notifications_outbox (report_id) WHERE status = 'PENDING';
+CREATE INDEX notifications_outbox_pending_next_attempt_at_idx ON notifications_outbox (next_attempt_at, id) WHERE status = 'PENDING';
+CREATE INDEX notifications_outbox_report_id_idx ON notifications_outbox (report_id);
@@ -0,0 +1,124 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/queue"
+)
+
+const bearerPrefix = "Bearer "
+
+// AdminService represents the service layer
+// for the admin endpoints of the reports API.
+type AdminService struct {
+	log        *log.Logger
+	controller queue.Controller
+	token      string
+}
+
+// NewAdminService builds a new Admin API Service.
+// Requests must supply token as a bearer token.
+func NewAdminService(log *log.Logger, controller queue.Controller, token string) *AdminService {
+	return &AdminService{
+		log:        log,
+		controller: controller,
+		token:      token,
+	}
+}
+
+// Authorize is a middleware which checks the admin token.
+// Every request is rejected if the token is empty.
+func (s *AdminService) Authorize(next echo.HandlerFunc) echo.HandlerFunc {
+	return tokenAuth(s.token)(next)
+}
+
+// tokenAuth returns a middleware which checks that requests
+// supply the admin token as a bearer token. Every request
+// is rejected if the token is empty.
+func tokenAuth(adminToken string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			auth := c.Request().Header.Get(echo.HeaderAuthorization)
+			token := strings.TrimPrefix(auth, bearerPrefix)
+			if adminToken == "" || !strings.HasPrefix(auth, bearerPrefix) ||
+				subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+				return echo.NewHTTPError(http.StatusUnauthorized)
+			}
+			return next(c)
+		}
+	}
+}
+
+// GetConsumers returns the state of the queue consumption.
+func (s *AdminService) GetConsumers(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.consumersDTO())
+}
+
+// PauseConsumers pauses the queue consumption.
+func (s *AdminService) PauseConsumers(c echo.Context) error {
+	s.controller.Pause()
+	s.log.Warn("Queue consumption paused")
+	return c.JSON(http.StatusOK, s.consumersDTO())
+}
+
+// ResumeConsumers resumes the queue consumption.
+func (s *AdminService) ResumeConsumers(c echo.Context) error {
+	s.controller.Resume()
+	s.log.Warn("Queue consumption resumed")
+	return c.JSON(http.StatusOK, s.consumersDTO())
+}
+
+// SetQueueConsumers changes the number of consumers of a queue.
+func (s *AdminService) SetQueueConsumers(c echo.Context) error {
+	queueName := c.Param("queue")
+
+	req := SetConsumersReqDTO{}
+	if err := c.Bind(&req); err != nil || req.Consumers == nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity)
+	}
+
+	if err := s.controller.SetConsumers(queueName, *req.Consumers); err != nil {
+		if errors.Is(err, queue.ErrQueueNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound)
+		}
+		return err
+	}
+	s.log.WithFields(log.Fields{
+		"queue":     queueName,
+		"consumers": *req.Consumers,
+	}).Warn("Queue consumers changed")
+
+	return c.JSON(http.StatusOK, s.consumersDTO())
+}
+
+func (s *AdminService) consumersDTO() ConsumersDTO {
+	dto := ConsumersDTO{
+		Paused:    s.controller.Paused(),
+		Consumers: []ConsumerDTO{},
+	}
+	for _, state := range s.controller.State() {
+		consumer := ConsumerDTO{
+			ID:                state.ID,
+			Queue:             state.Queue,
+			Status:            state.Status,
+			LastError:         state.LastError,
+			MessagesProcessed: state.MessagesProcessed,
+		}
+		if !state.LastErrorAt.IsZero() {
+			lastErrorAt := state.LastErrorAt
+			consumer.LastErrorAt = &lastErrorAt
+		}
+		dto.Consumers = append(dto.Consumers, consumer)
+	}
+	return dto
+}
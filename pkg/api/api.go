@@ -21,22 +21,50 @@ const (
 	getReportPath      = "/reports/:type/:id"
 	getReportNotifPath = "/reports/:type/:id/notification"
 	sendReportPath     = "/reports/:type/:id/send"
+	getRevisionsPath   = "/reports/:type/:id/revisions"
+	getDeliveriesPath  = "/reports/:type/:id/deliveries"
+
+	suppressionsPath = "/suppressions"
+	suppressionPath  = "/suppressions/:email"
+
+	preferencesPath = "/preferences/:email"
+	preferencePath  = "/preferences/:email/:type"
+	unsubscribePath = "/unsubscribe"
+
+	adminPath           = "/admin"
+	consumersPath       = "/consumers"
+	pauseConsumersPath  = "/consumers/pause"
+	resumeConsumersPath = "/consumers/resume"
+	queueConsumersPath  = "/queues/:queue/consumers"
 
 	healthCheckEndpoint = "/healthcheck"
 )
 
 // ReportsAPI represents an API
 // to interact with reports.
+// The admin endpoints are only exposed if AdminService is set.
+// The suppressions and preferences endpoints are only exposed if
+// AdminToken is set, and requests must supply it as a bearer token.
+// The unsubscribe endpoints are public, as they verify their tokens.
 type ReportsAPI struct {
-	ReportsService *ReportsService
-	echo           *echo.Echo
+	ReportsService      *ReportsService
+	SuppressionsService *SuppressionsService
+	PreferencesService  *PreferencesService
+	AdminService        *AdminService
+	AdminToken          string
+	echo                *echo.Echo
 }
 
 // NewReportsAPI builds a new reports API.
-func NewReportsAPI(reportsService *ReportsService) *ReportsAPI {
+func NewReportsAPI(reportsService *ReportsService, suppressionsService *SuppressionsService,
+	preferencesService *PreferencesService, adminService *AdminService, adminToken string) *ReportsAPI {
 	return &ReportsAPI{
-		ReportsService: reportsService,
-		echo:           echo.New(),
+		ReportsService:      reportsService,
+		SuppressionsService: suppressionsService,
+		PreferencesService:  preferencesService,
+		AdminService:        adminService,
+		AdminToken:          adminToken,
+		echo:                echo.New(),
 	}
 }
 
@@ -51,6 +79,55 @@ func (a *ReportsAPI) Start(port int) error {
 	sendReportEndpoint := fmt.Sprintf(endpointFmt, api, version, sendReportPath)
 	a.echo.POST(sendReportEndpoint, a.ReportsService.SendReport)
 
+	// Get Report's revisions: GET /reports/{type}/{id}/revisions
+	getRevisionsEndpoint := fmt.Sprintf(endpointFmt, api, version, getRevisionsPath)
+	a.echo.GET(getRevisionsEndpoint, a.ReportsService.GetReportRevisions)
+
+	// Get Report's deliveries: GET /reports/{type}/{id}/deliveries
+	getDeliveriesEndpoint := fmt.Sprintf(endpointFmt, api, version, getDeliveriesPath)
+	a.echo.GET(getDeliveriesEndpoint, a.ReportsService.GetReportDeliveries)
+
+	// Suppressions and preferences: /suppressions/..., /preferences/...
+	if a.AdminToken != "" {
+		auth := tokenAuth(a.AdminToken)
+
+		// Get suppression list: GET /suppressions
+		suppressionsEndpoint := fmt.Sprintf(endpointFmt, api, version, suppressionsPath)
+		a.echo.GET(suppressionsEndpoint, a.SuppressionsService.GetSuppressions, auth)
+		// Add to suppression list: POST /suppressions
+		a.echo.POST(suppressionsEndpoint, a.SuppressionsService.AddSuppression, auth)
+		// Remove from suppression list: DELETE /suppressions/{email}
+		suppressionEndpoint := fmt.Sprintf(endpointFmt, api, version, suppressionPath)
+		a.echo.DELETE(suppressionEndpoint, a.SuppressionsService.DeleteSuppression, auth)
+
+		// Get recipient preferences: GET /preferences/{email}
+		preferencesEndpoint := fmt.Sprintf(endpointFmt, api, version, preferencesPath)
+		a.echo.GET(preferencesEndpoint, a.PreferencesService.GetPreferences, auth)
+		// Set recipient preference: PUT /preferences/{email}/{type}
+		preferenceEndpoint := fmt.Sprintf(endpointFmt, api, version, preferencePath)
+		a.echo.PUT(preferenceEndpoint, a.PreferencesService.SetPreference, auth)
+	}
+
+	// Unsubscribe confirmation page: GET /unsubscribe?token={token}
+	unsubscribeEndpoint := fmt.Sprintf(endpointFmt, api, version, unsubscribePath)
+	a.echo.GET(unsubscribeEndpoint, a.PreferencesService.UnsubscribePage)
+	// Unsubscribe, also RFC 8058 one-click: POST /unsubscribe?token={token}
+	a.echo.POST(unsubscribeEndpoint, a.PreferencesService.Unsubscribe)
+
+	// Admin: /admin/...
+	if a.AdminService != nil {
+		admin := a.echo.Group(fmt.Sprintf(endpointFmt, api, version, adminPath), a.AdminService.Authorize)
+
+		// Get consumers state: GET /admin/consumers
+		admin.GET(consumersPath, a.AdminService.GetConsumers)
+		// Pause consumption: POST /admin/consumers/pause
+		admin.POST(pauseConsumersPath, a.AdminService.PauseConsumers)
+		// Resume consumption: POST /admin/consumers/resume
+		admin.POST(resumeConsumersPath, a.AdminService.ResumeConsumers)
+		// Set queue consumers: PUT /admin/queues/{queue}/consumers
+		admin.PUT(queueConsumersPath, a.AdminService.SetQueueConsumers)
+	}
+
 	// Healthcheck
 	a.echo.GET(healthCheckEndpoint, a.ReportsService.HealthCheck)
 
@@ -6,6 +6,7 @@ package api
 
 import (
 	"errors"
+	"time"
 )
 
 var (
@@ -18,6 +19,20 @@ type SendReportReqDTO struct {
 	Recipients []string `json:"recipients"`
 }
 
+// InvalidRecipientsDTO represents the error response
+// DTO for the Send Report endpoint when recipients are
+// not valid. Errors holds the error of each recipient.
+type InvalidRecipientsDTO struct {
+	Message string              `json:"message"`
+	Errors  []RecipientErrorDTO `json:"errors"`
+}
+
+// RecipientErrorDTO represents the error of a recipient.
+type RecipientErrorDTO struct {
+	Recipient string `json:"recipient"`
+	Reason    string `json:"reason"`
+}
+
 // ReportNotificationDTO represents the response DTO
 // for the Get Report's Notification endpoint.
 type ReportNotificationDTO struct {
@@ -25,3 +40,108 @@ type ReportNotificationDTO struct {
 	Body    string `json:"body"`
 	Format  string `json:"format"`
 }
+
+// ReportRevisionsDTO represents the response DTO
+// for the Get Report's Revisions endpoint.
+type ReportRevisionsDTO struct {
+	Revisions []ReportRevisionDTO `json:"revisions"`
+}
+
+// ReportRevisionDTO represents a revision of a report.
+type ReportRevisionDTO struct {
+	Revision   int       `json:"revision"`
+	Status     string    `json:"status"`
+	Subject    string    `json:"subject"`
+	Body       string    `json:"body"`
+	Format     string    `json:"format"`
+	Recipients []string  `json:"recipients"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// DeliveriesDTO represents the response DTO
+// for the Get Report's Deliveries endpoint.
+type DeliveriesDTO struct {
+	Deliveries []DeliveryDTO `json:"deliveries"`
+}
+
+// DeliveryDTO represents the delivery
+// of a report to a recipient.
+type DeliveryDTO struct {
+	Recipient         string    `json:"recipient"`
+	Channel           string    `json:"channel"`
+	ProviderMessageID string    `json:"provider_message_id,omitempty"`
+	Status            string    `json:"status"`
+	Error             string    `json:"error,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// AddSuppressionReqDTO represents the DTO
+// for the Add Suppression endpoint payload.
+type AddSuppressionReqDTO struct {
+	Email  string `json:"email"`
+	Detail string `json:"detail"`
+}
+
+// SuppressionsDTO represents the response DTO
+// for the Get Suppressions endpoint.
+type SuppressionsDTO struct {
+	Suppressions []SuppressionDTO `json:"suppressions"`
+}
+
+// SuppressionDTO represents a recipient
+// in the suppression list.
+type SuppressionDTO struct {
+	Email             string    `json:"email"`
+	Reason            string    `json:"reason"`
+	Detail            string    `json:"detail,omitempty"`
+	ProviderMessageID string    `json:"provider_message_id,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// SetPreferenceReqDTO represents the DTO
+// for the Set Preference endpoint payload.
+type SetPreferenceReqDTO struct {
+	Unsubscribed bool   `json:"unsubscribed"`
+	Format       string `json:"format"`
+	Channel      string `json:"channel"`
+}
+
+// PreferencesDTO represents the response DTO
+// for the Get Preferences endpoint.
+type PreferencesDTO struct {
+	Preferences []PreferenceDTO `json:"preferences"`
+}
+
+// PreferenceDTO represents the preference
+// of a recipient for a report type.
+type PreferenceDTO struct {
+	ReportType   string    `json:"report_type"`
+	Unsubscribed bool      `json:"unsubscribed"`
+	Format       string    `json:"format,omitempty"`
+	Channel      string    `json:"channel,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// SetConsumersReqDTO represents the DTO for
+// the Set Queue Consumers endpoint payload.
+type SetConsumersReqDTO struct {
+	Consumers *uint8 `json:"consumers"`
+}
+
+// ConsumersDTO represents the response DTO
+// for the admin consumers endpoints.
+type ConsumersDTO struct {
+	Paused    bool          `json:"paused"`
+	Consumers []ConsumerDTO `json:"consumers"`
+}
+
+// ConsumerDTO represents the state of a queue consumer.
+type ConsumerDTO struct {
+	ID                int        `json:"id"`
+	Queue             string     `json:"queue"`
+	Status            string     `json:"status"`
+	LastError         string     `json:"last_error,omitempty"`
+	LastErrorAt       *time.Time `json:"last_error_at,omitempty"`
+	MessagesProcessed uint64     `json:"messages_processed"`
+}
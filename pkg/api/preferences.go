@@ -0,0 +1,171 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package api
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/mail"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+	"github.com/adevinta/vulcan-reports-generator/pkg/notify"
+	"github.com/adevinta/vulcan-reports-generator/pkg/storage"
+)
+
+const (
+	unsubscribePageFmt = `<!DOCTYPE html>
+<html>
+<head><meta charset="UTF-8"><title>Unsubscribe</title></head>
+<body>%s</body>
+</html>
+`
+	unsubscribeFormFmt = `<p>Unsubscribe %s from the %s emails?</p>
+<form method="POST"><button type="submit">Unsubscribe</button></form>`
+	unsubscribedFmt = `<p>%s has been unsubscribed from the %s emails.</p>`
+)
+
+// PreferencesService represents the service layer for the
+// recipient preferences endpoints of the reports API.
+type PreferencesService struct {
+	log         *log.Logger
+	preferences storage.PreferencesRepository
+	unsubscribe notify.UnsubscribeConfig
+	reportTypes map[model.ReportType]bool
+}
+
+// NewPreferencesService builds a new Preferences API Service.
+// The unsubscribe config is used to verify the unsubscribe tokens,
+// and preferences can only be set for the given report types.
+func NewPreferencesService(log *log.Logger, preferences storage.PreferencesRepository,
+	unsubscribe notify.UnsubscribeConfig, reportTypes []model.ReportType) *PreferencesService {
+	types := map[model.ReportType]bool{}
+	for _, t := range reportTypes {
+		types[t] = true
+	}
+	return &PreferencesService{
+		log:         log,
+		preferences: preferences,
+		unsubscribe: unsubscribe,
+		reportTypes: types,
+	}
+}
+
+// GetPreferences returns the preferences of the recipient for every report type.
+func (s *PreferencesService) GetPreferences(c echo.Context) error {
+	prefs, err := s.preferences.GetRecipientPreferences(c.Request().Context(), c.Param("email"))
+	if err != nil {
+		return err
+	}
+
+	respDTO := PreferencesDTO{Preferences: []PreferenceDTO{}}
+	for _, p := range prefs {
+		dto := PreferenceDTO{
+			ReportType:   string(p.ReportType),
+			Unsubscribed: p.Unsubscribed,
+			Channel:      p.Channel,
+			UpdatedAt:    p.UpdatedAt,
+		}
+		if p.HasFmt {
+			dto.Format = notifFmts[p.Fmt]
+		}
+		respDTO.Preferences = append(respDTO.Preferences, dto)
+	}
+
+	return c.JSON(http.StatusOK, respDTO)
+}
+
+// SetPreference sets the preference of the recipient for the report type.
+func (s *PreferencesService) SetPreference(c echo.Context) error {
+	email := c.Param("email")
+	typ := model.ReportType(c.Param("type"))
+	if !s.reportTypes[typ] {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, unSupportedReportType)
+	}
+
+	req := SetPreferenceReqDTO{}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity)
+	}
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "invalid email")
+	}
+
+	pref := model.Preference{
+		Email:        email,
+		ReportType:   typ,
+		Unsubscribed: req.Unsubscribed,
+		Channel:      req.Channel,
+		UpdatedAt:    time.Now(),
+	}
+	if req.Format != "" {
+		notifFmt, ok := parseNotifFmt(req.Format)
+		if !ok {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "invalid format")
+		}
+		pref.HasFmt, pref.Fmt = true, notifFmt
+	}
+	if req.Channel != "" && req.Channel != model.DeliveryChannelEmail {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "invalid channel")
+	}
+
+	if err := s.preferences.SavePreference(c.Request().Context(), pref); err != nil {
+		return err
+	}
+	s.log.WithFields(log.Fields{
+		"recipient": email,
+		"type":      typ,
+	}).Info("Recipient preference updated")
+
+	return c.String(http.StatusOK, okResp)
+}
+
+// UnsubscribePage returns the page to confirm the unsubscription
+// of the recipient of the unsubscribe link. The recipient is not
+// unsubscribed on GET, as links are opened by mail scanners.
+func (s *PreferencesService) UnsubscribePage(c echo.Context) error {
+	email, typ, err := s.unsubscribe.ParseToken(c.QueryParam("token"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	form := fmt.Sprintf(unsubscribeFormFmt, html.EscapeString(email), html.EscapeString(string(typ)))
+	return c.HTML(http.StatusOK, fmt.Sprintf(unsubscribePageFmt, form))
+}
+
+// Unsubscribe unsubscribes the recipient of the unsubscribe link
+// from the report type. It is also the target of the RFC 8058
+// one-click unsubscribe requests sent by the email clients.
+func (s *PreferencesService) Unsubscribe(c echo.Context) error {
+	email, typ, err := s.unsubscribe.ParseToken(c.QueryParam("token"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err := s.preferences.Unsubscribe(c.Request().Context(), email, typ); err != nil {
+		return err
+	}
+	s.log.WithFields(log.Fields{
+		"recipient": email,
+		"type":      typ,
+	}).Info("Recipient unsubscribed")
+
+	mssg := fmt.Sprintf(unsubscribedFmt, html.EscapeString(email), html.EscapeString(string(typ)))
+	return c.HTML(http.StatusOK, fmt.Sprintf(unsubscribePageFmt, mssg))
+}
+
+// parseNotifFmt returns the notification format for its name.
+func parseNotifFmt(name string) (model.NotifFmt, bool) {
+	for f, n := range notifFmts {
+		if n == name {
+			return f, true
+		}
+	}
+	return 0, false
+}
@@ -7,6 +7,7 @@ package api
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
@@ -14,6 +15,7 @@ import (
 
 	"github.com/adevinta/vulcan-reports-generator/pkg/model"
 	"github.com/adevinta/vulcan-reports-generator/pkg/notify"
+	"github.com/adevinta/vulcan-reports-generator/pkg/report"
 	"github.com/adevinta/vulcan-reports-generator/pkg/storage"
 )
 
@@ -35,15 +37,22 @@ type ReportsService struct {
 	log          *log.Logger
 	notifier     notify.Notifier
 	repositories map[model.ReportType]storage.ReportsRepository
+	deliveries   storage.DeliveriesRepository
+	recipients   notify.RecipientsConfig
 }
 
 // NewReportsService builds a new Reports API Service.
+// The recipients of the send requests are validated
+// against the restrictions of the recipients config.
 func NewReportsService(log *log.Logger, notifier notify.Notifier,
-	repositories map[model.ReportType]storage.ReportsRepository) *ReportsService {
+	repositories map[model.ReportType]storage.ReportsRepository, deliveries storage.DeliveriesRepository,
+	recipients notify.RecipientsConfig) *ReportsService {
 	return &ReportsService{
 		log:          log,
 		notifier:     notifier,
 		repositories: repositories,
+		deliveries:   deliveries,
+		recipients:   recipients,
 	}
 }
 
@@ -52,7 +61,7 @@ func (s *ReportsService) GetReportNotification(c echo.Context) error {
 	id := c.Param("id")
 	typ := model.ReportType(c.Param("type"))
 
-	ctx := context.Background()
+	ctx := c.Request().Context()
 
 	r, ok := s.repositories[typ]
 	if !ok {
@@ -76,17 +85,104 @@ func (s *ReportsService) GetReportNotification(c echo.Context) error {
 	return c.JSON(http.StatusOK, respDTO)
 }
 
+// GetReportRevisions returns the revisions of the report for the specified type and id.
+func (s *ReportsService) GetReportRevisions(c echo.Context) error {
+	id := c.Param("id")
+	typ := model.ReportType(c.Param("type"))
+
+	ctx := c.Request().Context()
+
+	r, ok := s.repositories[typ]
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, unSupportedReportType)
+	}
+
+	if _, err := r.GetReport(ctx, id); err != nil {
+		if errors.Is(err, storage.ErrReportNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound)
+		}
+		return err
+	}
+
+	revisions, err := r.GetReportRevisions(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	respDTO := ReportRevisionsDTO{Revisions: []ReportRevisionDTO{}}
+	for _, rev := range revisions {
+		recipients := rev.DeliveredTo
+		if recipients == nil {
+			recipients = []string{}
+		}
+		respDTO.Revisions = append(respDTO.Revisions, ReportRevisionDTO{
+			Revision:   rev.Revision,
+			Status:     rev.Status,
+			Subject:    rev.Notification.Subject,
+			Body:       rev.Notification.Body,
+			Format:     notifFmts[rev.Notification.Fmt],
+			Recipients: recipients,
+			CreatedAt:  rev.CreatedAt,
+		})
+	}
+
+	return c.JSON(http.StatusOK, respDTO)
+}
+
+// GetReportDeliveries returns the deliveries of the report for the specified type and id.
+func (s *ReportsService) GetReportDeliveries(c echo.Context) error {
+	id := c.Param("id")
+	typ := model.ReportType(c.Param("type"))
+
+	ctx := c.Request().Context()
+
+	r, ok := s.repositories[typ]
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, unSupportedReportType)
+	}
+
+	if _, err := r.GetReport(ctx, id); err != nil {
+		if errors.Is(err, storage.ErrReportNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound)
+		}
+		return err
+	}
+
+	deliveries, err := s.deliveries.GetDeliveries(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	respDTO := DeliveriesDTO{Deliveries: []DeliveryDTO{}}
+	for _, d := range deliveries {
+		respDTO.Deliveries = append(respDTO.Deliveries, DeliveryDTO{
+			Recipient:         d.Recipient,
+			Channel:           d.Channel,
+			ProviderMessageID: d.ProviderMessageID,
+			Status:            d.Status,
+			Error:             d.Error,
+			CreatedAt:         d.CreatedAt,
+			UpdatedAt:         d.UpdatedAt,
+		})
+	}
+
+	return c.JSON(http.StatusOK, respDTO)
+}
+
 // SendReport sends the report notification for the specified report type and id.
 func (s *ReportsService) SendReport(c echo.Context) error {
 	id := c.Param("id")
 	typ := model.ReportType(c.Param("type"))
 
-	ctx := context.Background()
+	ctx := c.Request().Context()
 
 	req := SendReportReqDTO{}
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusUnprocessableEntity)
 	}
+	if err := s.recipients.ValidateRecipients(req.Recipients); err != nil {
+		return invalidRecipientsError(err)
+	}
 
 	r, ok := s.repositories[typ]
 	if !ok {
@@ -101,22 +197,109 @@ func (s *ReportsService) SendReport(c echo.Context) error {
 		return err
 	}
 
-	if report.GetStatus() == model.StatusFailed {
+	switch status := report.GetStatus(); {
+	case status == model.StatusGenerating:
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "report is being generated")
+	case !model.IsGenerated(status):
 		return echo.NewHTTPError(http.StatusUnprocessableEntity, "report generation failed")
 	}
-	if report.GetStatus() == model.StatusGenerating {
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, "report is being generated")
+
+	if err = s.updateStatus(ctx, r, id, model.StatusSending, nil); err != nil {
+		return statusError(err)
 	}
 
-	notif := report.GetNotification()
-	err = s.notifier.Notify(notif.Subject, notif.Body, notif.Fmt, req.Recipients)
+	// Only the reports bound to a team, e.g.: live reports,
+	// count against the team cap.
+	var teamID string
+	if teamReport, ok := report.(interface{ GetTeamID() string }); ok {
+		teamID = teamReport.GetTeamID()
+	}
+	notif := notify.Notification{
+		ReportType: typ,
+		TeamID:     teamID,
+		Subject:    report.GetNotification().Subject,
+		Body:       report.GetNotification().Body,
+		Fmt:        report.GetNotification().Fmt,
+		Recipients: req.Recipients,
+	}
+	res, err := s.notifier.Notify(ctx, notif)
+	deliveries := res.Deliveries(id, notif, err)
+	if saveErr := s.deliveries.SaveDeliveries(context.WithoutCancel(ctx), deliveries); saveErr != nil {
+		s.log.WithError(saveErr).WithField("reportID", id).Error("Error saving report deliveries")
+	}
 	if err != nil {
+		// The failure is recorded even if the request was canceled.
+		if updateErr := s.updateStatus(context.WithoutCancel(ctx), r, id, model.StatusSendFailed, nil); updateErr != nil {
+			s.log.WithError(updateErr).WithField("reportID", id).Error("Error updating report status")
+		}
+		if errors.Is(err, notify.ErrThrottled) || errors.Is(err, notify.ErrDailyCapExceeded) {
+			return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+		}
+		if errors.Is(err, notify.ErrInvalidRecipients) {
+			return invalidRecipientsError(err)
+		}
 		return err
 	}
 
+	if err = s.updateStatus(ctx, r, id, model.StatusSent, res.DeliveredTo(notif.Recipients)); err != nil {
+		return statusError(err)
+	}
+
 	return c.String(http.StatusOK, okResp)
 }
 
+// invalidRecipientsError returns the HTTP error for the
+// invalid recipients, detailing the error of each one.
+func invalidRecipientsError(err error) error {
+	respDTO := InvalidRecipientsDTO{
+		Message: err.Error(),
+		Errors:  []RecipientErrorDTO{},
+	}
+	var rerr *notify.RecipientsError
+	if errors.As(err, &rerr) {
+		respDTO.Message = notify.ErrInvalidRecipients.Error()
+		if rerr.Reason != "" {
+			respDTO.Message = fmt.Sprintf("%v: %s", notify.ErrInvalidRecipients, rerr.Reason)
+		}
+		for _, e := range rerr.Errors {
+			respDTO.Errors = append(respDTO.Errors, RecipientErrorDTO{
+				Recipient: e.Recipient,
+				Reason:    e.Reason,
+			})
+		}
+	}
+	return echo.NewHTTPError(http.StatusUnprocessableEntity, respDTO)
+}
+
+// updateStatus transitions the report to the specified status and saves it,
+// recording deliveredTo if it is sent. The report is locked while its
+// current status is checked and updated, so concurrent changes are kept.
+func (s *ReportsService) updateStatus(ctx context.Context, r storage.ReportsRepository, id, status string, deliveredTo []string) error {
+	return r.Transact(ctx, func(r storage.ReportsRepository) error {
+		current, err := r.GetReport(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !model.IsValidTransition(current.GetStatus(), status) {
+			return fmt.Errorf("%w: from %s to %s", report.ErrInvalidStatusTransition, current.GetStatus(), status)
+		}
+		current.SetStatus(status)
+		if status == model.StatusSent {
+			current.SetDeliveredTo(deliveredTo)
+		}
+		return r.SaveReport(ctx, current)
+	})
+}
+
+// statusError returns the HTTP error for the error updating the report
+// status, a conflict if the report can not transition to the status.
+func statusError(err error) error {
+	if errors.Is(err, report.ErrInvalidStatusTransition) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	return err
+}
+
 // HealthCheck is the service handler for healthcheck queries.
 func (s *ReportsService) HealthCheck(c echo.Context) error {
 	return c.String(http.StatusOK, okResp)
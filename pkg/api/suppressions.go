@@ -0,0 +1,93 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/mail"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+	"github.com/adevinta/vulcan-reports-generator/pkg/storage"
+)
+
+// SuppressionsService represents the service layer
+// for the suppression list endpoints of the reports API.
+type SuppressionsService struct {
+	log          *log.Logger
+	suppressions storage.SuppressionsRepository
+}
+
+// NewSuppressionsService builds a new Suppressions API Service.
+func NewSuppressionsService(log *log.Logger, suppressions storage.SuppressionsRepository) *SuppressionsService {
+	return &SuppressionsService{
+		log:          log,
+		suppressions: suppressions,
+	}
+}
+
+// GetSuppressions returns the recipients in the suppression list.
+func (s *SuppressionsService) GetSuppressions(c echo.Context) error {
+	suppressions, err := s.suppressions.GetSuppressions(c.Request().Context())
+	if err != nil {
+		return err
+	}
+
+	respDTO := SuppressionsDTO{Suppressions: []SuppressionDTO{}}
+	for _, sup := range suppressions {
+		respDTO.Suppressions = append(respDTO.Suppressions, SuppressionDTO{
+			Email:             sup.Email,
+			Reason:            sup.Reason,
+			Detail:            sup.Detail,
+			ProviderMessageID: sup.ProviderMessageID,
+			CreatedAt:         sup.CreatedAt,
+		})
+	}
+
+	return c.JSON(http.StatusOK, respDTO)
+}
+
+// AddSuppression adds a recipient to the suppression list.
+func (s *SuppressionsService) AddSuppression(c echo.Context) error {
+	req := AddSuppressionReqDTO{}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity)
+	}
+	if addr, err := mail.ParseAddress(req.Email); err != nil || addr.Address != req.Email {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "invalid email")
+	}
+
+	err := s.suppressions.SaveSuppression(c.Request().Context(), model.Suppression{
+		Email:     req.Email,
+		Reason:    model.SuppressionReasonManual,
+		Detail:    req.Detail,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	s.log.WithField("recipient", req.Email).Warn("Recipient added to the suppression list")
+
+	return c.String(http.StatusOK, okResp)
+}
+
+// DeleteSuppression removes a recipient from the suppression list.
+func (s *SuppressionsService) DeleteSuppression(c echo.Context) error {
+	email := c.Param("email")
+
+	if err := s.suppressions.DeleteSuppression(c.Request().Context(), email); err != nil {
+		if errors.Is(err, storage.ErrSuppressionNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound)
+		}
+		return err
+	}
+	s.log.WithField("recipient", email).Warn("Recipient removed from the suppression list")
+
+	return c.String(http.StatusOK, okResp)
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+const (
+	// SpecVersion is the CloudEvents spec version of the events.
+	SpecVersion = "1.0"
+
+	// Source is the source of the events.
+	Source = "vulcan-reports-generator"
+
+	// TypeReportGenerated indicates that a report was generated.
+	TypeReportGenerated = "com.adevinta.vulcan.report.generated"
+	// TypeReportGenerationFailed indicates that a report could not be generated.
+	TypeReportGenerationFailed = "com.adevinta.vulcan.report.generation_failed"
+	// TypeReportSent indicates that a report notification was sent.
+	TypeReportSent = "com.adevinta.vulcan.report.sent"
+	// TypeReportSendFailed indicates that a report notification could not be sent.
+	TypeReportSendFailed = "com.adevinta.vulcan.report.send_failed"
+
+	contentTypeJSON = "application/json"
+)
+
+// Event represents a report lifecycle
+// event in CloudEvents JSON format.
+type Event struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Subject         string      `json:"subject,omitempty"`
+	Time            time.Time   `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	Data            interface{} `json:"data"`
+}
+
+// ReportData is the data of the report lifecycle events.
+type ReportData struct {
+	ReportID       string   `json:"report_id,omitempty"`
+	ReportType     string   `json:"report_type"`
+	TeamID         string   `json:"team_id"`
+	Status         string   `json:"status,omitempty"`
+	Recipients     []string `json:"recipients,omitempty"`
+	IdempotencyKey string   `json:"idempotency_key,omitempty"`
+	Error          string   `json:"error,omitempty"`
+}
+
+// Publisher represents a sink for the report lifecycle events.
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// NewEvent returns a new event with a random ID. The subject
+// identifies the report the event is about, if known.
+func NewEvent(typ, subject string, data interface{}) Event {
+	return Event{
+		SpecVersion:     SpecVersion,
+		ID:              newID(),
+		Source:          Source,
+		Type:            typ,
+		Subject:         subject,
+		Time:            time.Now().UTC(),
+		DataContentType: contentTypeJSON,
+		Data:            data,
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	// Read only fails if the system RNG is unavailable.
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
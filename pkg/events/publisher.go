@@ -0,0 +1,205 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/queue"
+)
+
+const (
+	// SinkNone disables the publication of events.
+	SinkNone = ""
+	// SinkSNS publishes the events to an SNS topic.
+	SinkSNS = "sns"
+	// SinkSQS sends the events to an SQS queue.
+	SinkSQS = "sqs"
+	// SinkFile appends the events to a local file, one per line.
+	SinkFile = "file"
+	// SinkHTTP posts the events to an HTTP endpoint.
+	SinkHTTP = "http"
+
+	// typeAttr is the message attribute holding the event
+	// type, so subscriptions can filter on it.
+	typeAttr = "type"
+
+	contentTypeCloudEvents = "application/cloudevents+json"
+
+	defHTTPTimeout = 10
+)
+
+var (
+	// ErrInvalidSink indicates that the events sink is not supported or not properly configured.
+	ErrInvalidSink = errors.New("Invalid events sink")
+	// ErrUnexpectedStatus indicates that the HTTP sink responded with a non 2xx status code.
+	ErrUnexpectedStatus = errors.New("Unexpected HTTP sink response status")
+)
+
+// Config is the configuration for the events publisher.
+//
+//   - Sink is one of: sns, sqs, file or http.
+//     If empty, events are not published.
+//   - Timeout is the HTTP sink timeout, in seconds.
+type Config struct {
+	Sink    string          `toml:"sink"`
+	SNS     queue.SNSConfig `toml:"sns"`
+	SQS     queue.SQSConfig `toml:"sqs"`
+	File    string          `toml:"file"`
+	URL     string          `toml:"url"`
+	Timeout int64           `toml:"timeout"`
+}
+
+// NewPublisher builds the publisher for the configured sink.
+// If no sink is configured, nil is returned.
+func NewPublisher(cfg Config) (Publisher, error) {
+	switch cfg.Sink {
+	case SinkNone:
+		return nil, nil
+	case SinkSNS:
+		p, err := queue.NewSNSProducer(cfg.SNS)
+		if err != nil {
+			return nil, err
+		}
+		return NewProducerPublisher(p), nil
+	case SinkSQS:
+		p, err := queue.NewSQSProducer(cfg.SQS)
+		if err != nil {
+			return nil, err
+		}
+		return NewProducerPublisher(p), nil
+	case SinkFile:
+		if cfg.File == "" {
+			return nil, fmt.Errorf("%w: file is required", ErrInvalidSink)
+		}
+		return NewFilePublisher(cfg.File), nil
+	case SinkHTTP:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("%w: url is required", ErrInvalidSink)
+		}
+		timeout := int64(defHTTPTimeout)
+		if cfg.Timeout > 0 {
+			timeout = cfg.Timeout
+		}
+		return NewHTTPPublisher(cfg.URL, &http.Client{Timeout: time.Duration(timeout) * time.Second}), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSink, cfg.Sink)
+	}
+}
+
+// ProducerPublisher publishes the events through a queue producer,
+// e.g.: an SNS topic or an SQS queue. The event type is set as
+// message attribute and the event ID as deduplication ID.
+// For FIFO queues and topics, the events of the same report
+// are ordered through its ID as message group.
+type ProducerPublisher struct {
+	producer queue.Producer
+}
+
+// NewProducerPublisher creates a new ProducerPublisher.
+func NewProducerPublisher(producer queue.Producer) *ProducerPublisher {
+	return &ProducerPublisher{producer: producer}
+}
+
+// Publish sends the event to the queue.
+func (p *ProducerPublisher) Publish(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return p.producer.Send(ctx, queue.Message{
+		ID:         event.ID,
+		Body:       string(body),
+		Attributes: map[string]string{typeAttr: event.Type},
+		GroupID:    groupID(event),
+	})
+}
+
+func groupID(event Event) string {
+	if event.Subject != "" {
+		return event.Subject
+	}
+	return event.Source
+}
+
+// FilePublisher appends the events to a local file in JSONL format.
+type FilePublisher struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFilePublisher creates a new FilePublisher.
+func NewFilePublisher(path string) *FilePublisher {
+	return &FilePublisher{path: path}
+}
+
+// Publish appends the event to the file.
+func (p *FilePublisher) Publish(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := os.OpenFile(p.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(body, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// HTTPPublisher posts the events to an HTTP
+// endpoint in CloudEvents structured mode.
+type HTTPPublisher struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPPublisher creates a new HTTPPublisher.
+func NewHTTPPublisher(url string, client *http.Client) *HTTPPublisher {
+	return &HTTPPublisher{
+		url:    url,
+		client: client,
+	}
+}
+
+// Publish posts the event to the endpoint.
+func (p *HTTPPublisher) Publish(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentTypeCloudEvents)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: %d", ErrUnexpectedStatus, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/queue"
+)
+
+type mockProducer struct {
+	queue.Producer
+	sent []queue.Message
+}
+
+func (p *mockProducer) Send(ctx context.Context, mssg queue.Message) error {
+	p.sent = append(p.sent, mssg)
+	return nil
+}
+
+func TestProducerPublisher(t *testing.T) {
+	testCases := []struct {
+		name            string
+		event           Event
+		expectedGroupID string
+	}{
+		{
+			name:            "Should group events by report",
+			event:           NewEvent(TypeReportSent, "report-1", ReportData{ReportID: "report-1"}),
+			expectedGroupID: "report-1",
+		},
+		{
+			name:            "Should group events without report by source",
+			event:           NewEvent(TypeReportGenerationFailed, "", ReportData{TeamID: "1"}),
+			expectedGroupID: Source,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			producer := &mockProducer{}
+			if err := NewProducerPublisher(producer).Publish(context.Background(), tc.event); err != nil {
+				t.Fatalf("Expected no error, but got: %v", err)
+			}
+
+			if len(producer.sent) != 1 {
+				t.Fatalf("Expected sent messages to be: %d\nBut got: %d", 1, len(producer.sent))
+			}
+			mssg := producer.sent[0]
+			if mssg.ID != tc.event.ID || mssg.GroupID != tc.expectedGroupID || mssg.Attributes[typeAttr] != tc.event.Type {
+				t.Fatalf("Unexpected message: %+v", mssg)
+			}
+			var event map[string]interface{}
+			if err := json.Unmarshal([]byte(mssg.Body), &event); err != nil {
+				t.Fatalf("Error decoding message body: %v", err)
+			}
+			if event["specversion"] != SpecVersion || event["type"] != tc.event.Type || event["id"] != tc.event.ID {
+				t.Fatalf("Unexpected event: %v", event)
+			}
+		})
+	}
+}
+
+func TestFilePublisher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	publisher := NewFilePublisher(path)
+
+	published := []Event{
+		NewEvent(TypeReportGenerated, "report-1", ReportData{ReportID: "report-1"}),
+		NewEvent(TypeReportSent, "report-1", ReportData{ReportID: "report-1"}),
+	}
+	for _, e := range published {
+		if err := publisher.Publish(context.Background(), e); err != nil {
+			t.Fatalf("Expected no error, but got: %v", err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Error opening events file: %v", err)
+	}
+	defer f.Close()
+
+	var ids []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("Error decoding event: %v", err)
+		}
+		ids = append(ids, e.ID)
+	}
+	expectedIDs := []string{published[0].ID, published[1].ID}
+	if !reflect.DeepEqual(ids, expectedIDs) {
+		t.Fatalf("Expected event IDs to be: %v\nBut got: %v", expectedIDs, ids)
+	}
+}
+
+func TestHTTPPublisher(t *testing.T) {
+	testCases := []struct {
+		name        string
+		status      int
+		expectedErr error
+	}{
+		{
+			name:   "Should post event",
+			status: http.StatusAccepted,
+		},
+		{
+			name:        "Should return err for non 2xx status",
+			status:      http.StatusInternalServerError,
+			expectedErr: ErrUnexpectedStatus,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				contentType string
+				received    Event
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				contentType = r.Header.Get("Content-Type")
+				json.NewDecoder(r.Body).Decode(&received)
+				w.WriteHeader(tc.status)
+			}))
+			defer srv.Close()
+
+			event := NewEvent(TypeReportSendFailed, "report-1", ReportData{ReportID: "report-1", Error: "ErrNotify"})
+			err := NewHTTPPublisher(srv.URL, srv.Client()).Publish(context.Background(), event)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected err: %v\nBut got: %v", tc.expectedErr, err)
+			}
+			if contentType != contentTypeCloudEvents {
+				t.Fatalf("Expected content type to be: %s\nBut got: %s", contentTypeCloudEvents, contentType)
+			}
+			if received.ID != event.ID || received.Type != event.Type {
+				t.Fatalf("Unexpected received event: %+v", received)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package model
+
+import "time"
+
+const (
+	// DeliveryChannelEmail identifies the deliveries sent by email.
+	DeliveryChannelEmail = "email"
+
+	// DeliveryStatusSent indicates that the notification was accepted by the provider.
+	DeliveryStatusSent = "SENT"
+	// DeliveryStatusFailed indicates that the notification could not be sent.
+	DeliveryStatusFailed = "FAILED"
+	// DeliveryStatusSuppressed indicates that the notification was not sent
+	// because the recipient is in the suppression list.
+	DeliveryStatusSuppressed = "SUPPRESSED"
+	// DeliveryStatusUnsubscribed indicates that the notification was
+	// not sent because the recipient unsubscribed from the report type.
+	DeliveryStatusUnsubscribed = "UNSUBSCRIBED"
+	// DeliveryStatusOtherChannel indicates that the notification was not
+	// sent because the recipient prefers to be notified through another channel.
+	DeliveryStatusOtherChannel = "OTHER_CHANNEL"
+	// DeliveryStatusCapped indicates that the notification was not sent
+	// because the daily cap of the team or the recipient was reached.
+	DeliveryStatusCapped = "CAPPED"
+	// DeliveryStatusSandboxed indicates that the notification was not sent
+	// because the recipient is not allowed in the sandbox mode.
+	DeliveryStatusSandboxed = "SANDBOXED"
+	// DeliveryStatusDelivered indicates that the provider
+	// delivered the notification to the recipient's mail server.
+	DeliveryStatusDelivered = "DELIVERED"
+	// DeliveryStatusBounced indicates that the notification bounced.
+	DeliveryStatusBounced = "BOUNCED"
+	// DeliveryStatusComplained indicates that the recipient
+	// marked the notification as spam.
+	DeliveryStatusComplained = "COMPLAINED"
+)
+
+// Delivery represents an attempt to send
+// a report notification to a recipient.
+// TeamID is the team the report belongs to, if known.
+// ProviderMessageID is the ID assigned to the
+// notification by the provider, e.g.: SES MessageId.
+type Delivery struct {
+	ID                int64
+	ReportID          string
+	ReportType        ReportType
+	TeamID            string
+	Recipient         string
+	Channel           string
+	ProviderMessageID string
+	Status            string
+	Error             string
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
@@ -31,6 +31,14 @@ func (r *LiveReport) GetStatus() string {
 	return r.Status
 }
 
+func (r *LiveReport) SetStatus(status string) {
+	r.Status = status
+}
+
+func (r *LiveReport) SetDeliveredTo(recipients []string) {
+	r.DeliveredTo = recipients
+}
+
 func (r *LiveReport) GetCreatedAt() time.Time {
 	return r.CreatedAt
 }
@@ -0,0 +1,40 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package model
+
+import "time"
+
+const (
+	// OutboxStatusPending indicates that the notification is waiting to be sent.
+	OutboxStatusPending = "PENDING"
+	// OutboxStatusSent indicates that the notification was sent.
+	OutboxStatusSent = "SENT"
+	// OutboxStatusFailed indicates that the notification
+	// could not be sent and will not be retried.
+	OutboxStatusFailed = "FAILED"
+)
+
+// OutboxNotification represents a report notification written to
+// the outbox along with the report status change, to be sent later
+// by the dispatcher. DeliveredTo are the recipients notified by the
+// send attempts so far. Attempts is the number of send attempts and
+// Error the error of the last one, if any. NextAttemptAt is the time
+// the pending notification is due.
+type OutboxNotification struct {
+	ID             int64
+	ReportID       string
+	ReportType     ReportType
+	TeamID         string
+	IdempotencyKey string
+	Notification   Notification
+	Recipients     []string
+	DeliveredTo    []string
+	Status         string
+	Attempts       int
+	Error          string
+	NextAttemptAt  time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
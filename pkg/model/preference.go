@@ -0,0 +1,23 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package model
+
+import "time"
+
+// Preference represents the preferences of a recipient
+// for the notifications of a report type. Email is
+// always stored lowercased.
+// Fmt and Channel are only honoured if HasFmt and
+// Channel are set. Otherwise the notification is sent
+// in the report format through the default channel.
+type Preference struct {
+	Email        string
+	ReportType   ReportType
+	Unsubscribed bool
+	HasFmt       bool
+	Fmt          NotifFmt
+	Channel      string
+	UpdatedAt    time.Time
+}
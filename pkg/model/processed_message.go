@@ -0,0 +1,43 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package model
+
+import "time"
+
+const (
+	// StageReceived indicates that the message has been received but its report is not generated yet.
+	StageReceived = "RECEIVED"
+	// StageGenerated indicates that the report for the message has been generated.
+	StageGenerated = "GENERATED"
+	// StageNotified indicates that the report notification for the message has been sent.
+	StageNotified = "NOTIFIED"
+	// StageFinished indicates that the message has been completely processed.
+	StageFinished = "FINISHED"
+	// StageFailed indicates that the message processing has been given up.
+	StageFailed = "FAILED"
+)
+
+// ProcessedMessage represents the processing
+// state of a report generation request.
+// Key is either the idempotency key supplied
+// in the request or the queue message ID.
+// Payload is the original request, kept so
+// it can be requeued if processing gets stuck.
+// Notified are the recipients the report notification
+// was already sent to, so they are not sent it again.
+// LockedUntil is the time the consumer processing
+// the message holds it until, zero if not locked.
+type ProcessedMessage struct {
+	Key         string
+	ReportType  ReportType
+	ReportID    string
+	Stage       string
+	Payload     string
+	Requeues    int
+	Notified    []string
+	LockedUntil time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
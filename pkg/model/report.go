@@ -23,12 +23,61 @@ const (
 
 	// StatusGenerating indicates that report is being generated.
 	StatusGenerating = "GENERATING"
+	// StatusGenerated indicates that report has been generated.
+	StatusGenerated = "GENERATED"
+	// StatusGenerationFailed indicates that report generation failed.
+	StatusGenerationFailed = "GENERATION_FAILED"
+	// StatusSending indicates that report notification is being sent.
+	StatusSending = "SENDING"
+	// StatusSent indicates that report notification has been sent.
+	StatusSent = "SENT"
+	// StatusSendFailed indicates that report notification could not be sent.
+	StatusSendFailed = "SEND_FAILED"
+
 	// StatusFinished indicates that report has been generated.
+	// Only kept for reports stored by previous versions.
 	StatusFinished = "FINISHED"
 	// StatusFailed indicates that report generation failed.
+	// Only kept for reports stored by previous versions.
 	StatusFailed = "FAILED"
 )
 
+// statusTransitions defines the valid
+// transitions between report statuses.
+var statusTransitions = map[string][]string{
+	"":                     {StatusGenerating},
+	StatusGenerating:       {StatusGenerated, StatusGenerationFailed},
+	StatusGenerated:        {StatusGenerating, StatusSending},
+	StatusGenerationFailed: {StatusGenerating},
+	StatusSending:          {StatusGenerating, StatusSending, StatusSent, StatusSendFailed},
+	StatusSent:             {StatusGenerating, StatusSending},
+	StatusSendFailed:       {StatusGenerating, StatusSending},
+	StatusFinished:         {StatusGenerating, StatusSending},
+	StatusFailed:           {StatusGenerating},
+}
+
+// IsValidTransition returns true if a report
+// can go from status from to status to.
+func IsValidTransition(from, to string) bool {
+	for _, s := range statusTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsGenerated returns true if the report has been
+// generated, so its notification can be sent.
+func IsGenerated(status string) bool {
+	switch status {
+	case StatusGenerated, StatusSending, StatusSent, StatusSendFailed, StatusFinished:
+		return true
+	default:
+		return false
+	}
+}
+
 // ReportType specifies a report type.
 type ReportType string
 
@@ -39,19 +88,24 @@ type Report interface {
 	GetNotification() Notification
 	GetDeliveredTo() []string
 	GetStatus() string
+	SetStatus(status string)
+	SetDeliveredTo(recipients []string)
 	GetCreatedAt() time.Time
 	GetUpdatedAt() time.Time
 }
 
 // BaseReport represents the common
 // fields for all types of reports.
+// DeliveredTo holds the recipients the report notification was
+// last sent to, while deliveries are tracked per recipient.
 type BaseReport struct {
-	ID           string
-	Notification Notification
-	DeliveredTo  []string
-	Status       string
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	ID              string
+	Notification    Notification
+	DeliveredTo     []string
+	Status          string
+	StatusUpdatedAt time.Time
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
 }
 
 // FileInfo contains report file
@@ -0,0 +1,21 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package model
+
+import "time"
+
+// ReportRevision represents the state of a report each time
+// it was saved, e.g.: when it was generated, regenerated or
+// its status changed, so previous notifications are kept.
+// Revisions are numbered from 1 for each report.
+type ReportRevision struct {
+	ReportID     string
+	ReportType   ReportType
+	Revision     int
+	Status       string
+	Notification Notification
+	DeliveredTo  []string
+	CreatedAt    time.Time
+}
@@ -0,0 +1,29 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package model
+
+import "time"
+
+const (
+	// SuppressionReasonBounce indicates that email to the recipient bounced permanently.
+	SuppressionReasonBounce = "BOUNCE"
+	// SuppressionReasonComplaint indicates that the recipient marked the email as spam.
+	SuppressionReasonComplaint = "COMPLAINT"
+	// SuppressionReasonManual indicates that the recipient was suppressed through the API.
+	SuppressionReasonManual = "MANUAL"
+)
+
+// Suppression represents a recipient that must not be notified.
+// Email is always stored lowercased.
+// Detail holds additional information about the reason, e.g.:
+// the bounce diagnostic code, and ProviderMessageID is the
+// notification that caused the suppression, if any.
+type Suppression struct {
+	Email             string
+	Reason            string
+	Detail            string
+	ProviderMessageID string
+	CreatedAt         time.Time
+}
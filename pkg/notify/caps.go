@@ -0,0 +1,116 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package notify
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+)
+
+// capsWindow is the period the daily caps apply to.
+const capsWindow = 24 * time.Hour
+
+// CapsConfig is the configuration of the daily caps of notifications,
+// which guard against runaway loops sending the same reports again and
+// again. TeamDailyCap is the max number of emails sent to the recipients
+// of a team and RecipientDailyCap is the max number of emails sent to
+// a recipient in the last 24 hours. Zero values disable the caps.
+type CapsConfig struct {
+	TeamDailyCap      int `toml:"team_daily_cap"`
+	RecipientDailyCap int `toml:"recipient_daily_cap"`
+}
+
+// DeliveriesCounter counts the notifications sent, e.g.: from the recorded deliveries.
+// CountRecipientDeliveries returns the counts by lowercased recipient.
+type DeliveriesCounter interface {
+	CountTeamDeliveries(ctx context.Context, teamID string, since time.Time) (int, error)
+	CountRecipientDeliveries(ctx context.Context, recipients []string, since time.Time) (map[string]int, error)
+}
+
+// capsNotifier is a Notifier that enforces
+// the daily caps of notifications.
+type capsNotifier struct {
+	notifier Notifier
+	counter  DeliveriesCounter
+	cfg      CapsConfig
+	log      *log.Logger
+}
+
+// NewCapsNotifier returns a Notifier that does not notify the recipients
+// that reached their daily cap, nor any recipient of a team that would
+// exceed its daily cap. The capped recipients are returned as skipped in
+// the notification Result. If no caps are configured, it returns notifier.
+func NewCapsNotifier(notifier Notifier, counter DeliveriesCounter, cfg CapsConfig, log *log.Logger) Notifier {
+	if cfg.TeamDailyCap <= 0 && cfg.RecipientDailyCap <= 0 {
+		return notifier
+	}
+	return &capsNotifier{
+		notifier: notifier,
+		counter:  counter,
+		cfg:      cfg,
+		log:      log,
+	}
+}
+
+// Notify notifies the recipients under their daily cap. It returns
+// ErrDailyCapExceeded if the team cap would be exceeded or every
+// recipient reached its cap.
+func (n *capsNotifier) Notify(ctx context.Context, notif Notification) (Result, error) {
+	since := time.Now().Add(-capsWindow)
+
+	var res Result
+	if n.cfg.TeamDailyCap > 0 && notif.TeamID != "" {
+		sent, err := n.counter.CountTeamDeliveries(ctx, notif.TeamID, since)
+		if err != nil {
+			return Result{}, err
+		}
+		if sent+len(notif.Recipients) > n.cfg.TeamDailyCap {
+			for _, r := range notif.Recipients {
+				res.skip(r, model.DeliveryStatusCapped)
+			}
+			n.log.WithFields(log.Fields{
+				"teamID": notif.TeamID,
+				"sent":   sent,
+				"cap":    n.cfg.TeamDailyCap,
+			}).Error("Team daily cap exceeded")
+			return res, ErrDailyCapExceeded
+		}
+	}
+
+	allowed := notif.Recipients
+	if n.cfg.RecipientDailyCap > 0 && len(notif.Recipients) > 0 {
+		counts, err := n.counter.CountRecipientDeliveries(ctx, notif.Recipients, since)
+		if err != nil {
+			return Result{}, err
+		}
+		allowed = nil
+		for _, r := range notif.Recipients {
+			if counts[strings.ToLower(strings.TrimSpace(r))] >= n.cfg.RecipientDailyCap {
+				res.skip(r, model.DeliveryStatusCapped)
+				continue
+			}
+			allowed = append(allowed, r)
+		}
+		if len(res.Skipped) > 0 {
+			n.log.WithFields(log.Fields{
+				"recipients": res.Skipped,
+				"cap":        n.cfg.RecipientDailyCap,
+			}).Error("Recipients daily cap exceeded")
+		}
+		if len(allowed) == 0 {
+			return res, ErrDailyCapExceeded
+		}
+	}
+
+	notif.Recipients = allowed
+	sent, err := n.notifier.Notify(ctx, notif)
+	res.merge(sent)
+	return res, err
+}
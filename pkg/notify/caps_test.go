@@ -0,0 +1,119 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package notify
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+)
+
+// DeliveriesCounter mock.
+type mockDeliveriesCounter struct {
+	team       int
+	recipients map[string]int
+	err        error
+}
+
+func (c *mockDeliveriesCounter) CountTeamDeliveries(ctx context.Context, teamID string, since time.Time) (int, error) {
+	return c.team, c.err
+}
+
+func (c *mockDeliveriesCounter) CountRecipientDeliveries(ctx context.Context, recipients []string, since time.Time) (map[string]int, error) {
+	return c.recipients, c.err
+}
+
+func TestCapsNotify(t *testing.T) {
+	testCases := []struct {
+		name               string
+		cfg                CapsConfig
+		counter            *mockDeliveriesCounter
+		teamID             string
+		recipients         []string
+		expectedRecipients []string
+		expectedResult     Result
+		expectedErr        error
+	}{
+		{
+			name:               "Should notify every recipient under the caps",
+			cfg:                CapsConfig{TeamDailyCap: 3, RecipientDailyCap: 2},
+			counter:            &mockDeliveriesCounter{team: 1, recipients: map[string]int{"tom@vulcan.example.com": 1}},
+			teamID:             "1",
+			recipients:         []string{"tom@vulcan.example.com", "ann@vulcan.example.com"},
+			expectedRecipients: []string{"tom@vulcan.example.com", "ann@vulcan.example.com"},
+			expectedResult:     newResult("mssg-1", []string{"tom@vulcan.example.com", "ann@vulcan.example.com"}),
+		},
+		{
+			name:           "Should return ErrDailyCapExceeded due to team cap",
+			cfg:            CapsConfig{TeamDailyCap: 3},
+			counter:        &mockDeliveriesCounter{team: 2},
+			teamID:         "1",
+			recipients:     []string{"tom@vulcan.example.com", "ann@vulcan.example.com"},
+			expectedResult: Result{Skipped: map[string]string{"tom@vulcan.example.com": model.DeliveryStatusCapped, "ann@vulcan.example.com": model.DeliveryStatusCapped}},
+			expectedErr:    ErrDailyCapExceeded,
+		},
+		{
+			name:               "Should not apply team cap without team",
+			cfg:                CapsConfig{TeamDailyCap: 3},
+			counter:            &mockDeliveriesCounter{team: 3},
+			recipients:         []string{"tom@vulcan.example.com"},
+			expectedRecipients: []string{"tom@vulcan.example.com"},
+			expectedResult:     newResult("mssg-1", []string{"tom@vulcan.example.com"}),
+		},
+		{
+			name:               "Should skip recipients over their cap",
+			cfg:                CapsConfig{RecipientDailyCap: 2},
+			counter:            &mockDeliveriesCounter{recipients: map[string]int{"tom@vulcan.example.com": 2}},
+			recipients:         []string{"Tom@vulcan.example.com", "ann@vulcan.example.com"},
+			expectedRecipients: []string{"ann@vulcan.example.com"},
+			expectedResult: Result{
+				ProviderIDs: map[string]string{"ann@vulcan.example.com": "mssg-1"},
+				Skipped:     map[string]string{"Tom@vulcan.example.com": model.DeliveryStatusCapped},
+			},
+		},
+		{
+			name:           "Should return ErrDailyCapExceeded due to every recipient over its cap",
+			cfg:            CapsConfig{RecipientDailyCap: 2},
+			counter:        &mockDeliveriesCounter{recipients: map[string]int{"tom@vulcan.example.com": 3}},
+			recipients:     []string{"tom@vulcan.example.com"},
+			expectedResult: Result{Skipped: map[string]string{"tom@vulcan.example.com": model.DeliveryStatusCapped}},
+			expectedErr:    ErrDailyCapExceeded,
+		},
+		{
+			name:        "Should return ErrMock",
+			cfg:         CapsConfig{RecipientDailyCap: 2},
+			counter:     &mockDeliveriesCounter{err: errMock},
+			recipients:  []string{"tom@vulcan.example.com"},
+			expectedErr: errMock,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			notifier := &mockNotifier{}
+			n := NewCapsNotifier(notifier, tc.counter, tc.cfg, log.New())
+
+			res, err := n.Notify(context.Background(), Notification{
+				TeamID:     tc.teamID,
+				Recipients: tc.recipients,
+			})
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected err: %v\nBut got: %v", tc.expectedErr, err)
+			}
+			if !reflect.DeepEqual(res, tc.expectedResult) {
+				t.Fatalf("Expected result: %+v\nBut got: %+v", tc.expectedResult, res)
+			}
+			if !reflect.DeepEqual(notifier.recipients, tc.expectedRecipients) {
+				t.Fatalf("Expected recipients: %v\nBut got: %v", tc.expectedRecipients, notifier.recipients)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	// BackendSES sends the notifications through AWS SES.
+	BackendSES = "ses"
+	// BackendFile writes the notifications to a local directory.
+	BackendFile = "file"
+
+	// FileFormatEML writes each notification as an RFC 5322 .eml file.
+	FileFormatEML = "eml"
+	// FileFormatMbox appends the notifications to an mbox file.
+	FileFormatMbox = "mbox"
+
+	emlExt   = ".eml"
+	mboxFile = "notifications.mbox"
+
+	defFileFrom = "vulcan-reports-generator@localhost"
+	// mboxDateFmt is the asctime format of the mbox From_ lines.
+	mboxDateFmt = "Mon Jan _2 15:04:05 2006"
+)
+
+// mboxFromLine matches the body lines that must be quoted in
+// mboxrd format, so they are not taken for message separators.
+var mboxFromLine = regexp.MustCompile(`(?m)^(>*From )`)
+
+// FileConfig is the configuration of the file notifier, meant for
+// local development and testing. The notifications are written to Dir
+// in Format, which is one of: eml (default) or mbox. From is the sender
+// of the emails. Defaults to vulcan-reports-generator@localhost.
+type FileConfig struct {
+	Dir    string `toml:"dir"`
+	Format string `toml:"format"`
+	From   string `toml:"from"`
+}
+
+// fileNotifier is a Notifier which writes
+// the notifications into a local directory.
+type fileNotifier struct {
+	mu  sync.Mutex
+	cfg FileConfig
+	seq uint64
+}
+
+// NewFileNotifier builds a new file notifier,
+// creating the notifications directory if needed.
+func NewFileNotifier(cfg FileConfig) (*fileNotifier, error) {
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("%w: notifications dir is required", ErrInvalidConfig)
+	}
+	switch cfg.Format {
+	case "":
+		cfg.Format = FileFormatEML
+	case FileFormatEML, FileFormatMbox:
+	default:
+		return nil, fmt.Errorf("%w: unsupported file format %q", ErrInvalidConfig, cfg.Format)
+	}
+	if cfg.From == "" {
+		cfg.From = defFileFrom
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &fileNotifier{
+		cfg: cfg,
+	}, nil
+}
+
+// Notify writes the notification as an RFC 5322 email, including its
+// headers and recipients. The generated Message-Id, which also names
+// the .eml files, is returned as the provider ID of every recipient.
+func (n *fileNotifier) Notify(ctx context.Context, notif Notification) (Result, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := time.Now()
+	n.seq++
+	id := fmt.Sprintf("%d-%06d", now.UnixNano(), n.seq)
+
+	headers := map[string]string{}
+	for k, v := range notif.Headers {
+		headers[k] = v
+	}
+	headers["Message-Id"] = fmt.Sprintf("<%s@vulcan-reports-generator>", id)
+
+	data, err := rawEmail{
+		From:    n.cfg.From,
+		To:      notif.Recipients,
+		Subject: notif.Subject,
+		Body:    notif.Body,
+		Fmt:     notif.Fmt,
+		Headers: headers,
+		Date:    now,
+	}.bytes()
+	if err != nil {
+		return Result{}, err
+	}
+
+	if n.cfg.Format == FileFormatMbox {
+		err = n.appendMbox(data, now)
+	} else {
+		err = n.writeEML(id, data)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+
+	return newResult(id, notif.Recipients), nil
+}
+
+// writeEML writes the email into its own file. The file is renamed
+// once written, so readers never see partially written emails.
+func (n *fileNotifier) writeEML(id string, data []byte) error {
+	tmp, err := os.CreateTemp(n.cfg.Dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // nolint
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(n.cfg.Dir, id+emlExt))
+}
+
+// appendMbox appends the email to the mbox file in mboxrd format,
+// with LF line endings and a From_ line before the message.
+func (n *fileNotifier) appendMbox(data []byte, date time.Time) error {
+	data = bytes.ReplaceAll(data, []byte(crlf), []byte("\n"))
+	data = mboxFromLine.ReplaceAll(data, []byte(">$1"))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From %s %s\n", n.cfg.From, date.UTC().Format(mboxDateFmt))
+	buf.Write(data)
+	buf.WriteString("\n")
+
+	f, err := os.OpenFile(filepath.Join(n.cfg.Dir, mboxFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package notify
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+)
+
+func TestFileNotifierEML(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "notifications")
+	notifier, err := NewFileNotifier(FileConfig{Dir: dir, From: "me@me.com"})
+	if err != nil {
+		t.Fatalf("Error building notifier: %v", err)
+	}
+
+	res, err := notifier.Notify(context.Background(), Notification{
+		Subject:    "Vulcan Digest",
+		Body:       "<p>Body</p>",
+		Fmt:        model.NotifFmtHTML,
+		Recipients: []string{"tom@vulcan.example.com", "ann@vulcan.example.com"},
+		Headers:    map[string]string{"X-Original-Recipients": "bob@example.org"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+
+	id := res.ProviderIDs["tom@vulcan.example.com"]
+	if id == "" || res.ProviderIDs["ann@vulcan.example.com"] != id {
+		t.Fatalf("Unexpected result: %+v", res)
+	}
+	f, err := os.Open(filepath.Join(dir, id+emlExt))
+	if err != nil {
+		t.Fatalf("Error opening email file: %v", err)
+	}
+	defer f.Close()
+	mssg, err := mail.ReadMessage(f)
+	if err != nil {
+		t.Fatalf("Error parsing email file: %v", err)
+	}
+
+	expectedHeaders := map[string]string{
+		"From":                  "me@me.com",
+		"To":                    "tom@vulcan.example.com, ann@vulcan.example.com",
+		"Subject":               "Vulcan Digest",
+		"Message-Id":            "<" + id + "@vulcan-reports-generator>",
+		"X-Original-Recipients": "bob@example.org",
+		"Content-Type":          "text/html; charset=UTF-8",
+	}
+	for k, v := range expectedHeaders {
+		if got := mssg.Header.Get(k); got != v {
+			t.Fatalf("Expected header %s: %q\nBut got: %q", k, v, got)
+		}
+	}
+}
+
+func TestFileNotifierMbox(t *testing.T) {
+	dir := t.TempDir()
+	notifier, err := NewFileNotifier(FileConfig{Dir: dir, Format: FileFormatMbox})
+	if err != nil {
+		t.Fatalf("Error building notifier: %v", err)
+	}
+
+	for _, body := range []string{"First", "Second\nFrom here on"} {
+		_, err := notifier.Notify(context.Background(), Notification{
+			Subject:    "Vulcan Digest",
+			Body:       body,
+			Fmt:        model.NotifFmtText,
+			Recipients: []string{"tom@vulcan.example.com"},
+		})
+		if err != nil {
+			t.Fatalf("Expected no error, but got: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, mboxFile))
+	if err != nil {
+		t.Fatalf("Error reading mbox file: %v", err)
+	}
+	mbox := string(data)
+	if n := strings.Count(mbox, "\nFrom "+defFileFrom+" ") + 1; !strings.HasPrefix(mbox, "From "+defFileFrom+" ") || n != 2 {
+		t.Fatalf("Expected 2 messages in mbox, but got:\n%s", mbox)
+	}
+	if !strings.Contains(mbox, "\n>From here on") || strings.Contains(mbox, "\r\n") {
+		t.Fatalf("Expected mboxrd quoting and LF line endings, but got:\n%s", mbox)
+	}
+}
+
+func TestNewFileNotifier(t *testing.T) {
+	testCases := []struct {
+		name        string
+		cfg         FileConfig
+		expectedErr error
+	}{
+		{
+			name: "Happy path",
+			cfg:  FileConfig{Dir: t.TempDir()},
+		},
+		{
+			name:        "Should return ErrInvalidConfig due to missing dir",
+			cfg:         FileConfig{},
+			expectedErr: ErrInvalidConfig,
+		},
+		{
+			name:        "Should return ErrInvalidConfig due to invalid format",
+			cfg:         FileConfig{Dir: t.TempDir(), Format: "maildir"},
+			expectedErr: ErrInvalidConfig,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewFileNotifier(tc.cfg); !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected err: %v\nBut got: %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+)
+
+const crlf = "\r\n"
+
+// contentTypes are the MIME types of the notification formats.
+var contentTypes = map[model.NotifFmt]string{
+	model.NotifFmtHTML: "text/html; charset=UTF-8",
+	model.NotifFmtText: "text/plain; charset=UTF-8",
+}
+
+// rawEmail represents an RFC 5322 email, used to send
+// notifications with headers not supported by SendEmail.
+type rawEmail struct {
+	From    string
+	To      []string
+	CC      []string
+	Subject string
+	Body    string
+	Fmt     model.NotifFmt
+	Headers map[string]string
+	Date    time.Time
+}
+
+// bytes returns the email encoded as an RFC 5322 message
+// with a single quoted-printable body part. Line breaks are
+// stripped from the header values, so they can not inject
+// other headers.
+func (e rawEmail) bytes() ([]byte, error) {
+	contentType, ok := contentTypes[e.Fmt]
+	if !ok {
+		return nil, ErrUnsupportedFmt
+	}
+
+	var buf bytes.Buffer
+	writeHeader := func(key, value string) {
+		fmt.Fprintf(&buf, "%s: %s%s", textproto.CanonicalMIMEHeaderKey(key), SanitizeHeader(value), crlf)
+	}
+
+	writeHeader("From", e.From)
+	if len(e.To) > 0 {
+		writeHeader("To", strings.Join(e.To, ", "))
+	}
+	if len(e.CC) > 0 {
+		writeHeader("Cc", strings.Join(e.CC, ", "))
+	}
+	writeHeader("Subject", mime.QEncoding.Encode("UTF-8", SanitizeHeader(e.Subject)))
+	writeHeader("Date", e.Date.Format(time.RFC1123Z))
+
+	keys := make([]string, 0, len(e.Headers))
+	for k := range e.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		writeHeader(k, e.Headers[k])
+	}
+
+	writeHeader("MIME-Version", "1.0")
+	writeHeader("Content-Type", contentType)
+	writeHeader("Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString(crlf)
+
+	w := quotedprintable.NewWriter(&buf)
+	if _, err := w.Write([]byte(e.Body)); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	buf.WriteString(crlf)
+
+	return buf.Bytes(), nil
+}
@@ -5,7 +5,9 @@ Copyright 2021 Adevinta
 package notify
 
 import (
+	"context"
 	"errors"
+	"time"
 
 	"github.com/adevinta/vulcan-reports-generator/pkg/model"
 )
@@ -15,10 +17,123 @@ var (
 	ErrInvalidConfig = errors.New("Invalid configuration")
 	// ErrUnsupportedFmt indicates that the specified format is not supported.
 	ErrUnsupportedFmt = errors.New("Unsupported format")
+	// ErrThrottled indicates that the notification could not be sent
+	// because the provider is throttling the requests.
+	ErrThrottled = errors.New("Notification throttled")
+	// ErrRecipientsSuppressed indicates that the notification was not
+	// sent because every recipient is in the suppression list.
+	ErrRecipientsSuppressed = errors.New("Every recipient is suppressed")
+	// ErrDailyCapExceeded indicates that the notification was not sent
+	// because the daily cap of the team or its recipients was reached.
+	ErrDailyCapExceeded = errors.New("Daily notifications cap exceeded")
 )
 
 // Notifier defines the
 // interface for a notifier.
 type Notifier interface {
-	Notify(subject, mssg string, fmt model.NotifFmt, recipients []string) error
+	Notify(ctx context.Context, notif Notification) (Result, error)
+}
+
+// Notification represents a report notification.
+// ReportType and TeamID are the type of the notified
+// report and the team it belongs to, if any.
+// Headers are added to the headers of the email,
+// e.g.: List-Unsubscribe.
+type Notification struct {
+	ReportType model.ReportType
+	TeamID     string
+	Subject    string
+	Body       string
+	Fmt        model.NotifFmt
+	Recipients []string
+	Headers    map[string]string
+}
+
+// Result is the result of sending a notification.
+// ProviderIDs are the IDs assigned by the provider to the
+// notification sent to each recipient. Skipped are the recipients
+// which were not notified, along with the delivery status that
+// describes why, e.g.: SUPPRESSED.
+type Result struct {
+	ProviderIDs map[string]string
+	Skipped     map[string]string
+}
+
+// newResult returns the result of a
+// notification sent to every recipient.
+func newResult(providerID string, recipients []string) Result {
+	res := Result{ProviderIDs: map[string]string{}}
+	for _, r := range recipients {
+		res.ProviderIDs[r] = providerID
+	}
+	return res
+}
+
+// merge adds the outcome of other to the result.
+func (r *Result) merge(other Result) {
+	for rcpt, id := range other.ProviderIDs {
+		if r.ProviderIDs == nil {
+			r.ProviderIDs = map[string]string{}
+		}
+		r.ProviderIDs[rcpt] = id
+	}
+	for rcpt, status := range other.Skipped {
+		r.skip(rcpt, status)
+	}
+}
+
+// DeliveredTo returns the recipients, out of the specified
+// ones, which were notified, i.e.: those with a provider ID.
+func (r Result) DeliveredTo(recipients []string) []string {
+	delivered := []string{}
+	for _, rcpt := range recipients {
+		if r.ProviderIDs[rcpt] != "" {
+			delivered = append(delivered, rcpt)
+		}
+	}
+	return delivered
+}
+
+// skip records that the recipient was not notified.
+func (r *Result) skip(recipient, status string) {
+	if r.Skipped == nil {
+		r.Skipped = map[string]string{}
+	}
+	r.Skipped[recipient] = status
+}
+
+// Deliveries returns the deliveries of the notification of the
+// report, whose sending returned this result and err.
+// Recipients with a provider ID were notified even if err is
+// not nil, e.g.: when the notification is sent separately to
+// each recipient and only some of them fail.
+func (r Result) Deliveries(reportID string, notif Notification, err error) []model.Delivery {
+	var errMssg string
+	if err != nil {
+		errMssg = err.Error()
+	}
+
+	now := time.Now()
+	var deliveries []model.Delivery
+	for _, rcpt := range notif.Recipients {
+		d := model.Delivery{
+			ReportID:          reportID,
+			ReportType:        notif.ReportType,
+			TeamID:            notif.TeamID,
+			Recipient:         rcpt,
+			Channel:           model.DeliveryChannelEmail,
+			ProviderMessageID: r.ProviderIDs[rcpt],
+			Status:            model.DeliveryStatusSent,
+			CreatedAt:         now,
+			UpdatedAt:         now,
+		}
+		switch status, skipped := r.Skipped[rcpt]; {
+		case skipped:
+			d.Status = status
+		case err != nil && d.ProviderMessageID == "":
+			d.Status, d.Error = model.DeliveryStatusFailed, errMssg
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries
 }
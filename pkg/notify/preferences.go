@@ -0,0 +1,136 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package notify
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+)
+
+// PreferencesStore represents the store of the preferences
+// of the recipients for each report type. GetPreferences
+// returns the preferences of the recipients that have any.
+type PreferencesStore interface {
+	GetPreferences(ctx context.Context, typ model.ReportType, recipients []string) ([]model.Preference, error)
+}
+
+// preferencesNotifier is a Notifier that honours
+// the preferences of the recipients.
+type preferencesNotifier struct {
+	notifier    Notifier
+	store       PreferencesStore
+	unsubscribe UnsubscribeConfig
+	log         *log.Logger
+}
+
+// NewPreferencesNotifier returns a Notifier that does not notify the
+// recipients unsubscribed from the report type or who prefer another
+// channel, and that sends the notification in the preferred format of
+// each recipient. If unsubscribe links are enabled, the notification
+// is sent separately to each recipient, with its own link in the body
+// and the RFC 8058 one-click unsubscribe headers.
+// Notifications without report type are sent as they are.
+func NewPreferencesNotifier(notifier Notifier, store PreferencesStore, unsubscribe UnsubscribeConfig, log *log.Logger) (Notifier, error) {
+	if err := unsubscribe.validate(); err != nil {
+		return nil, err
+	}
+	return &preferencesNotifier{
+		notifier:    notifier,
+		store:       store,
+		unsubscribe: unsubscribe,
+		log:         log,
+	}, nil
+}
+
+// Notify notifies the recipients according to their preferences.
+// If sending to some of the recipients fails, the rest are still
+// notified and the errors are joined.
+func (n *preferencesNotifier) Notify(ctx context.Context, notif Notification) (Result, error) {
+	if notif.ReportType == "" {
+		return n.notifier.Notify(ctx, notif)
+	}
+
+	prefs, err := n.store.GetPreferences(ctx, notif.ReportType, notif.Recipients)
+	if err != nil {
+		return Result{}, err
+	}
+	byEmail := map[string]model.Preference{}
+	for _, p := range prefs {
+		byEmail[p.Email] = p
+	}
+
+	// Group the recipients by the format they prefer, unless
+	// each one must receive its own unsubscribe link.
+	var (
+		res    Result
+		groups []Notification
+		byFmt  = map[model.NotifFmt]int{}
+	)
+	for _, r := range notif.Recipients {
+		pref, ok := byEmail[strings.ToLower(strings.TrimSpace(r))]
+		if ok && pref.Unsubscribed {
+			res.skip(r, model.DeliveryStatusUnsubscribed)
+			continue
+		}
+		if ok && pref.Channel != "" && pref.Channel != model.DeliveryChannelEmail {
+			res.skip(r, model.DeliveryStatusOtherChannel)
+			continue
+		}
+
+		rcptNotif := notif
+		if ok && pref.HasFmt && pref.Fmt != notif.Fmt {
+			rcptNotif = convertFmt(notif, pref.Fmt)
+		}
+		if n.unsubscribe.Enabled {
+			rcptNotif.Recipients = []string{r}
+			groups = append(groups, withUnsubscribeLink(rcptNotif, n.unsubscribe.link(r, notif.ReportType)))
+			continue
+		}
+		i, ok := byFmt[rcptNotif.Fmt]
+		if !ok {
+			i = len(groups)
+			byFmt[rcptNotif.Fmt] = i
+			rcptNotif.Recipients = nil
+			groups = append(groups, rcptNotif)
+		}
+		groups[i].Recipients = append(groups[i].Recipients, r)
+	}
+	if len(res.Skipped) > 0 {
+		n.log.WithFields(log.Fields{
+			"type":       notif.ReportType,
+			"recipients": res.Skipped,
+		}).Info("Recipients skipped by their preferences")
+	}
+
+	var errs []error
+	for _, g := range groups {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		sent, err := n.notifier.Notify(ctx, g)
+		res.merge(sent)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return res, errors.Join(errs...)
+}
+
+// convertFmt returns the notification in the specified format.
+// Only HTML notifications can be converted to text, so the
+// rest are returned unchanged.
+func convertFmt(notif Notification, fmt model.NotifFmt) Notification {
+	if notif.Fmt == model.NotifFmtHTML && fmt == model.NotifFmtText {
+		notif.Body = htmlToText(notif.Body)
+		notif.Fmt = model.NotifFmtText
+	}
+	return notif
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package notify
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+)
+
+var mockUnsubscribeCfg = UnsubscribeConfig{
+	Enabled: true,
+	URL:     "https://reports.vulcan.example.com/api/v1/unsubscribe",
+	Secret:  "secret",
+}
+
+// PreferencesStore mock.
+type mockPreferencesStore struct {
+	prefs []model.Preference
+	err   error
+}
+
+func (s *mockPreferencesStore) GetPreferences(ctx context.Context, typ model.ReportType, recipients []string) ([]model.Preference, error) {
+	return s.prefs, s.err
+}
+
+func TestPreferencesNotify(t *testing.T) {
+	testCases := []struct {
+		name           string
+		store          *mockPreferencesStore
+		unsubscribe    UnsubscribeConfig
+		notif          Notification
+		expectedNotifs []Notification
+		expectedResult Result
+		expectedErr    error
+	}{
+		{
+			name:  "Should notify every recipient without preferences",
+			store: &mockPreferencesStore{},
+			notif: Notification{
+				ReportType: "livereport",
+				Body:       "<p>mssg</p>",
+				Fmt:        model.NotifFmtHTML,
+				Recipients: []string{"tom@vulcan.example.com", "ann@vulcan.example.com"},
+			},
+			expectedNotifs: []Notification{
+				{
+					ReportType: "livereport",
+					Body:       "<p>mssg</p>",
+					Fmt:        model.NotifFmtHTML,
+					Recipients: []string{"tom@vulcan.example.com", "ann@vulcan.example.com"},
+				},
+			},
+			expectedResult: newResult("mssg-1", []string{"tom@vulcan.example.com", "ann@vulcan.example.com"}),
+		},
+		{
+			name: "Should skip unsubscribed recipients and recipients preferring other channels",
+			store: &mockPreferencesStore{prefs: []model.Preference{
+				{Email: "tom@vulcan.example.com", ReportType: "livereport", Unsubscribed: true},
+				{Email: "bob@vulcan.example.com", ReportType: "livereport", Channel: "slack"},
+				{Email: "ann@vulcan.example.com", ReportType: "livereport", Channel: model.DeliveryChannelEmail},
+			}},
+			notif: Notification{
+				ReportType: "livereport",
+				Body:       "<p>mssg</p>",
+				Fmt:        model.NotifFmtHTML,
+				Recipients: []string{"Tom@vulcan.example.com", "bob@vulcan.example.com", "ann@vulcan.example.com"},
+			},
+			expectedNotifs: []Notification{
+				{
+					ReportType: "livereport",
+					Body:       "<p>mssg</p>",
+					Fmt:        model.NotifFmtHTML,
+					Recipients: []string{"ann@vulcan.example.com"},
+				},
+			},
+			expectedResult: Result{
+				ProviderIDs: map[string]string{"ann@vulcan.example.com": "mssg-1"},
+				Skipped: map[string]string{
+					"Tom@vulcan.example.com": model.DeliveryStatusUnsubscribed,
+					"bob@vulcan.example.com": model.DeliveryStatusOtherChannel,
+				},
+			},
+		},
+		{
+			name: "Should group recipients by preferred format",
+			store: &mockPreferencesStore{prefs: []model.Preference{
+				{Email: "tom@vulcan.example.com", ReportType: "livereport", HasFmt: true, Fmt: model.NotifFmtText},
+				{Email: "ann@vulcan.example.com", ReportType: "livereport", HasFmt: true, Fmt: model.NotifFmtHTML},
+			}},
+			notif: Notification{
+				ReportType: "livereport",
+				Body:       `<p>See the <a href="https://vulcan.example.com/live">report</a></p>`,
+				Fmt:        model.NotifFmtHTML,
+				Recipients: []string{"tom@vulcan.example.com", "ann@vulcan.example.com", "bob@vulcan.example.com"},
+			},
+			expectedNotifs: []Notification{
+				{
+					ReportType: "livereport",
+					Body:       "See the report (https://vulcan.example.com/live)\n",
+					Fmt:        model.NotifFmtText,
+					Recipients: []string{"tom@vulcan.example.com"},
+				},
+				{
+					ReportType: "livereport",
+					Body:       `<p>See the <a href="https://vulcan.example.com/live">report</a></p>`,
+					Fmt:        model.NotifFmtHTML,
+					Recipients: []string{"ann@vulcan.example.com", "bob@vulcan.example.com"},
+				},
+			},
+			expectedResult: newResult("mssg-1", []string{"tom@vulcan.example.com", "ann@vulcan.example.com", "bob@vulcan.example.com"}),
+		},
+		{
+			name:        "Should send each recipient its unsubscribe link",
+			store:       &mockPreferencesStore{},
+			unsubscribe: mockUnsubscribeCfg,
+			notif: Notification{
+				ReportType: "livereport",
+				Body:       "mssg",
+				Fmt:        model.NotifFmtText,
+				Recipients: []string{"tom@vulcan.example.com", "ann@vulcan.example.com"},
+			},
+			expectedNotifs: []Notification{
+				withUnsubscribeLink(Notification{
+					ReportType: "livereport",
+					Body:       "mssg",
+					Fmt:        model.NotifFmtText,
+					Recipients: []string{"tom@vulcan.example.com"},
+				}, mockUnsubscribeCfg.link("tom@vulcan.example.com", "livereport")),
+				withUnsubscribeLink(Notification{
+					ReportType: "livereport",
+					Body:       "mssg",
+					Fmt:        model.NotifFmtText,
+					Recipients: []string{"ann@vulcan.example.com"},
+				}, mockUnsubscribeCfg.link("ann@vulcan.example.com", "livereport")),
+			},
+			expectedResult: newResult("mssg-1", []string{"tom@vulcan.example.com", "ann@vulcan.example.com"}),
+		},
+		{
+			name: "Should not check preferences without report type",
+			store: &mockPreferencesStore{prefs: []model.Preference{
+				{Email: "tom@vulcan.example.com", Unsubscribed: true},
+			}},
+			notif: Notification{
+				Body:       "mssg",
+				Fmt:        model.NotifFmtText,
+				Recipients: []string{"tom@vulcan.example.com"},
+			},
+			expectedNotifs: []Notification{
+				{
+					Body:       "mssg",
+					Fmt:        model.NotifFmtText,
+					Recipients: []string{"tom@vulcan.example.com"},
+				},
+			},
+			expectedResult: newResult("mssg-1", []string{"tom@vulcan.example.com"}),
+		},
+		{
+			name:  "Should return ErrMock",
+			store: &mockPreferencesStore{err: errMock},
+			notif: Notification{
+				ReportType: "livereport",
+				Recipients: []string{"tom@vulcan.example.com"},
+			},
+			expectedErr: errMock,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			notifier := &mockNotifier{}
+			n, err := NewPreferencesNotifier(notifier, tc.store, tc.unsubscribe, log.New())
+			if err != nil {
+				t.Fatalf("Error building notifier: %v", err)
+			}
+
+			res, err := n.Notify(context.Background(), tc.notif)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected err: %v\nBut got: %v", tc.expectedErr, err)
+			}
+			if !reflect.DeepEqual(res, tc.expectedResult) {
+				t.Fatalf("Expected result: %+v\nBut got: %+v", tc.expectedResult, res)
+			}
+			if !reflect.DeepEqual(notifier.notifs, tc.expectedNotifs) {
+				t.Fatalf("Expected notifications: %+v\nBut got: %+v", tc.expectedNotifs, notifier.notifs)
+			}
+		})
+	}
+}
+
+func TestHTMLToText(t *testing.T) {
+	body := `<html><head><style>p { color: red; }</style></head><body>
+		<h1>Vulcan   Digest</h1>
+		<p>Found <b>3</b> issues.</p>
+		<ul><li>High</li><li>Low</li></ul>
+		<script>alert(1)</script>
+		<a href="https://vulcan.example.com/live">Live report</a>
+	</body></html>`
+	expected := "Vulcan Digest\n\nFound 3 issues.\n\nHigh\n\nLow\n\nLive report (https://vulcan.example.com/live)\n"
+
+	if text := htmlToText(body); text != expected {
+		t.Fatalf("Expected text: %q\nBut got: %q", expected, text)
+	}
+	if strings.Contains(htmlToText(body), "alert") {
+		t.Fatal("Expected scripts to be dropped")
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package notify
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/ses/sesiface"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defMaxRetries   = 3
+	defRetryBackoff = 1000
+)
+
+// RateLimitConfig is the configuration of the rate at which emails are sent.
+//
+//   - Enabled limits the rate using a token bucket shared by every
+//     consumer of the process. Each recipient of an email takes a token.
+//   - MaxSendRate is the max number of recipients per second of each
+//     replica. If zero, the max send rate of the SES account divided by
+//     Replicas is used.
+//   - Replicas is the number of replicas sending emails through the
+//     SES account, as the limit is per process. Defaults to 1.
+//   - MaxRetries is the number of times the emails throttled by SES are
+//     retried, regardless of Enabled. Defaults to 3. Negative values
+//     disable the retries.
+//   - RetryBackoff is the base backoff before retrying, in milliseconds,
+//     doubled on each retry and jittered. Defaults to 1000.
+type RateLimitConfig struct {
+	Enabled      bool    `toml:"enabled"`
+	MaxSendRate  float64 `toml:"max_send_rate"`
+	Replicas     int     `toml:"replicas"`
+	MaxRetries   int     `toml:"max_retries"`
+	RetryBackoff int64   `toml:"retry_backoff"`
+}
+
+// newLimiter returns the token bucket to limit the emails sent by the
+// process. The SES account max send rate is shared by the replicas. The
+// bucket holds one second of tokens, so bursts do not exceed the rate.
+func (c RateLimitConfig) newLimiter(sesSvc sesiface.SESAPI) (*rate.Limiter, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	maxRate := c.MaxSendRate
+	if maxRate <= 0 {
+		quota, err := sesSvc.GetSendQuota(&ses.GetSendQuotaInput{})
+		if err != nil {
+			return nil, err
+		}
+		maxRate = aws.Float64Value(quota.MaxSendRate) / float64(max(c.Replicas, 1))
+	}
+	if maxRate <= 0 {
+		return nil, ErrInvalidConfig
+	}
+	return rate.NewLimiter(rate.Limit(maxRate), int(math.Max(1, math.Floor(maxRate)))), nil
+}
+
+// withDefaults returns the config with the defaults applied.
+func (c RateLimitConfig) withDefaults() RateLimitConfig {
+	if c.MaxRetries == 0 {
+		c.MaxRetries = defMaxRetries
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = defRetryBackoff
+	}
+	return c
+}
+
+// waitN waits until n tokens are available. The tokens are taken in
+// chunks of the bucket size, so emails with more recipients than the
+// bucket can hold are not rejected. A nil limiter does not wait.
+func waitN(ctx context.Context, limiter *rate.Limiter, n int) error {
+	if limiter == nil {
+		return nil
+	}
+	for n > 0 {
+		chunk := min(n, limiter.Burst())
+		if err := limiter.WaitN(ctx, chunk); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// retryThrottled calls send until it does not return ErrThrottled or the
+// retries are exhausted, waiting an exponential jittered backoff between
+// calls, so consumers throttled at the same time do not retry together.
+func (c RateLimitConfig) retryThrottled(ctx context.Context, send func() error) error {
+	backoff := time.Duration(c.RetryBackoff) * time.Millisecond
+	for retry := 0; ; retry++ {
+		err := send()
+		if !errors.Is(err, ErrThrottled) || retry >= c.MaxRetries {
+			return err
+		}
+
+		wait := backoff << retry
+		wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
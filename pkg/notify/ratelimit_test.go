@@ -0,0 +1,159 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package notify
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"golang.org/x/time/rate"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+)
+
+func (m *mockSESAPI) GetSendQuota(input *ses.GetSendQuotaInput) (*ses.GetSendQuotaOutput, error) {
+	return &ses.GetSendQuotaOutput{MaxSendRate: aws.Float64(14)}, nil
+}
+
+func TestNotifyRetryThrottled(t *testing.T) {
+	throttled := awserr.New("Throttling", "Maximum sending rate exceeded.", nil)
+
+	testCases := []struct {
+		name          string
+		maxRetries    int
+		errs          []error
+		expectedCalls int
+		expectedErr   error
+	}{
+		{
+			name:          "Should retry throttled emails",
+			maxRetries:    3,
+			errs:          []error{throttled, throttled},
+			expectedCalls: 3,
+		},
+		{
+			name:          "Should return ErrThrottled once retries are exhausted",
+			maxRetries:    2,
+			errs:          []error{throttled, throttled, throttled, throttled},
+			expectedCalls: 3,
+			expectedErr:   ErrThrottled,
+		},
+		{
+			name:          "Should not retry other errors",
+			maxRetries:    3,
+			errs:          []error{errMock},
+			expectedCalls: 1,
+			expectedErr:   errMock,
+		},
+		{
+			name:          "Should not retry if retries are disabled",
+			maxRetries:    -1,
+			errs:          []error{throttled},
+			expectedCalls: 1,
+			expectedErr:   ErrThrottled,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls int
+			cfg := mockCfg
+			cfg.RateLimit = RateLimitConfig{MaxRetries: tc.maxRetries, RetryBackoff: 1}
+			notifier, err := NewSESNotifier(cfg, &mockSESAPI{
+				mockFunc: func(*ses.SendEmailInput) (*ses.SendEmailOutput, error) {
+					calls++
+					if calls <= len(tc.errs) {
+						return nil, tc.errs[calls-1]
+					}
+					return &ses.SendEmailOutput{MessageId: aws.String("mssg-1")}, nil
+				},
+			})
+			if err != nil {
+				t.Fatalf("Error building notifier: %v", err)
+			}
+
+			_, err = notifier.Notify(context.Background(), Notification{
+				Body:       "mssg",
+				Fmt:        model.NotifFmtText,
+				Recipients: []string{"tom@somewhere.com"},
+			})
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected err: %v\nBut got: %v", tc.expectedErr, err)
+			}
+			if calls != tc.expectedCalls {
+				t.Fatalf("Expected calls to be: %d\nBut got: %d", tc.expectedCalls, calls)
+			}
+		})
+	}
+}
+
+func TestNewLimiter(t *testing.T) {
+	testCases := []struct {
+		name          string
+		cfg           RateLimitConfig
+		expectedLimit rate.Limit
+		expectedBurst int
+	}{
+		{
+			name:          "Should use the configured rate",
+			cfg:           RateLimitConfig{Enabled: true, MaxSendRate: 0.5},
+			expectedLimit: 0.5,
+			expectedBurst: 1,
+		},
+		{
+			name:          "Should use the SES max send rate",
+			cfg:           RateLimitConfig{Enabled: true},
+			expectedLimit: 14,
+			expectedBurst: 14,
+		},
+		{
+			name:          "Should divide the SES max send rate by the replicas",
+			cfg:           RateLimitConfig{Enabled: true, Replicas: 4},
+			expectedLimit: 3.5,
+			expectedBurst: 3,
+		},
+		{
+			name:          "Should not divide the configured rate by the replicas",
+			cfg:           RateLimitConfig{Enabled: true, MaxSendRate: 2, Replicas: 4},
+			expectedLimit: 2,
+			expectedBurst: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			limiter, err := tc.cfg.newLimiter(&mockSESAPI{})
+			if err != nil {
+				t.Fatalf("Error building limiter: %v", err)
+			}
+			if limiter.Limit() != tc.expectedLimit || limiter.Burst() != tc.expectedBurst {
+				t.Fatalf("Expected limit and burst: %v %d\nBut got: %v %d",
+					tc.expectedLimit, tc.expectedBurst, limiter.Limit(), limiter.Burst())
+			}
+		})
+	}
+}
+
+func TestWaitN(t *testing.T) {
+	// More tokens than the bucket holds must not be rejected.
+	limiter := rate.NewLimiter(rate.Inf, 2)
+	if err := waitN(context.Background(), limiter, 5); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+
+	// Waiting is aborted once ctx is done.
+	limiter = rate.NewLimiter(rate.Every(time.Hour), 1)
+	limiter.Allow()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := waitN(ctx, limiter, 1); err == nil {
+		t.Fatal("Expected error, but got nil")
+	}
+}
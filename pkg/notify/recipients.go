@@ -0,0 +1,116 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// defMaxRecipients is the max number of
+// destinations of an email sent through SES.
+const defMaxRecipients = 50
+
+var (
+	// ErrInvalidRecipients indicates that the recipients of the notification are not valid.
+	ErrInvalidRecipients = errors.New("Invalid recipients")
+
+	headerReplacer = strings.NewReplacer("\r", "", "\n", "")
+)
+
+// RecipientsConfig restricts the recipients of the notifications.
+// AllowedDomains are the email domains that can be notified. If
+// empty, every domain is allowed. MaxRecipients is the max number
+// of recipients of a notification. Defaults to 50.
+type RecipientsConfig struct {
+	AllowedDomains []string `toml:"allowed_domains"`
+	MaxRecipients  int      `toml:"max_recipients"`
+}
+
+// RecipientError describes why a recipient is not valid.
+type RecipientError struct {
+	Recipient string
+	Reason    string
+}
+
+// RecipientsError is returned when the recipients of a notification are
+// not valid. Errors holds an error for each invalid recipient, if the
+// recipients are not rejected as a whole. It wraps ErrInvalidRecipients.
+type RecipientsError struct {
+	Reason string
+	Errors []RecipientError
+}
+
+func (e *RecipientsError) Error() string {
+	var errs []string
+	for _, r := range e.Errors {
+		errs = append(errs, fmt.Sprintf("%s: %s", r.Recipient, r.Reason))
+	}
+	if len(errs) == 0 {
+		return fmt.Sprintf("%v: %s", ErrInvalidRecipients, e.Reason)
+	}
+	return fmt.Sprintf("%v: %s", ErrInvalidRecipients, strings.Join(errs, ", "))
+}
+
+func (e *RecipientsError) Unwrap() error {
+	return ErrInvalidRecipients
+}
+
+// ValidateRecipients checks that every recipient is a plain email
+// address of an allowed domain and that there are not too many of them.
+// It returns a *RecipientsError if the recipients are not valid.
+func (c RecipientsConfig) ValidateRecipients(recipients []string) error {
+	max := c.MaxRecipients
+	if max <= 0 {
+		max = defMaxRecipients
+	}
+	if len(recipients) > max {
+		return &RecipientsError{Reason: fmt.Sprintf("%d recipients exceed the max of %d", len(recipients), max)}
+	}
+
+	var errs []RecipientError
+	for _, r := range recipients {
+		if reason := c.validateRecipient(r); reason != "" {
+			errs = append(errs, RecipientError{Recipient: r, Reason: reason})
+		}
+	}
+	if len(errs) > 0 {
+		return &RecipientsError{Errors: errs}
+	}
+	return nil
+}
+
+// validateRecipient returns the reason why
+// the recipient is not valid, if any.
+func (c RecipientsConfig) validateRecipient(recipient string) string {
+	addr, err := mail.ParseAddress(recipient)
+	if err != nil {
+		return "invalid address"
+	}
+	// Display names are rejected too, so only
+	// plain addresses end up in the headers.
+	if addr.Address != recipient {
+		return "not a plain address"
+	}
+	if len(c.AllowedDomains) == 0 {
+		return ""
+	}
+
+	domain := recipient[strings.LastIndex(recipient, "@")+1:]
+	for _, d := range c.AllowedDomains {
+		if strings.EqualFold(domain, d) {
+			return ""
+		}
+	}
+	return "domain not allowed"
+}
+
+// SanitizeHeader strips the line breaks from a header value,
+// e.g.: the subject, so it can not inject other headers.
+func SanitizeHeader(value string) string {
+	return headerReplacer.Replace(value)
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package notify
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidateRecipients(t *testing.T) {
+	testCases := []struct {
+		name           string
+		cfg            RecipientsConfig
+		recipients     []string
+		expectedErr    error
+		expectedErrors []RecipientError
+	}{
+		{
+			name:       "Happy path",
+			cfg:        RecipientsConfig{AllowedDomains: []string{"vulcan.example.com"}},
+			recipients: []string{"tom@vulcan.example.com", "ann@Vulcan.Example.com"},
+		},
+		{
+			name:       "Should allow every domain if not restricted",
+			recipients: []string{"tom@example.org"},
+		},
+		{
+			name:        "Should return per recipient errors",
+			cfg:         RecipientsConfig{AllowedDomains: []string{"vulcan.example.com"}},
+			recipients:  []string{"tom@vulcan.example.com", "tom", "Tom <tom@vulcan.example.com>", "tom@example.org", "tom@vulcan.example.com\r\nBcc: x@example.org"},
+			expectedErr: ErrInvalidRecipients,
+			expectedErrors: []RecipientError{
+				{Recipient: "tom", Reason: "invalid address"},
+				{Recipient: "Tom <tom@vulcan.example.com>", Reason: "not a plain address"},
+				{Recipient: "tom@example.org", Reason: "domain not allowed"},
+				{Recipient: "tom@vulcan.example.com\r\nBcc: x@example.org", Reason: "invalid address"},
+			},
+		},
+		{
+			name:        "Should return err for too many recipients",
+			cfg:         RecipientsConfig{MaxRecipients: 1},
+			recipients:  []string{"tom@vulcan.example.com", "ann@vulcan.example.com"},
+			expectedErr: ErrInvalidRecipients,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.cfg.ValidateRecipients(tc.recipients)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected err: %v\nBut got: %v", tc.expectedErr, err)
+			}
+			var rerr *RecipientsError
+			if errors.As(err, &rerr) && !reflect.DeepEqual(rerr.Errors, tc.expectedErrors) {
+				t.Fatalf("Expected errors: %v\nBut got: %v", tc.expectedErrors, rerr.Errors)
+			}
+		})
+	}
+}
+
+func TestSanitizeHeader(t *testing.T) {
+	got := SanitizeHeader("Live Report - Team\r\nBcc: x@example.org")
+	expected := "Live Report - TeamBcc: x@example.org"
+	if got != expected {
+		t.Fatalf("Expected header: %q\nBut got: %q", expected, got)
+	}
+}
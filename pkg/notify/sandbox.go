@@ -0,0 +1,133 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+)
+
+// headerOriginalRecipients is the header with the
+// recipients of the notification before redirecting it.
+const headerOriginalRecipients = "X-Original-Recipients"
+
+// SandboxConfig is the configuration of the sandbox mode, meant for
+// non-production environments, e.g.: staging, so real people are not
+// notified. The recipients in Allowlist are notified as usual, and the
+// rest are redirected to CatchAll or, if it is empty, not notified.
+// The subjects are prefixed with the Environment name, if set.
+type SandboxConfig struct {
+	Enabled     bool     `toml:"enabled"`
+	Environment string   `toml:"environment"`
+	CatchAll    string   `toml:"catch_all"`
+	Allowlist   []string `toml:"allowlist"`
+}
+
+func (c SandboxConfig) validate() error {
+	addrs := c.Allowlist
+	if c.CatchAll != "" {
+		addrs = append([]string{c.CatchAll}, addrs...)
+	}
+	for _, addr := range addrs {
+		if a, err := mail.ParseAddress(addr); err != nil || a.Address != addr {
+			return fmt.Errorf("%w: invalid sandbox address %q", ErrInvalidConfig, addr)
+		}
+	}
+	return nil
+}
+
+// sandboxNotifier is a Notifier that redirects the
+// notifications to the allowed recipients.
+type sandboxNotifier struct {
+	notifier Notifier
+	cfg      SandboxConfig
+	allowed  map[string]bool
+	log      *log.Logger
+}
+
+// NewSandboxNotifier returns a Notifier that rewrites the recipients of the
+// notifications according to the sandbox config, and adds the original
+// recipients in the X-Original-Recipients header. The recipients redirected
+// to the catch-all address share its provider ID in the notification Result,
+// and those not notified are returned as skipped.
+// If the sandbox mode is not enabled, it returns notifier.
+func NewSandboxNotifier(notifier Notifier, cfg SandboxConfig, log *log.Logger) (Notifier, error) {
+	if !cfg.Enabled {
+		return notifier, nil
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	allowed := map[string]bool{}
+	for _, addr := range cfg.Allowlist {
+		allowed[strings.ToLower(addr)] = true
+	}
+	return &sandboxNotifier{
+		notifier: notifier,
+		cfg:      cfg,
+		allowed:  allowed,
+		log:      log,
+	}, nil
+}
+
+// Notify sends the notification to the allowed recipients
+// and to the catch-all address, if any recipient is redirected.
+func (n *sandboxNotifier) Notify(ctx context.Context, notif Notification) (Result, error) {
+	var (
+		res        Result
+		recipients []string
+		redirected []string
+	)
+	for _, r := range notif.Recipients {
+		switch {
+		case n.allowed[strings.ToLower(strings.TrimSpace(r))]:
+			recipients = append(recipients, r)
+		case n.cfg.CatchAll != "":
+			redirected = append(redirected, r)
+		default:
+			res.skip(r, model.DeliveryStatusSandboxed)
+		}
+	}
+	if len(redirected) > 0 && !n.allowed[strings.ToLower(n.cfg.CatchAll)] {
+		recipients = append(recipients, n.cfg.CatchAll)
+	}
+	n.log.WithFields(log.Fields{
+		"recipients": notif.Recipients,
+		"redirected": recipients,
+	}).Debug("Sandbox notification redirected")
+	if len(recipients) == 0 {
+		return res, nil
+	}
+
+	headers := map[string]string{}
+	for k, v := range notif.Headers {
+		headers[k] = v
+	}
+	headers[headerOriginalRecipients] = strings.Join(notif.Recipients, ", ")
+	notif.Headers = headers
+	if n.cfg.Environment != "" {
+		notif.Subject = fmt.Sprintf("[%s] %s", n.cfg.Environment, notif.Subject)
+	}
+	notif.Recipients = recipients
+
+	sent, err := n.notifier.Notify(ctx, notif)
+	if id, ok := sent.ProviderIDs[n.cfg.CatchAll]; ok {
+		if !n.allowed[strings.ToLower(n.cfg.CatchAll)] {
+			delete(sent.ProviderIDs, n.cfg.CatchAll)
+		}
+		for _, r := range redirected {
+			sent.ProviderIDs[r] = id
+		}
+	}
+	res.merge(sent)
+	return res, err
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package notify
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+)
+
+func TestSandboxNotify(t *testing.T) {
+	testCases := []struct {
+		name           string
+		cfg            SandboxConfig
+		recipients     []string
+		expectedNotifs []Notification
+		expectedResult Result
+	}{
+		{
+			name: "Should redirect recipients to catch-all",
+			cfg: SandboxConfig{
+				Enabled:     true,
+				Environment: "staging",
+				CatchAll:    "catchall@vulcan.example.com",
+				Allowlist:   []string{"ann@vulcan.example.com"},
+			},
+			recipients: []string{"tom@example.org", "Ann@vulcan.example.com", "bob@example.org"},
+			expectedNotifs: []Notification{
+				{
+					Subject:    "[staging] subject",
+					Recipients: []string{"Ann@vulcan.example.com", "catchall@vulcan.example.com"},
+					Headers: map[string]string{
+						"X-Custom":              "value",
+						"X-Original-Recipients": "tom@example.org, Ann@vulcan.example.com, bob@example.org",
+					},
+				},
+			},
+			expectedResult: newResult("mssg-1", []string{"tom@example.org", "Ann@vulcan.example.com", "bob@example.org"}),
+		},
+		{
+			name: "Should skip recipients not allowlisted without catch-all",
+			cfg: SandboxConfig{
+				Enabled:   true,
+				Allowlist: []string{"ann@vulcan.example.com"},
+			},
+			recipients: []string{"tom@example.org", "ann@vulcan.example.com"},
+			expectedNotifs: []Notification{
+				{
+					Subject:    "subject",
+					Recipients: []string{"ann@vulcan.example.com"},
+					Headers: map[string]string{
+						"X-Custom":              "value",
+						"X-Original-Recipients": "tom@example.org, ann@vulcan.example.com",
+					},
+				},
+			},
+			expectedResult: Result{
+				ProviderIDs: map[string]string{"ann@vulcan.example.com": "mssg-1"},
+				Skipped:     map[string]string{"tom@example.org": model.DeliveryStatusSandboxed},
+			},
+		},
+		{
+			name:           "Should not notify if no recipient is allowed",
+			cfg:            SandboxConfig{Enabled: true},
+			recipients:     []string{"tom@example.org"},
+			expectedResult: Result{Skipped: map[string]string{"tom@example.org": model.DeliveryStatusSandboxed}},
+		},
+		{
+			name:       "Should not redirect if sandbox is disabled",
+			cfg:        SandboxConfig{CatchAll: "catchall@vulcan.example.com"},
+			recipients: []string{"tom@example.org"},
+			expectedNotifs: []Notification{
+				{
+					Subject:    "subject",
+					Recipients: []string{"tom@example.org"},
+					Headers:    map[string]string{"X-Custom": "value"},
+				},
+			},
+			expectedResult: newResult("mssg-1", []string{"tom@example.org"}),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			notifier := &mockNotifier{}
+			n, err := NewSandboxNotifier(notifier, tc.cfg, log.New())
+			if err != nil {
+				t.Fatalf("Error building notifier: %v", err)
+			}
+
+			res, err := n.Notify(context.Background(), Notification{
+				Subject:    "subject",
+				Recipients: tc.recipients,
+				Headers:    map[string]string{"X-Custom": "value"},
+			})
+			if err != nil {
+				t.Fatalf("Expected no error, but got: %v", err)
+			}
+			if !reflect.DeepEqual(res, tc.expectedResult) {
+				t.Fatalf("Expected result: %+v\nBut got: %+v", tc.expectedResult, res)
+			}
+			if !reflect.DeepEqual(notifier.notifs, tc.expectedNotifs) {
+				t.Fatalf("Expected notifications: %+v\nBut got: %+v", tc.expectedNotifs, notifier.notifs)
+			}
+		})
+	}
+}
+
+func TestNewSandboxNotifier(t *testing.T) {
+	testCases := []struct {
+		name        string
+		cfg         SandboxConfig
+		expectedErr error
+	}{
+		{
+			name: "Should not validate disabled config",
+			cfg:  SandboxConfig{CatchAll: "invalid"},
+		},
+		{
+			name: "Happy path",
+			cfg:  SandboxConfig{Enabled: true, CatchAll: "catchall@vulcan.example.com", Allowlist: []string{"ann@vulcan.example.com"}},
+		},
+		{
+			name:        "Should return ErrInvalidConfig due to invalid catch-all",
+			cfg:         SandboxConfig{Enabled: true, CatchAll: "Catch All <catchall@vulcan.example.com>"},
+			expectedErr: ErrInvalidConfig,
+		},
+		{
+			name:        "Should return ErrInvalidConfig due to invalid allowlist",
+			cfg:         SandboxConfig{Enabled: true, Allowlist: []string{""}},
+			expectedErr: ErrInvalidConfig,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewSandboxNotifier(&mockNotifier{}, tc.cfg, log.New())
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected err: %v\nBut got: %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
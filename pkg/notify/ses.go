@@ -5,53 +5,135 @@ Copyright 2021 Adevinta
 package notify
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/mail"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ses"
 	"github.com/aws/aws-sdk-go/service/ses/sesiface"
+	"golang.org/x/time/rate"
 
 	"github.com/adevinta/vulcan-reports-generator/pkg/model"
 )
 
 const (
 	utf8 = "UTF-8"
+
+	// sesThrottlingCode is the error code returned by SES
+	// when the maximum sending rate is exceeded.
+	sesThrottlingCode = "Throttling"
 )
 
 type sesNotifier struct {
-	cfg    SESConfig
-	sesSvc sesiface.SESAPI
+	cfg     SESConfig
+	sesSvc  sesiface.SESAPI
+	limiter *rate.Limiter
 }
 
 type SESConfig struct {
-	Region string
-	From   string
-	CC     []string
+	Region     string
+	From       string
+	CC         []string
+	Recipients RecipientsConfig `toml:"recipients"`
+	RateLimit  RateLimitConfig  `toml:"rate_limit"`
 }
 
+// NewSESNotifier builds a new SES notifier. If the rate limit is enabled
+// without max send rate, it is read from the SES account send quota.
 func NewSESNotifier(cfg SESConfig, sesSvc sesiface.SESAPI) (*sesNotifier, error) {
 	if !isValidConfig(cfg) {
 		return nil, ErrInvalidConfig
 	}
 
+	cfg.RateLimit = cfg.RateLimit.withDefaults()
+	limiter, err := cfg.RateLimit.newLimiter(sesSvc)
+	if err != nil {
+		return nil, err
+	}
+
 	return &sesNotifier{
-		cfg:    cfg,
-		sesSvc: sesSvc,
+		cfg:     cfg,
+		sesSvc:  sesSvc,
+		limiter: limiter,
 	}, nil
 }
 
-func (n *sesNotifier) Notify(subject, mssg string, fmt model.NotifFmt, recipients []string) error {
-	input, err := n.buildInput(subject, mssg, fmt, recipients)
-	if err != nil {
+// Notify sends the notification as a single email to every
+// recipient and returns the SES message ID. The recipients
+// are validated against the configured restrictions first.
+// Notifications with headers are sent as raw emails, as
+// SendEmail does not support custom headers.
+// Sending waits for the rate limit, and emails throttled by
+// SES are retried before returning ErrThrottled.
+func (n *sesNotifier) Notify(ctx context.Context, notif Notification) (Result, error) {
+	if err := n.cfg.Recipients.ValidateRecipients(notif.Recipients); err != nil {
+		return Result{}, err
+	}
+
+	send := n.sendEmail
+	if len(notif.Headers) > 0 {
+		send = n.sendRawEmail
+	}
+
+	var messageID string
+	err := n.cfg.RateLimit.retryThrottled(ctx, func() (err error) {
+		if err = waitN(ctx, n.limiter, len(notif.Recipients)+len(n.cfg.CC)); err != nil {
+			return err
+		}
+		messageID, err = send(ctx, notif)
 		return err
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	return newResult(messageID, notif.Recipients), nil
+}
+
+// sendEmail sends the notification and returns the SES message ID.
+func (n *sesNotifier) sendEmail(ctx context.Context, notif Notification) (string, error) {
+	input, err := n.buildInput(notif.Subject, notif.Body, notif.Fmt, notif.Recipients)
+	if err != nil {
+		return "", err
 	}
 
-	_, err = n.sesSvc.SendEmail(input)
+	output, err := n.sesSvc.SendEmailWithContext(ctx, input)
 	if err != nil {
-		return err
+		return "", wrapSESError(err)
 	}
+	return aws.StringValue(output.MessageId), nil
+}
 
-	return nil
+// sendRawEmail sends the notification as a raw
+// email and returns the SES message ID.
+func (n *sesNotifier) sendRawEmail(ctx context.Context, notif Notification) (string, error) {
+	data, err := rawEmail{
+		From:    n.cfg.From,
+		To:      notif.Recipients,
+		CC:      n.cfg.CC,
+		Subject: notif.Subject,
+		Body:    notif.Body,
+		Fmt:     notif.Fmt,
+		Headers: notif.Headers,
+		Date:    time.Now(),
+	}.bytes()
+	if err != nil {
+		return "", err
+	}
+
+	output, err := n.sesSvc.SendRawEmailWithContext(ctx, &ses.SendRawEmailInput{
+		Destinations: stringSliceToAWSString(append(append([]string{}, notif.Recipients...), n.cfg.CC...)),
+		RawMessage:   &ses.RawMessage{Data: data},
+		Source:       aws.String(n.cfg.From),
+	})
+	if err != nil {
+		return "", wrapSESError(err)
+	}
+	return aws.StringValue(output.MessageId), nil
 }
 
 func (n *sesNotifier) buildInput(subject, mssg string, fmt model.NotifFmt, recipients []string) (*ses.SendEmailInput, error) {
@@ -79,13 +161,22 @@ func (n *sesNotifier) buildInput(subject, mssg string, fmt model.NotifFmt, recip
 			Body: body,
 			Subject: &ses.Content{
 				Charset: aws.String(utf8),
-				Data:    aws.String(subject),
+				Data:    aws.String(SanitizeHeader(subject)),
 			},
 		},
 		Source: aws.String(n.cfg.From),
 	}, nil
 }
 
+// wrapSESError wraps the SES throttling errors with ErrThrottled.
+func wrapSESError(err error) error {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == sesThrottlingCode {
+		return fmt.Errorf("%w: %v", ErrThrottled, err)
+	}
+	return err
+}
+
 func isValidConfig(cfg SESConfig) bool {
 	if _, err := mail.ParseAddress(cfg.From); err != nil ||
 		cfg.From == "" || cfg.Region == "" {
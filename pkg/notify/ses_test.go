@@ -5,9 +5,16 @@ Copyright 2021 Adevinta
 package notify
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"net/mail"
+	"reflect"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/ses"
 	"github.com/aws/aws-sdk-go/service/ses/sesiface"
 
@@ -28,12 +35,18 @@ type mockSendEmailFunc func(*ses.SendEmailInput) (*ses.SendEmailOutput, error)
 type mockSESAPI struct {
 	sesiface.SESAPI
 	mockFunc mockSendEmailFunc
+	raw      []*ses.SendRawEmailInput
 }
 
-func (m *mockSESAPI) SendEmail(input *ses.SendEmailInput) (*ses.SendEmailOutput, error) {
+func (m *mockSESAPI) SendEmailWithContext(ctx aws.Context, input *ses.SendEmailInput, opts ...request.Option) (*ses.SendEmailOutput, error) {
 	return m.mockFunc(input)
 }
 
+func (m *mockSESAPI) SendRawEmailWithContext(ctx aws.Context, input *ses.SendRawEmailInput, opts ...request.Option) (*ses.SendRawEmailOutput, error) {
+	m.raw = append(m.raw, input)
+	return &ses.SendRawEmailOutput{MessageId: aws.String("raw-1")}, nil
+}
+
 func TestNotify(t *testing.T) {
 	type input struct {
 		subject    string
@@ -46,6 +59,7 @@ func TestNotify(t *testing.T) {
 		name        string
 		input       input
 		mockFunc    mockSendEmailFunc
+		expectedID  string
 		expectedErr error
 	}{
 		{
@@ -58,8 +72,9 @@ func TestNotify(t *testing.T) {
 			},
 			mockFunc: func(*ses.SendEmailInput) (*ses.SendEmailOutput, error) {
 				// All good.
-				return nil, nil
+				return &ses.SendEmailOutput{MessageId: aws.String("mssg-1")}, nil
 			},
+			expectedID: "mssg-1",
 		},
 		{
 			name: "Should return err due to SendEmail err",
@@ -85,6 +100,19 @@ func TestNotify(t *testing.T) {
 			},
 			expectedErr: ErrUnsupportedFmt,
 		},
+		{
+			name: "Should return ErrThrottled due to SES throttling",
+			input: input{
+				subject:    "An important subject",
+				mssg:       "An important mssg",
+				fmt:        model.NotifFmtText,
+				recipients: []string{"tom@somewhere.com"},
+			},
+			mockFunc: func(*ses.SendEmailInput) (*ses.SendEmailOutput, error) {
+				return nil, awserr.New("Throttling", "Maximum sending rate exceeded.", nil)
+			},
+			expectedErr: ErrThrottled,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -96,14 +124,72 @@ func TestNotify(t *testing.T) {
 				},
 			}
 
-			err := notifier.Notify(tc.input.mssg, tc.input.subject, tc.input.fmt, tc.input.recipients)
+			res, err := notifier.Notify(context.Background(), Notification{
+				Subject:    tc.input.subject,
+				Body:       tc.input.mssg,
+				Fmt:        tc.input.fmt,
+				Recipients: tc.input.recipients,
+			})
 			if !errors.Is(err, tc.expectedErr) {
 				t.Fatalf("Expected err: %v\nBut got: %v", tc.expectedErr, err)
 			}
+			for _, r := range tc.input.recipients {
+				if res.ProviderIDs[r] != tc.expectedID {
+					t.Fatalf("Expected message ID: %s\nBut got: %s", tc.expectedID, res.ProviderIDs[r])
+				}
+			}
 		})
 	}
 }
 
+func TestNotifyRaw(t *testing.T) {
+	sesSvc := &mockSESAPI{}
+	notifier := &sesNotifier{
+		cfg:    SESConfig{Region: "eu-west-1", From: "me@me.com", CC: []string{"cc@me.com"}},
+		sesSvc: sesSvc,
+	}
+
+	res, err := notifier.Notify(context.Background(), Notification{
+		Subject:    "Subject\r\nBcc: x@example.org",
+		Body:       "<p>Body</p>",
+		Fmt:        model.NotifFmtHTML,
+		Recipients: []string{"tom@somewhere.com"},
+		Headers:    map[string]string{"List-Unsubscribe": "<https://example.com/u>"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if res.ProviderIDs["tom@somewhere.com"] != "raw-1" {
+		t.Fatalf("Expected message ID: %s\nBut got: %s", "raw-1", res.ProviderIDs["tom@somewhere.com"])
+	}
+	if len(sesSvc.raw) != 1 {
+		t.Fatalf("Expected raw emails to be: %d\nBut got: %d", 1, len(sesSvc.raw))
+	}
+
+	input := sesSvc.raw[0]
+	if destinations := aws.StringValueSlice(input.Destinations); !reflect.DeepEqual(destinations, []string{"tom@somewhere.com", "cc@me.com"}) {
+		t.Fatalf("Unexpected destinations: %v", destinations)
+	}
+	mssg, err := mail.ReadMessage(bytes.NewReader(input.RawMessage.Data))
+	if err != nil {
+		t.Fatalf("Error parsing raw email: %v", err)
+	}
+	expectedHeaders := map[string]string{
+		"From":             "me@me.com",
+		"To":               "tom@somewhere.com",
+		"Cc":               "cc@me.com",
+		"Subject":          "SubjectBcc: x@example.org",
+		"List-Unsubscribe": "<https://example.com/u>",
+		"Content-Type":     "text/html; charset=UTF-8",
+		"Bcc":              "",
+	}
+	for k, v := range expectedHeaders {
+		if got := mssg.Header.Get(k); got != v {
+			t.Fatalf("Expected header %s: %q\nBut got: %q", k, v, got)
+		}
+	}
+}
+
 func TestParseConfig(t *testing.T) {
 	testCases := []struct {
 		name     string
@@ -0,0 +1,78 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package notify
+
+import (
+	"context"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+)
+
+// SuppressionList represents the list of recipients that must not be notified.
+// GetSuppressed returns the recipients that are suppressed, lowercased.
+type SuppressionList interface {
+	GetSuppressed(ctx context.Context, recipients []string) ([]string, error)
+}
+
+// suppressionNotifier is a Notifier that strips
+// the suppressed recipients before notifying.
+type suppressionNotifier struct {
+	notifier Notifier
+	list     SuppressionList
+	log      *log.Logger
+}
+
+// NewSuppressionNotifier returns a Notifier that does not notify
+// the recipients in the suppression list, so we stop sending emails
+// to addresses that bounce or complain. The suppressed recipients
+// are returned as skipped in the notification Result.
+func NewSuppressionNotifier(notifier Notifier, list SuppressionList, log *log.Logger) Notifier {
+	return &suppressionNotifier{
+		notifier: notifier,
+		list:     list,
+		log:      log,
+	}
+}
+
+// Notify notifies the recipients that are not suppressed. If every
+// recipient is suppressed, it returns ErrRecipientsSuppressed.
+func (n *suppressionNotifier) Notify(ctx context.Context, notif Notification) (Result, error) {
+	suppressed, err := n.list.GetSuppressed(ctx, notif.Recipients)
+	if err != nil {
+		return Result{}, err
+	}
+	isSuppressed := map[string]bool{}
+	for _, s := range suppressed {
+		isSuppressed[s] = true
+	}
+
+	var (
+		res     Result
+		allowed []string
+	)
+	for _, r := range notif.Recipients {
+		if isSuppressed[strings.ToLower(strings.TrimSpace(r))] {
+			res.skip(r, model.DeliveryStatusSuppressed)
+			continue
+		}
+		allowed = append(allowed, r)
+	}
+	if len(res.Skipped) > 0 {
+		n.log.WithFields(log.Fields{
+			"recipients": suppressed,
+		}).Warn("Suppressed recipients will not be notified")
+	}
+	if len(notif.Recipients) > 0 && len(allowed) == 0 {
+		return res, ErrRecipientsSuppressed
+	}
+
+	notif.Recipients = allowed
+	sent, err := n.notifier.Notify(ctx, notif)
+	res.merge(sent)
+	return res, err
+}
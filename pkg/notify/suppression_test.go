@@ -0,0 +1,102 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package notify
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+)
+
+// SuppressionList mock.
+type mockSuppressionList struct {
+	suppressed []string
+	err        error
+}
+
+func (l *mockSuppressionList) GetSuppressed(ctx context.Context, recipients []string) ([]string, error) {
+	return l.suppressed, l.err
+}
+
+// Notifier mock.
+type mockNotifier struct {
+	recipients []string
+	notifs     []Notification
+}
+
+func (n *mockNotifier) Notify(ctx context.Context, notif Notification) (Result, error) {
+	n.recipients = notif.Recipients
+	n.notifs = append(n.notifs, notif)
+	return newResult("mssg-1", notif.Recipients), nil
+}
+
+func TestSuppressionNotify(t *testing.T) {
+	testCases := []struct {
+		name               string
+		list               *mockSuppressionList
+		recipients         []string
+		expectedRecipients []string
+		expectedResult     Result
+		expectedErr        error
+	}{
+		{
+			name:               "Should notify every recipient",
+			list:               &mockSuppressionList{},
+			recipients:         []string{"tom@vulcan.example.com"},
+			expectedRecipients: []string{"tom@vulcan.example.com"},
+			expectedResult:     newResult("mssg-1", []string{"tom@vulcan.example.com"}),
+		},
+		{
+			name:               "Should strip suppressed recipients",
+			list:               &mockSuppressionList{suppressed: []string{"tom@vulcan.example.com"}},
+			recipients:         []string{"Tom@vulcan.example.com", "ann@vulcan.example.com"},
+			expectedRecipients: []string{"ann@vulcan.example.com"},
+			expectedResult: Result{
+				ProviderIDs: map[string]string{"ann@vulcan.example.com": "mssg-1"},
+				Skipped:     map[string]string{"Tom@vulcan.example.com": model.DeliveryStatusSuppressed},
+			},
+		},
+		{
+			name:           "Should return ErrRecipientsSuppressed",
+			list:           &mockSuppressionList{suppressed: []string{"tom@vulcan.example.com"}},
+			recipients:     []string{"tom@vulcan.example.com"},
+			expectedResult: Result{Skipped: map[string]string{"tom@vulcan.example.com": model.DeliveryStatusSuppressed}},
+			expectedErr:    ErrRecipientsSuppressed,
+		},
+		{
+			name:        "Should return ErrMock",
+			list:        &mockSuppressionList{err: errMock},
+			recipients:  []string{"tom@vulcan.example.com"},
+			expectedErr: errMock,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			notifier := &mockNotifier{}
+			n := NewSuppressionNotifier(notifier, tc.list, log.New())
+
+			res, err := n.Notify(context.Background(), Notification{
+				Subject:    "subject",
+				Body:       "mssg",
+				Recipients: tc.recipients,
+			})
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected err: %v\nBut got: %v", tc.expectedErr, err)
+			}
+			if !reflect.DeepEqual(res, tc.expectedResult) {
+				t.Fatalf("Expected result: %+v\nBut got: %+v", tc.expectedResult, res)
+			}
+			if !reflect.DeepEqual(notifier.recipients, tc.expectedRecipients) {
+				t.Fatalf("Expected recipients: %v\nBut got: %v", tc.expectedRecipients, notifier.recipients)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package notify
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// blockTags are the HTML elements rendered in their own lines.
+var blockTags = map[string]bool{
+	"br": true, "p": true, "div": true, "tr": true, "li": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"table": true, "ul": true, "ol": true,
+}
+
+// htmlToText renders the text of an HTML document, e.g.: for the
+// recipients that prefer text emails. Links are kept after their
+// text, and the contents of scripts and styles are dropped.
+func htmlToText(body string) string {
+	var (
+		b     strings.Builder
+		skip  int
+		hrefs []string
+	)
+	z := html.NewTokenizer(strings.NewReader(body))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return collapseLines(b.String())
+		case html.TextToken:
+			if skip == 0 {
+				b.WriteString(strings.Join(strings.Fields(string(z.Text())), " "))
+				b.WriteString(" ")
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			switch {
+			case tok.Data == "script" || tok.Data == "style":
+				skip++
+			case blockTags[tok.Data]:
+				b.WriteString("\n")
+			}
+			if tok.Data == "a" && tok.Type == html.StartTagToken {
+				hrefs = append(hrefs, linkHref(tok))
+			}
+		case html.EndTagToken:
+			tok := z.Token()
+			switch {
+			case (tok.Data == "script" || tok.Data == "style") && skip > 0:
+				skip--
+			case blockTags[tok.Data]:
+				b.WriteString("\n")
+			}
+			if tok.Data == "a" && len(hrefs) > 0 {
+				if href := hrefs[len(hrefs)-1]; href != "" {
+					b.WriteString("(" + href + ") ")
+				}
+				hrefs = hrefs[:len(hrefs)-1]
+			}
+		}
+	}
+}
+
+// linkHref returns the target of the link if it is an absolute URL.
+func linkHref(tok html.Token) string {
+	for _, attr := range tok.Attr {
+		if attr.Key == "href" && (strings.HasPrefix(attr.Val, "http://") || strings.HasPrefix(attr.Val, "https://")) {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// collapseLines trims the lines and removes the consecutive blank ones.
+func collapseLines(text string) string {
+	var lines []string
+	blank := true
+	for _, l := range strings.Split(text, "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" {
+			if !blank {
+				lines = append(lines, "")
+			}
+			blank = true
+			continue
+		}
+		lines = append(lines, l)
+		blank = false
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")) + "\n"
+}
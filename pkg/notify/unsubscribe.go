@@ -0,0 +1,122 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+)
+
+const (
+	// Headers of RFC 8058 one-click unsubscribe.
+	headerListUnsubscribe     = "List-Unsubscribe"
+	headerListUnsubscribePost = "List-Unsubscribe-Post"
+	listUnsubscribeOneClick   = "List-Unsubscribe=One-Click"
+
+	tokenSep = "."
+)
+
+var (
+	// ErrInvalidToken indicates that the unsubscribe token is not valid.
+	ErrInvalidToken = errors.New("Invalid unsubscribe token")
+)
+
+// UnsubscribeConfig is the configuration of the unsubscribe links.
+// URL is the public URL of the unsubscribe endpoint of the API,
+// e.g.: https://reports.example.com/api/v1/unsubscribe, and Secret
+// is the key used to sign the links.
+type UnsubscribeConfig struct {
+	Enabled bool   `toml:"enabled"`
+	URL     string `toml:"url"`
+	Secret  string `toml:"secret"`
+}
+
+func (c UnsubscribeConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if u, err := url.Parse(c.URL); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("%w: invalid unsubscribe url", ErrInvalidConfig)
+	}
+	if c.Secret == "" {
+		return fmt.Errorf("%w: missing unsubscribe secret", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// Token returns the signed token that allows the
+// recipient to unsubscribe from the report type.
+func (c UnsubscribeConfig) Token(email string, typ model.ReportType) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(strings.ToLower(email) + "\n" + string(typ)))
+	return payload + tokenSep + c.sign(payload)
+}
+
+// ParseToken returns the recipient and the report type of the token.
+// It returns ErrInvalidToken if the token is malformed or its signature
+// does not match.
+func (c UnsubscribeConfig) ParseToken(token string) (string, model.ReportType, error) {
+	payload, sig, ok := strings.Cut(token, tokenSep)
+	if !ok || c.Secret == "" || !hmac.Equal([]byte(sig), []byte(c.sign(payload))) {
+		return "", "", ErrInvalidToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", "", ErrInvalidToken
+	}
+	email, typ, ok := strings.Cut(string(data), "\n")
+	if !ok || email == "" || typ == "" {
+		return "", "", ErrInvalidToken
+	}
+	return email, model.ReportType(typ), nil
+}
+
+// link returns the unsubscribe link for the recipient and report type.
+func (c UnsubscribeConfig) link(email string, typ model.ReportType) string {
+	u, _ := url.Parse(c.URL)
+	q := u.Query()
+	q.Set("token", c.Token(email, typ))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func (c UnsubscribeConfig) sign(payload string) string {
+	mac := hmac.New(sha256.New, []byte(c.Secret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// withUnsubscribeLink returns the notification for a single recipient
+// with the RFC 8058 headers and the unsubscribe link in the body.
+func withUnsubscribeLink(notif Notification, link string) Notification {
+	headers := map[string]string{}
+	for k, v := range notif.Headers {
+		headers[k] = v
+	}
+	headers[headerListUnsubscribe] = "<" + link + ">"
+	headers[headerListUnsubscribePost] = listUnsubscribeOneClick
+	notif.Headers = headers
+
+	switch notif.Fmt {
+	case model.NotifFmtHTML:
+		footer := fmt.Sprintf(`<p style="font-size:small"><a href="%s">Unsubscribe</a> from these emails.</p>`,
+			html.EscapeString(link))
+		if i := strings.LastIndex(strings.ToLower(notif.Body), "</body>"); i >= 0 {
+			notif.Body = notif.Body[:i] + footer + notif.Body[i:]
+		} else {
+			notif.Body += footer
+		}
+	case model.NotifFmtText:
+		notif.Body += "\n\nUnsubscribe from these emails: " + link + "\n"
+	}
+	return notif
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package notify
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+)
+
+func TestParseToken(t *testing.T) {
+	token := mockUnsubscribeCfg.Token("Tom@vulcan.example.com", "livereport")
+
+	testCases := []struct {
+		name          string
+		cfg           UnsubscribeConfig
+		token         string
+		expectedEmail string
+		expectedType  model.ReportType
+		expectedErr   error
+	}{
+		{
+			name:          "Happy path",
+			cfg:           mockUnsubscribeCfg,
+			token:         token,
+			expectedEmail: "tom@vulcan.example.com",
+			expectedType:  "livereport",
+		},
+		{
+			name:        "Should return ErrInvalidToken due to tampered payload",
+			cfg:         mockUnsubscribeCfg,
+			token:       mockUnsubscribeCfg.Token("ann@vulcan.example.com", "livereport")[:10] + token[10:],
+			expectedErr: ErrInvalidToken,
+		},
+		{
+			name:        "Should return ErrInvalidToken due to different secret",
+			cfg:         UnsubscribeConfig{Secret: "other"},
+			token:       token,
+			expectedErr: ErrInvalidToken,
+		},
+		{
+			name:        "Should return ErrInvalidToken due to missing signature",
+			cfg:         mockUnsubscribeCfg,
+			token:       strings.Split(token, tokenSep)[0],
+			expectedErr: ErrInvalidToken,
+		},
+		{
+			name:        "Should return ErrInvalidToken due to empty token",
+			cfg:         mockUnsubscribeCfg,
+			expectedErr: ErrInvalidToken,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			email, typ, err := tc.cfg.ParseToken(tc.token)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected err: %v\nBut got: %v", tc.expectedErr, err)
+			}
+			if email != tc.expectedEmail || typ != tc.expectedType {
+				t.Fatalf("Expected email and type: %s %s\nBut got: %s %s", tc.expectedEmail, tc.expectedType, email, typ)
+			}
+		})
+	}
+}
+
+func TestWithUnsubscribeLink(t *testing.T) {
+	link := mockUnsubscribeCfg.link("tom@vulcan.example.com", "livereport")
+
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("Error parsing link: %v", err)
+	}
+	if email, _, err := mockUnsubscribeCfg.ParseToken(u.Query().Get("token")); err != nil || email != "tom@vulcan.example.com" {
+		t.Fatalf("Expected link token to be valid, but got: %s %v", email, err)
+	}
+
+	notif := Notification{
+		Body:    "<html><body><p>mssg</p></body></html>",
+		Fmt:     model.NotifFmtHTML,
+		Headers: map[string]string{"X-Custom": "value"},
+	}
+	got := withUnsubscribeLink(notif, link)
+
+	expectedHeaders := map[string]string{
+		"X-Custom":              "value",
+		"List-Unsubscribe":      "<" + link + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+	for k, v := range expectedHeaders {
+		if got.Headers[k] != v {
+			t.Fatalf("Expected header %s: %s\nBut got: %s", k, v, got.Headers[k])
+		}
+	}
+	if len(notif.Headers) != 1 {
+		t.Fatalf("Expected original headers to be unchanged, but got: %v", notif.Headers)
+	}
+	if !strings.HasSuffix(got.Body, "</p></body></html>") || !strings.Contains(got.Body, `<a href="`) {
+		t.Fatalf("Expected unsubscribe link before the body end, but got: %s", got.Body)
+	}
+}
+
+func TestUnsubscribeConfigValidate(t *testing.T) {
+	testCases := []struct {
+		name        string
+		cfg         UnsubscribeConfig
+		expectedErr error
+	}{
+		{
+			name: "Should not validate disabled config",
+			cfg:  UnsubscribeConfig{},
+		},
+		{
+			name: "Happy path",
+			cfg:  mockUnsubscribeCfg,
+		},
+		{
+			name:        "Should return ErrInvalidConfig due to relative URL",
+			cfg:         UnsubscribeConfig{Enabled: true, URL: "/api/v1/unsubscribe", Secret: "secret"},
+			expectedErr: ErrInvalidConfig,
+		},
+		{
+			name:        "Should return ErrInvalidConfig due to missing secret",
+			cfg:         UnsubscribeConfig{Enabled: true, URL: mockUnsubscribeCfg.URL},
+			expectedErr: ErrInvalidConfig,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.cfg.validate(); !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected err: %v\nBut got: %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package queue
+
+import (
+	"context"
+	"errors"
+	"math"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defAutoscaleInterval = 30
+	defDrainTime         = 300
+	defThrottleBackoff   = 30
+
+	// defLatency is the processing latency assumed
+	// before any message has been processed.
+	defLatency = time.Second
+)
+
+var (
+	// ErrThrottled indicates that the processing of a message failed because
+	// a downstream service is throttling the requests, e.g.: SES max send
+	// rate exceeded. Processors must wrap it so consumers back off.
+	ErrThrottled = errors.New("Throttled")
+	// ErrInvalidAutoscaleConfig indicates that the autoscaling configuration is not valid.
+	ErrInvalidAutoscaleConfig = errors.New("Invalid autoscale configuration")
+)
+
+// AutoscaleConfig is the configuration to scale the number of
+// consumers of a queue between MinConsumers and MaxConsumers.
+// The number of consumers is adjusted every Interval seconds so the
+// approximate number of messages in the queue would be processed in
+// DrainTime seconds, according to the observed processing latency.
+// When processing is throttled, consumers wait ThrottleBackoff seconds
+// before reading again and the number of consumers is halved.
+type AutoscaleConfig struct {
+	Enabled         bool  `toml:"enabled"`
+	MinConsumers    uint8 `toml:"min_consumers"`
+	MaxConsumers    uint8 `toml:"max_consumers"`
+	Interval        int64 `toml:"interval"`
+	DrainTime       int64 `toml:"drain_time"`
+	ThrottleBackoff int64 `toml:"throttle_backoff"`
+}
+
+func (c AutoscaleConfig) validate() error {
+	if c.Enabled && (c.MaxConsumers == 0 || c.MinConsumers > c.MaxConsumers) {
+		return ErrInvalidAutoscaleConfig
+	}
+	return nil
+}
+
+func (c AutoscaleConfig) interval() time.Duration {
+	return durationOrDefault(c.Interval, defAutoscaleInterval)
+}
+
+func (c AutoscaleConfig) drainTime() time.Duration {
+	return durationOrDefault(c.DrainTime, defDrainTime)
+}
+
+func (c AutoscaleConfig) throttleBackoff() time.Duration {
+	return durationOrDefault(c.ThrottleBackoff, defThrottleBackoff)
+}
+
+func durationOrDefault(seconds, def int64) time.Duration {
+	if seconds <= 0 {
+		seconds = def
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// queueStats gathers the processing statistics of the
+// consumers of a queue between autoscaling rounds.
+type queueStats struct {
+	mu        sync.Mutex
+	processed int
+	latency   time.Duration
+	throttled bool
+}
+
+// record records the processing of a message.
+func (s *queueStats) record(elapsed time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.processed++
+	s.latency += elapsed
+	if errors.Is(err, ErrThrottled) {
+		s.throttled = true
+	}
+}
+
+// reset returns the average latency of the recorded messages and
+// whether any of them was throttled, and resets the statistics.
+// If no messages were recorded, the average latency is zero.
+func (s *queueStats) reset() (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var avg time.Duration
+	if s.processed > 0 {
+		avg = s.latency / time.Duration(s.processed)
+	}
+	throttled := s.throttled
+	s.processed, s.latency, s.throttled = 0, 0, false
+	return avg, throttled
+}
+
+// desiredConsumers returns the number of consumers required to process
+// the messages in the queue in the drain time, given the processing
+// latency. If throttled, the current number of consumers is halved.
+func desiredConsumers(cfg AutoscaleConfig, current uint8, depth int, latency time.Duration, throttled bool) uint8 {
+	var desired float64
+	if throttled {
+		desired = math.Floor(float64(current) / 2)
+	} else {
+		desired = math.Ceil(float64(depth) * latency.Seconds() / cfg.drainTime().Seconds())
+	}
+
+	return clampConsumers(cfg, uint8(math.Min(desired, math.MaxUint8)))
+}
+
+// clampConsumers returns n limited to the configured
+// minimum and maximum number of consumers.
+func clampConsumers(cfg AutoscaleConfig, n uint8) uint8 {
+	if n < cfg.MinConsumers {
+		return cfg.MinConsumers
+	}
+	if n > cfg.MaxConsumers {
+		return cfg.MaxConsumers
+	}
+	return n
+}
+
+// autoscale periodically adjusts the number of consumers of
+// the queue until ctx is done.
+func (g *SQSConsumerGroup) autoscale(ctx context.Context, wg *sync.WaitGroup, q *sqsQueue) {
+	defer func() {
+		if err := recover(); err != nil {
+			g.logger.WithFields(log.Fields{
+				"err":   err,
+				"trace": string(debug.Stack()),
+			}).Error("Autoscaler stopping due to panic err")
+		}
+
+		wg.Done()
+	}()
+
+	cfg := q.config.Autoscale
+	ticker := time.NewTicker(cfg.interval())
+	defer ticker.Stop()
+
+	var lastLatency time.Duration = defLatency
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		depth, err := q.approximateDepth(ctx)
+		if err != nil {
+			g.logger.WithError(err).WithField("queue", q.config.Name()).Error("Error reading queue depth")
+			continue
+		}
+		latency, throttled := q.stats.reset()
+		if latency > 0 {
+			lastLatency = latency
+		}
+
+		g.mu.Lock()
+		current := uint8(len(q.consumers))
+		desired := desiredConsumers(cfg, current, depth, lastLatency, throttled)
+		if desired != current {
+			g.logger.WithFields(log.Fields{
+				"queue":     q.config.Name(),
+				"depth":     depth,
+				"latency":   lastLatency.String(),
+				"throttled": throttled,
+				"from":      current,
+				"to":        desired,
+			}).Info("Scaling queue consumers")
+			g.scale(q, desired)
+		}
+		g.mu.Unlock()
+	}
+}
+
+// approximateDepth returns the approximate number of messages
+// available to be read from the queue.
+func (q *sqsQueue) approximateDepth(ctx context.Context) (int, error) {
+	resp, err := q.sqs.GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl: aws.String(q.sqsURL),
+		AttributeNames: []*string{
+			aws.String(sqs.QueueAttributeNameApproximateNumberOfMessages),
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(aws.StringValue(resp.Attributes[sqs.QueueAttributeNameApproximateNumberOfMessages]))
+}
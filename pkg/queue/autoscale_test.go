@@ -0,0 +1,82 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDesiredConsumers(t *testing.T) {
+	cfg := AutoscaleConfig{
+		Enabled:      true,
+		MinConsumers: 1,
+		MaxConsumers: 10,
+		DrainTime:    60,
+	}
+
+	testCases := []struct {
+		name      string
+		current   uint8
+		depth     int
+		latency   time.Duration
+		throttled bool
+		expected  uint8
+	}{
+		{
+			name:     "Should scale to min when queue is empty",
+			current:  5,
+			depth:    0,
+			latency:  time.Second,
+			expected: 1,
+		},
+		{
+			name:     "Should scale to drain the queue in time",
+			current:  1,
+			depth:    240,
+			latency:  time.Second,
+			expected: 4,
+		},
+		{
+			name:     "Should scale up with latency",
+			current:  1,
+			depth:    240,
+			latency:  2 * time.Second,
+			expected: 8,
+		},
+		{
+			name:     "Should not scale beyond max",
+			current:  1,
+			depth:    10000,
+			latency:  time.Second,
+			expected: 10,
+		},
+		{
+			name:      "Should halve consumers when throttled",
+			current:   8,
+			depth:     10000,
+			latency:   time.Second,
+			throttled: true,
+			expected:  4,
+		},
+		{
+			name:      "Should not scale below min when throttled",
+			current:   1,
+			depth:     10000,
+			latency:   time.Second,
+			throttled: true,
+			expected:  1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := desiredConsumers(cfg, tc.current, tc.depth, tc.latency, tc.throttled)
+			if got != tc.expected {
+				t.Fatalf("Expected consumers to be: %d\nBut got: %d", tc.expected, got)
+			}
+		})
+	}
+}
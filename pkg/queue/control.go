@@ -0,0 +1,129 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// ConsumerIdle indicates that the consumer is waiting for messages.
+	ConsumerIdle = "idle"
+	// ConsumerProcessing indicates that the consumer is processing a message.
+	ConsumerProcessing = "processing"
+	// ConsumerPaused indicates that the consumer does not read messages
+	// because the consumption is paused, or because it is giving way to
+	// the consumers of queues with higher priority.
+	ConsumerPaused = "paused"
+)
+
+var (
+	// ErrQueueNotFound indicates that there is no queue with the specified name.
+	ErrQueueNotFound = errors.New("Queue not found")
+)
+
+// Controller represents a consumer whose consumption
+// can be managed at runtime.
+type Controller interface {
+	// Pause stops reading messages from the queues. The messages
+	// already read are processed before the consumers pause.
+	Pause()
+	// Resume resumes reading messages from the queues.
+	Resume()
+	// Paused returns true if the consumption is paused.
+	Paused() bool
+	// State returns the state of every consumer.
+	State() []ConsumerState
+	// SetConsumers changes the number of consumers
+	// reading from the specified queue.
+	SetConsumers(queue string, n uint8) error
+}
+
+// ConsumerState represents the state of a consumer.
+type ConsumerState struct {
+	ID                int
+	Queue             string
+	Status            string
+	LastError         string
+	LastErrorAt       time.Time
+	MessagesProcessed uint64
+}
+
+// consumerState holds the state of a consumer,
+// which is updated concurrently with its reads.
+type consumerState struct {
+	mu    sync.Mutex
+	state ConsumerState
+}
+
+func (s *consumerState) setStatus(status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.Status = status
+}
+
+// processed records the result of processing a message.
+func (s *consumerState) processed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.MessagesProcessed++
+	if err != nil {
+		s.state.LastError = err.Error()
+		s.state.LastErrorAt = time.Now()
+	}
+}
+
+func (s *consumerState) get() ConsumerState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// pauseGate blocks the consumers while the consumption is paused.
+type pauseGate struct {
+	mu      sync.Mutex
+	resumed chan struct{}
+}
+
+func (g *pauseGate) pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed == nil {
+		g.resumed = make(chan struct{})
+	}
+}
+
+func (g *pauseGate) resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed != nil {
+		close(g.resumed)
+		g.resumed = nil
+	}
+}
+
+func (g *pauseGate) paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.resumed != nil
+}
+
+// wait blocks until the consumption is resumed or ctx is done.
+func (g *pauseGate) wait(ctx context.Context) {
+	g.mu.Lock()
+	resumed := g.resumed
+	g.mu.Unlock()
+
+	if resumed == nil {
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case <-resumed:
+	}
+}
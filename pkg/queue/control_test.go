@@ -0,0 +1,168 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	log "github.com/sirupsen/logrus"
+)
+
+type countingSQSMock struct {
+	sqsiface.SQSAPI
+	receiveCalls int64
+}
+
+func (m *countingSQSMock) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	atomic.AddInt64(&m.receiveCalls, 1)
+	// Simulate a short long polling.
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Millisecond):
+	}
+	return &sqs.ReceiveMessageOutput{}, nil
+}
+
+func TestSQSConsumerGroupControl(t *testing.T) {
+	sqsMock := &countingSQSMock{}
+	group := &SQSConsumerGroup{
+		queues: []*sqsQueue{
+			{
+				config: SQSQueueConfig{
+					SQSConfig:  SQSConfig{QueueName: "reports"},
+					NConsumers: 2,
+				},
+				sqs: sqsMock,
+			},
+		},
+		processor: &mockProcessor{},
+		gate:      newPriorityGate(),
+		pause:     &pauseGate{},
+		logger:    log.New(),
+	}
+
+	group.Pause()
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	group.Start(ctx, &wg)
+
+	time.Sleep(50 * time.Millisecond)
+	if calls := atomic.LoadInt64(&sqsMock.receiveCalls); calls != 0 {
+		t.Fatalf("Expected no receive calls while paused, but got: %d", calls)
+	}
+	states := group.State()
+	if len(states) != 2 || states[0].Status != ConsumerPaused || states[0].Queue != "reports" {
+		t.Fatalf("Unexpected consumers state: %+v", states)
+	}
+
+	if err := group.SetConsumers("reports", 1); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if states := group.State(); len(states) != 1 {
+		t.Fatalf("Expected 1 consumer, but got: %+v", states)
+	}
+	if err := group.SetConsumers("unknown", 1); !errors.Is(err, ErrQueueNotFound) {
+		t.Fatalf("Expected err: %v\nBut got: %v", ErrQueueNotFound, err)
+	}
+
+	group.Resume()
+	if group.Paused() {
+		t.Fatalf("Expected consumption to be resumed")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if calls := atomic.LoadInt64(&sqsMock.receiveCalls); calls == 0 {
+		t.Fatalf("Expected receive calls once resumed")
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+type blockingProcessor struct {
+	started chan struct{}
+	release chan struct{}
+	err     error
+}
+
+func (p *blockingProcessor) ProcessMessage(ctx context.Context, mssg Message) error {
+	close(p.started)
+	select {
+	case <-ctx.Done():
+		p.err = ctx.Err()
+	case <-p.release:
+	}
+	return p.err
+}
+
+func TestSQSConsumerGroupScaleDownWhileProcessing(t *testing.T) {
+	sqsMock := &sqsMock{
+		returnMssgs: 1,
+	}
+	processor := &blockingProcessor{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	group := &SQSConsumerGroup{
+		queues: []*sqsQueue{
+			{
+				config: SQSQueueConfig{
+					SQSConfig:  SQSConfig{QueueName: "reports", Timeout: 30},
+					NConsumers: 1,
+				},
+				sqs: sqsMock,
+			},
+		},
+		processor: processor,
+		gate:      newPriorityGate(),
+		pause:     &pauseGate{},
+		logger:    log.New(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	group.Start(ctx, &wg)
+
+	select {
+	case <-processor.started:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected message to be processed")
+	}
+	if err := group.SetConsumers("reports", 0); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if states := group.State(); len(states) != 0 {
+		t.Fatalf("Expected no consumers, but got: %+v", states)
+	}
+	close(processor.release)
+
+	// The stopped consumer finishes processing
+	// the message and does not read new ones.
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected stopped consumer to finish")
+	}
+	if processor.err != nil {
+		t.Fatalf("Expected processing not to be aborted, but got: %v", processor.err)
+	}
+	if sqsMock.receiveCalls != 1 || sqsMock.deleteCalls != 1 {
+		t.Fatalf("Expected 1 receive and 1 delete calls, but got: %d %d", sqsMock.receiveCalls, sqsMock.deleteCalls)
+	}
+}
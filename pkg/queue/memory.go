@@ -0,0 +1,95 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package queue
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const memoryQueueName = "memory"
+
+// MemoryConsumer is a Consumer backed by a Go channel, meant for
+// tests. Messages are sent to it through its Send method, so it
+// also implements the Producer interface.
+// Failed messages are not retried. If the Errors channel is set,
+// the result of processing each message is reported through it.
+type MemoryConsumer struct {
+	nConsumers uint8
+	mssgs      chan Message
+	processor  Processor
+	logger     *log.Logger
+
+	Errors chan error
+}
+
+// NewMemoryConsumer creates a new MemoryConsumer which
+// buffers up to size messages not yet processed.
+func NewMemoryConsumer(nConsumers uint8, size int, processor Processor, logger *log.Logger) *MemoryConsumer {
+	return &MemoryConsumer{
+		nConsumers: nConsumers,
+		mssgs:      make(chan Message, size),
+		processor:  processor,
+		logger:     logger,
+	}
+}
+
+// Send enqueues a message, blocking while the buffer is full.
+func (c *MemoryConsumer) Send(ctx context.Context, mssg Message) error {
+	if mssg.Queue == "" {
+		mssg.Queue = memoryQueueName
+	}
+	if mssg.ReceiveCount == 0 {
+		mssg.ReceiveCount = 1
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case c.mssgs <- mssg:
+		return nil
+	}
+}
+
+// Start makes the consumers start processing the sent messages.
+func (c *MemoryConsumer) Start(ctx context.Context, wg *sync.WaitGroup) {
+	for i := uint8(0); i < c.nConsumers; i++ {
+		wg.Add(1)
+		go c.start(ctx, wg)
+	}
+}
+
+func (c *MemoryConsumer) start(ctx context.Context, wg *sync.WaitGroup) {
+	defer func() {
+		if err := recover(); err != nil {
+			c.logger.WithFields(log.Fields{
+				"err":   err,
+				"trace": string(debug.Stack()),
+			}).Error("Consumer stopping due to panic err")
+		}
+
+		wg.Done()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case mssg := <-c.mssgs:
+			err := c.processor.ProcessMessage(ctx, mssg)
+			if err != nil {
+				c.logger.WithError(err).WithFields(log.Fields{
+					"body": mssg.Body,
+				}).Error("Error processing memory message")
+			}
+			if c.Errors != nil {
+				c.Errors <- err
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestMemoryConsumer(t *testing.T) {
+	processor := &mockBodyProcessor{failBody: "fail"}
+	consumer := NewMemoryConsumer(1, 2, processor, log.New())
+	consumer.Errors = make(chan error)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	consumer.Start(ctx, &wg)
+
+	for _, body := range []string{"ok", "fail"} {
+		if err := consumer.Send(ctx, Message{Body: body}); err != nil {
+			t.Fatalf("Expected no error, but got: %v", err)
+		}
+	}
+	if err := <-consumer.Errors; err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if err := <-consumer.Errors; err == nil {
+		t.Fatalf("Expected processing error")
+	}
+
+	cancel()
+	wg.Wait()
+
+	if len(processor.mssgs) != 2 || processor.mssgs[0].Queue != memoryQueueName {
+		t.Fatalf("Unexpected processed messages: %v", processor.mssgs)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// defMaxPriorityWait is the default time consumers wait for
+	// queues with higher priority before reading anyway, in seconds.
+	defMaxPriorityWait = 60
+)
+
+// priorityGate coordinates the consumers of queues with different
+// priorities. Consumers of a queue do not read new messages while
+// messages from queues with a higher priority are being processed,
+// but only up to a max wait time, so a steady flow of higher
+// priority messages can not starve the lower priority queues.
+type priorityGate struct {
+	mu      sync.Mutex
+	busy    map[int]int
+	changed chan struct{}
+}
+
+func newPriorityGate() *priorityGate {
+	return &priorityGate{
+		busy:    map[int]int{},
+		changed: make(chan struct{}),
+	}
+}
+
+// wait blocks until no messages from queues with a priority
+// higher than the specified one are being processed, maxWait
+// has elapsed or ctx is done.
+func (g *priorityGate) wait(ctx context.Context, priority int, maxWait time.Duration) {
+	timeout := time.NewTimer(maxWait)
+	defer timeout.Stop()
+
+	for {
+		g.mu.Lock()
+		blocked := g.isBlocked(priority)
+		changed := g.changed
+		g.mu.Unlock()
+
+		if !blocked {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-timeout.C:
+			return
+		case <-changed:
+		}
+	}
+}
+
+// blocked returns true if consumers of the
+// specified priority must wait for their turn.
+func (g *priorityGate) blocked(priority int) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.isBlocked(priority)
+}
+
+func (g *priorityGate) isBlocked(priority int) bool {
+	for p, n := range g.busy {
+		if p > priority && n > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// acquire marks a batch of messages of the specified
+// priority as being processed.
+func (g *priorityGate) acquire(priority int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.busy[priority]++
+}
+
+// release marks a batch of messages of the specified priority as
+// processed and wakes up the consumers waiting for their turn.
+func (g *priorityGate) release(priority int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.busy[priority]--
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPriorityGate(t *testing.T) {
+	gate := newPriorityGate()
+	gate.acquire(10)
+
+	// Same and higher priorities are not blocked.
+	gate.wait(context.Background(), 10, time.Minute)
+	gate.wait(context.Background(), 20, time.Minute)
+
+	done := make(chan struct{})
+	go func() {
+		gate.wait(context.Background(), 0, time.Minute)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatalf("Expected lower priority to wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	gate.release(10)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected lower priority to resume after release")
+	}
+
+	gate.acquire(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	gate.wait(ctx, 0, time.Minute)
+}
+
+func TestPriorityGateMaxWait(t *testing.T) {
+	gate := newPriorityGate()
+	gate.acquire(10)
+
+	// Lower priorities read anyway once they have waited too long.
+	done := make(chan struct{})
+	go func() {
+		gate.wait(context.Background(), 0, 50*time.Millisecond)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected lower priority to resume after max wait")
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package queue
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// Producer represents a producer for a queue.
+type Producer interface {
+	Send(ctx context.Context, mssg Message) error
+}
+
+// SQSProducer is the SQS implementation of the Producer interface.
+// Messages are sent raw, without SNS envelope, and their
+// attributes are sent as SQS string attributes.
+type SQSProducer struct {
+	sqsURL string
+	fifo   bool
+	sqs    sqsiface.SQSAPI
+}
+
+// NewSQSProducer creates a new SQSProducer for the configured queue.
+func NewSQSProducer(config SQSConfig) (*SQSProducer, error) {
+	sqsSvc, sqsURL, err := newSQSClient(config.QueueArn, config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SQSProducer{
+		sqsURL: sqsURL,
+		fifo:   config.isFIFO(),
+		sqs:    sqsSvc,
+	}, nil
+}
+
+// Send sends a message to the queue. For FIFO queues, the message
+// GroupID and ID are used as the SQS message group and
+// deduplication IDs respectively.
+func (p *SQSProducer) Send(ctx context.Context, mssg Message) error {
+	input := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(p.sqsURL),
+		MessageBody: aws.String(mssg.Body),
+	}
+	if len(mssg.Attributes) > 0 {
+		input.MessageAttributes = map[string]*sqs.MessageAttributeValue{}
+		for k, v := range mssg.Attributes {
+			input.MessageAttributes[k] = &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(v),
+			}
+		}
+	}
+	if p.fifo {
+		input.MessageGroupId = aws.String(mssg.GroupID)
+		if mssg.ID != "" {
+			input.MessageDeduplicationId = aws.String(mssg.ID)
+		}
+	}
+
+	_, err := p.sqs.SendMessageWithContext(ctx, input)
+	return err
+}
+
+// SNSConfig is the configuration of an SNS topic to publish messages to.
+type SNSConfig struct {
+	TopicArn string `toml:"topic_arn"`
+	Endpoint string `toml:"endpoint"`
+}
+
+// SNSProducer is the SNS implementation of the Producer interface.
+// The message attributes are published as SNS string attributes,
+// so subscriptions can filter on them.
+type SNSProducer struct {
+	topicArn string
+	fifo     bool
+	sns      snsiface.SNSAPI
+}
+
+// NewSNSProducer creates a new SNSProducer for the configured topic.
+func NewSNSProducer(config SNSConfig) (*SNSProducer, error) {
+	awsSess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	arn, err := arn.Parse(config.TopicArn)
+	if err != nil {
+		return nil, err
+	}
+
+	awsCfg := aws.NewConfig()
+	if arn.Region != "" {
+		awsCfg = awsCfg.WithRegion(arn.Region)
+	}
+	if config.Endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(config.Endpoint)
+	}
+
+	return &SNSProducer{
+		topicArn: config.TopicArn,
+		fifo:     strings.HasSuffix(arn.Resource, fifoSuffix),
+		sns:      sns.New(awsSess, awsCfg),
+	}, nil
+}
+
+// Send publishes a message to the topic. For FIFO topics, the message
+// GroupID and ID are used as the SNS message group and
+// deduplication IDs respectively.
+func (p *SNSProducer) Send(ctx context.Context, mssg Message) error {
+	input := &sns.PublishInput{
+		TopicArn: aws.String(p.topicArn),
+		Message:  aws.String(mssg.Body),
+	}
+	if len(mssg.Attributes) > 0 {
+		input.MessageAttributes = map[string]*sns.MessageAttributeValue{}
+		for k, v := range mssg.Attributes {
+			input.MessageAttributes[k] = &sns.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(v),
+			}
+		}
+	}
+	if p.fifo {
+		input.MessageGroupId = aws.String(mssg.GroupID)
+		if mssg.ID != "" {
+			input.MessageDeduplicationId = aws.String(mssg.ID)
+		}
+	}
+
+	_, err := p.sns.PublishWithContext(ctx, input)
+	return err
+}
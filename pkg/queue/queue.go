@@ -6,15 +6,55 @@ package queue
 
 import (
 	"context"
+	"errors"
 	"sync"
+	"time"
 )
 
-// Consumer represents a consumer for a queue.
+const (
+	// BackendSQS identifies the SQS queue backend.
+	BackendSQS = "sqs"
+	// BackendSpool identifies the local directory spool queue backend.
+	BackendSpool = "spool"
+)
+
+var (
+	// ErrInvalidBackend indicates that the queue backend configuration is not valid.
+	ErrInvalidBackend = errors.New("Invalid queue backend")
+	// ErrUnprocessable indicates that the message can never be processed,
+	// e.g.: it requests an unsupported report type, so retrying it would
+	// fail the same way. Processors must wrap it so consumers discard the
+	// message instead of retrying it.
+	ErrUnprocessable = errors.New("Unprocessable message")
+)
+
+// Consumer represents a consumer for a queue, which
+// hands the messages over to its processor.
 type Consumer interface {
-	Start(ctx context.Context, wg *sync.WaitGroup, processor Processor)
+	Start(ctx context.Context, wg *sync.WaitGroup)
 }
 
 // Processor represents a queue message processor.
+// The processing of the message must be aborted once
+// ctx is done, e.g.: when the message deadline is reached.
 type Processor interface {
-	ProcessMessage(mssg string) error
+	ProcessMessage(ctx context.Context, mssg Message) error
+}
+
+// Message represents a message read from a queue
+// once its transport envelope has been removed.
+// GroupID identifies the group of messages that
+// must be processed in order, e.g.: in FIFO queues.
+// Queue is the name of the queue the message was read from.
+// ReceiveCount is the number of times the message has been
+// received from the queue, including the current one.
+// SentAt is the time the message was sent to the queue, if known.
+type Message struct {
+	ID           string
+	Body         string
+	Attributes   map[string]string
+	GroupID      string
+	Queue        string
+	ReceiveCount int
+	SentAt       time.Time
 }
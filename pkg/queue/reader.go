@@ -0,0 +1,92 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package queue
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defReaderVisibility is the default visibility timeout, in seconds,
+	// of the messages read by an SQSReader. It must be long enough
+	// for the whole queue to be read, otherwise messages would be
+	// read more than once.
+	defReaderVisibility = 600
+	// readerWaitTime is the wait time, in seconds, of the receives of
+	// an SQSReader. Long polling queries every SQS server, so an empty
+	// receive means that the queue is empty, unlike short polling.
+	readerWaitTime = 20
+)
+
+// SQSReader reads every message available in
+// a queue once, e.g.: from a dead letter queue.
+type SQSReader struct {
+	consumer *SQSConsumer
+}
+
+// NewSQSReader creates a new SQSReader.
+func NewSQSReader(config SQSConfig, logger *log.Logger) (*SQSReader, error) {
+	if !isValidEnvelope(config.Envelope) {
+		return nil, ErrInvalidEnvelope
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defReaderVisibility
+	}
+
+	sqsSvc, sqsURL, err := newSQSClient(config.QueueArn, config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SQSReader{
+		consumer: &SQSConsumer{
+			config:      config,
+			sqsURL:      sqsURL,
+			sqsWaitTime: readerWaitTime,
+			sqs:         sqsSvc,
+			logger:      logger,
+		},
+	}, nil
+}
+
+// Read calls fn for every message available in the queue, until
+// a receive with long polling returns no more messages. The messages for which fn returns
+// true are deleted from the queue. The rest become visible again
+// once their visibility timeout expires.
+func (r *SQSReader) Read(ctx context.Context, fn func(ctx context.Context, mssg Message) (bool, error)) error {
+	c := r.consumer
+	for {
+		mssgs, err := c.readMssgs(ctx)
+		if err != nil {
+			return err
+		}
+		if len(mssgs) == 0 {
+			return nil
+		}
+
+		for _, mssg := range mssgs {
+			queueMssg, err := c.validateMssg(mssg)
+			if err != nil {
+				c.logger.WithError(err).WithFields(log.Fields{
+					"mssg": mssg,
+				}).Error("Invalid SQS message")
+				continue
+			}
+
+			remove, err := fn(ctx, queueMssg)
+			if err != nil {
+				return err
+			}
+			if !remove {
+				continue
+			}
+			if err := c.deleteMessage(mssg); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	// EnvelopeAuto indicates that the SNS envelope must be
+	// detected for every message, accepting also raw messages.
+	EnvelopeAuto = "auto"
+	// EnvelopeSNS indicates that every message is expected
+	// to be wrapped in an SNS notification envelope.
+	EnvelopeSNS = "sns"
+	// EnvelopeRaw indicates that messages are delivered
+	// straight to SQS or through SNS raw message delivery.
+	EnvelopeRaw = "raw"
+)
+
+var (
+	// ErrInvalidEnvelope indicates that the configured envelope mode is not valid.
+	ErrInvalidEnvelope = errors.New("Invalid envelope mode")
+	// ErrMissingSNSEnvelope indicates that the message is not wrapped in an SNS envelope.
+	ErrMissingSNSEnvelope = errors.New("unexpected mssg format: Expected SNS envelope")
+
+	// snsEnvelopeFields are the fields that every SNS
+	// notification envelope contains.
+	snsEnvelopeFields = []string{"Type", "TopicArn", "Message"}
+)
+
+// snsNotification represents the envelope that SNS
+// puts around the messages published to a topic.
+type snsNotification struct {
+	Type              string                      `json:"Type"`
+	MessageID         string                      `json:"MessageId"`
+	TopicArn          string                      `json:"TopicArn"`
+	Subject           string                      `json:"Subject"`
+	Message           string                      `json:"Message"`
+	Timestamp         string                      `json:"Timestamp"`
+	SignatureVersion  string                      `json:"SignatureVersion"`
+	Signature         string                      `json:"Signature"`
+	SigningCertURL    string                      `json:"SigningCertURL"`
+	SubscribeURL      string                      `json:"SubscribeURL"`
+	Token             string                      `json:"Token"`
+	MessageAttributes map[string]snsMessageAttrib `json:"MessageAttributes"`
+}
+
+type snsMessageAttrib struct {
+	Type  string `json:"Type"`
+	Value string `json:"Value"`
+}
+
+func isValidEnvelope(envelope string) bool {
+	switch envelope {
+	case "", EnvelopeAuto, EnvelopeSNS, EnvelopeRaw:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseSNSEnvelope returns the SNS notification contained in
+// the given message body, and a boolean indicating if the body
+// actually has an SNS envelope.
+// Fields are checked with their exact name because JSON decoding
+// is case insensitive and raw requests may contain similar fields,
+// e.g.: "type".
+func parseSNSEnvelope(body string) (snsNotification, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(body), &fields); err != nil {
+		return snsNotification{}, false
+	}
+	for _, f := range snsEnvelopeFields {
+		if _, ok := fields[f]; !ok {
+			return snsNotification{}, false
+		}
+	}
+
+	var notif snsNotification
+	if err := json.Unmarshal([]byte(body), &notif); err != nil {
+		return snsNotification{}, false
+	}
+	return notif, true
+}
+
+// attributes returns the SNS message attributes
+// as a map of attribute names and values.
+func (n snsNotification) attributes() map[string]string {
+	attrs := map[string]string{}
+	for name, attr := range n.MessageAttributes {
+		attrs[name] = attr.Value
+	}
+	return attrs
+}
+
+// unwrapMessage removes the envelope, if any, around the
+// given message body based on the specified envelope mode.
+// If a verifier is supplied, only messages wrapped in an SNS
+// envelope with a valid signature are accepted.
+func unwrapMessage(envelope, body string, verifier *snsVerifier) (Message, error) {
+	switch envelope {
+	case EnvelopeRaw:
+		if verifier != nil {
+			return Message{}, ErrMissingSNSEnvelope
+		}
+		return Message{Body: body}, nil
+	case "", EnvelopeAuto, EnvelopeSNS:
+		notif, ok := parseSNSEnvelope(body)
+		if !ok {
+			if envelope == EnvelopeSNS || verifier != nil {
+				return Message{}, ErrMissingSNSEnvelope
+			}
+			return Message{Body: body}, nil
+		}
+		if verifier != nil {
+			if err := verifier.verify(notif); err != nil {
+				return Message{}, err
+			}
+		}
+		return Message{
+			Body:       notif.Message,
+			Attributes: notif.attributes(),
+		}, nil
+	default:
+		return Message{}, fmt.Errorf("%w: %s", ErrInvalidEnvelope, envelope)
+	}
+}
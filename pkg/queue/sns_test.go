@@ -0,0 +1,108 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package queue
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const (
+	mockSNSNotif = `
+	{
+		"Type" : "Notification",
+		"MessageId" : "22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324",
+		"TopicArn" : "arn:aws:sns:us-west-2:123456789012:MyTopic",
+		"Subject" : "My First Message",
+		"Message" : "{\"type\":\"livereport\"}",
+		"Timestamp" : "2012-05-02T00:54:06.655Z",
+		"SignatureVersion" : "1",
+		"Signature" : "EXAMPLEw6JRN...",
+		"SigningCertURL" : "https://sns.us-west-2.amazonaws.com/mock.pem",
+		"MessageAttributes" : {
+			"team_id" : {"Type":"String","Value":"1"}
+		}
+	}`
+
+	mockRawMssg = `{"type":"livereport","team_info":{"id":"1"},"data":{"message":"hello"}}`
+)
+
+func TestUnwrapMessage(t *testing.T) {
+	testCases := []struct {
+		name        string
+		envelope    string
+		body        string
+		expected    Message
+		expectedErr error
+	}{
+		{
+			name:     "Should remove SNS envelope in SNS mode",
+			envelope: EnvelopeSNS,
+			body:     mockSNSNotif,
+			expected: Message{
+				Body:       `{"type":"livereport"}`,
+				Attributes: map[string]string{"team_id": "1"},
+			},
+		},
+		{
+			name:        "Should return ErrMissingSNSEnvelope in SNS mode",
+			envelope:    EnvelopeSNS,
+			body:        mockRawMssg,
+			expectedErr: ErrMissingSNSEnvelope,
+		},
+		{
+			name:     "Should keep SNS envelope in raw mode",
+			envelope: EnvelopeRaw,
+			body:     mockSNSNotif,
+			expected: Message{
+				Body: mockSNSNotif,
+			},
+		},
+		{
+			name:     "Should detect SNS envelope in auto mode",
+			envelope: EnvelopeAuto,
+			body:     mockSNSNotif,
+			expected: Message{
+				Body:       `{"type":"livereport"}`,
+				Attributes: map[string]string{"team_id": "1"},
+			},
+		},
+		{
+			name:     "Should detect raw message in auto mode",
+			envelope: EnvelopeAuto,
+			body:     mockRawMssg,
+			expected: Message{
+				Body: mockRawMssg,
+			},
+		},
+		{
+			name:     "Should default to auto mode",
+			envelope: "",
+			body:     mockRawMssg,
+			expected: Message{
+				Body: mockRawMssg,
+			},
+		},
+		{
+			name:        "Should return ErrInvalidEnvelope",
+			envelope:    "invalid",
+			body:        mockRawMssg,
+			expectedErr: ErrInvalidEnvelope,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mssg, err := unwrapMessage(tc.envelope, tc.body, nil)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected err: %v\nBut got: %v", tc.expectedErr, err)
+			}
+			if !reflect.DeepEqual(mssg, tc.expected) {
+				t.Fatalf("Expected message: %v\nBut got: %v", tc.expected, mssg)
+			}
+		})
+	}
+}
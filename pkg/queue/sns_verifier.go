@@ -0,0 +1,312 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package queue
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defCertCacheTTL    = 24 * time.Hour
+	certFetchTimeout   = 10 * time.Second
+	maxCertSize        = 64 * 1024
+	pemExt             = ".pem"
+	snsSignatureV1     = "1"
+	snsSignatureV2     = "2"
+	snsNotifType       = "Notification"
+	snsSubscribeType   = "SubscriptionConfirmation"
+	snsUnsubscribeType = "UnsubscribeConfirmation"
+)
+
+var (
+	// ErrInvalidSNSSignature indicates that the SNS message signature is not valid.
+	ErrInvalidSNSSignature = errors.New("Invalid SNS message signature")
+	// ErrUnsupportedSignatureVersion indicates that the SNS signature version is not supported.
+	ErrUnsupportedSignatureVersion = errors.New("Unsupported SNS signature version")
+	// ErrInvalidSigningCertURL indicates that the SNS signing certificate URL is not trusted.
+	ErrInvalidSigningCertURL = errors.New("Invalid SNS signing certificate URL")
+	// ErrTopicNotAllowed indicates that the SNS message was published to a not allowed topic.
+	ErrTopicNotAllowed = errors.New("SNS topic not allowed")
+	// ErrInvalidVerificationConfig indicates that the SNS verification configuration is not valid.
+	ErrInvalidVerificationConfig = errors.New("Invalid SNS verification configuration")
+	// ErrSigningCertUnavailable indicates that the SNS signing certificate could not be
+	// downloaded, e.g.: SNS is not reachable, so the message must be verified later.
+	ErrSigningCertUnavailable = errors.New("SNS signing certificate unavailable")
+
+	// snsCertHostRegexp matches the hosts from which
+	// SNS signing certificates are served.
+	snsCertHostRegexp = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+)
+
+// SNSVerificationConfig is the configuration for
+// the verification of SNS message signatures.
+type SNSVerificationConfig struct {
+	Enabled bool `toml:"enabled"`
+	// AllowedTopicArns is the list of topics that are allowed
+	// to publish messages. It is required if Enabled.
+	AllowedTopicArns []string `toml:"allowed_topic_arns"`
+	// CertsDir is an optional directory containing the pinned
+	// signing certificates as PEM files named after the last
+	// element of the SigningCertURL path. When set, only pinned
+	// certificates are trusted and none is downloaded.
+	CertsDir string `toml:"certs_dir"`
+	// CertCacheTTL is the time in seconds that downloaded
+	// certificates are kept in the local cache.
+	CertCacheTTL int64 `toml:"cert_cache_ttl"`
+}
+
+type cachedCert struct {
+	cert      *x509.Certificate
+	expiresAt time.Time
+}
+
+// snsVerifier verifies the signature of SNS messages.
+type snsVerifier struct {
+	allowedTopics map[string]struct{}
+	pinned        map[string]*x509.Certificate
+	cacheTTL      time.Duration
+	httpClient    *http.Client
+
+	mu    sync.Mutex
+	certs map[string]cachedCert
+}
+
+// newSNSVerifier builds a new SNS signature verifier, loading
+// the pinned certificates if a certificates dir is configured.
+func newSNSVerifier(cfg SNSVerificationConfig) (*snsVerifier, error) {
+	if len(cfg.AllowedTopicArns) == 0 {
+		return nil, fmt.Errorf("%w: allowed topics are required", ErrInvalidVerificationConfig)
+	}
+
+	v := &snsVerifier{
+		allowedTopics: map[string]struct{}{},
+		cacheTTL:      defCertCacheTTL,
+		httpClient:    &http.Client{Timeout: certFetchTimeout},
+		certs:         map[string]cachedCert{},
+	}
+	if cfg.CertCacheTTL > 0 {
+		v.cacheTTL = time.Duration(cfg.CertCacheTTL) * time.Second
+	}
+	for _, topic := range cfg.AllowedTopicArns {
+		v.allowedTopics[topic] = struct{}{}
+	}
+
+	if cfg.CertsDir != "" {
+		pinned, err := loadPinnedCerts(cfg.CertsDir)
+		if err != nil {
+			return nil, err
+		}
+		v.pinned = pinned
+	}
+
+	return v, nil
+}
+
+// verify checks that the SNS notification was published to an
+// allowed topic and that its signature is valid.
+func (v *snsVerifier) verify(notif snsNotification) error {
+	if _, ok := v.allowedTopics[notif.TopicArn]; !ok {
+		return fmt.Errorf("%w: %s", ErrTopicNotAllowed, notif.TopicArn)
+	}
+
+	var algo x509.SignatureAlgorithm
+	switch notif.SignatureVersion {
+	case snsSignatureV1:
+		algo = x509.SHA1WithRSA
+	case snsSignatureV2:
+		algo = x509.SHA256WithRSA
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedSignatureVersion, notif.SignatureVersion)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(notif.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSNSSignature, err)
+	}
+
+	cert, err := v.signingCert(notif.SigningCertURL)
+	if err != nil {
+		return err
+	}
+
+	stringToSign, err := notif.stringToSign()
+	if err != nil {
+		return err
+	}
+	if err := cert.CheckSignature(algo, []byte(stringToSign), signature); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSNSSignature, err)
+	}
+
+	return nil
+}
+
+// signingCert returns the certificate for the given signing
+// certificate URL, either from the pinned certificates or
+// from the local cache, downloading it if needed.
+// The cache is not locked while downloading, so consumers
+// are not blocked by the download of another certificate.
+func (v *snsVerifier) signingCert(certURL string) (*x509.Certificate, error) {
+	u, err := url.Parse(certURL)
+	if err != nil || u.Scheme != "https" || !snsCertHostRegexp.MatchString(u.Host) ||
+		!strings.HasSuffix(u.Path, pemExt) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSigningCertURL, certURL)
+	}
+
+	now := time.Now()
+	if v.pinned != nil {
+		cert, ok := v.pinned[path.Base(u.Path)]
+		if !ok {
+			return nil, fmt.Errorf("%w: certificate not pinned: %s", ErrInvalidSigningCertURL, certURL)
+		}
+		if err := checkCertValidity(cert, now); err != nil {
+			return nil, err
+		}
+		return cert, nil
+	}
+
+	v.mu.Lock()
+	cached, ok := v.certs[certURL]
+	v.mu.Unlock()
+	if ok && now.Before(cached.expiresAt) {
+		return cached.cert, nil
+	}
+
+	cert, err := v.fetchCert(certURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCertValidity(cert, now); err != nil {
+		return nil, err
+	}
+
+	v.mu.Lock()
+	v.certs[certURL] = cachedCert{
+		cert:      cert,
+		expiresAt: now.Add(v.cacheTTL),
+	}
+	v.mu.Unlock()
+
+	return cert, nil
+}
+
+// fetchCert downloads the certificate. It returns ErrSigningCertUnavailable
+// if the download fails, as it does not mean the message is not valid.
+func (v *snsVerifier) fetchCert(certURL string) (*x509.Certificate, error) {
+	resp, err := v.httpClient.Get(certURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSigningCertUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s: status %d", ErrSigningCertUnavailable, certURL, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCertSize))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSigningCertUnavailable, err)
+	}
+
+	cert, err := parsePEMCert(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSNSSignature, err)
+	}
+	return cert, nil
+}
+
+// stringToSign builds the string that SNS signs for each
+// message type, as described in:
+// https://docs.aws.amazon.com/sns/latest/dg/sns-verify-signature-of-message.html
+func (n snsNotification) stringToSign() (string, error) {
+	var fields [][2]string
+	switch n.Type {
+	case snsNotifType:
+		fields = append(fields, [2]string{"Message", n.Message}, [2]string{"MessageId", n.MessageID})
+		if n.Subject != "" {
+			fields = append(fields, [2]string{"Subject", n.Subject})
+		}
+		fields = append(fields,
+			[2]string{"Timestamp", n.Timestamp},
+			[2]string{"TopicArn", n.TopicArn},
+			[2]string{"Type", n.Type},
+		)
+	case snsSubscribeType, snsUnsubscribeType:
+		fields = append(fields,
+			[2]string{"Message", n.Message},
+			[2]string{"MessageId", n.MessageID},
+			[2]string{"SubscribeURL", n.SubscribeURL},
+			[2]string{"Timestamp", n.Timestamp},
+			[2]string{"Token", n.Token},
+			[2]string{"TopicArn", n.TopicArn},
+			[2]string{"Type", n.Type},
+		)
+	default:
+		return "", fmt.Errorf("%w: unknown message type %q", ErrInvalidSNSSignature, n.Type)
+	}
+
+	var b strings.Builder
+	for _, f := range fields {
+		b.WriteString(f[0])
+		b.WriteByte('\n')
+		b.WriteString(f[1])
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// loadPinnedCerts loads every PEM certificate in dir
+// indexing them by their file name.
+func loadPinnedCerts(dir string) (map[string]*x509.Certificate, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	certs := map[string]*x509.Certificate{}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != pemExt {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		cert, err := parsePEMCert(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidVerificationConfig, e.Name(), err)
+		}
+		certs[e.Name()] = cert
+	}
+
+	return certs, nil
+}
+
+func parsePEMCert(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func checkCertValidity(cert *x509.Certificate, now time.Time) error {
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return fmt.Errorf("%w: certificate expired or not yet valid", ErrInvalidSigningCertURL)
+	}
+	return nil
+}
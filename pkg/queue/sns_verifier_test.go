@@ -0,0 +1,247 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package queue
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	mockTopicArn     = "arn:aws:sns:eu-west-1:123456789012:vulcan-api"
+	mockCertName     = "SimpleNotificationService-mock.pem"
+	mockCertURL      = "https://sns.eu-west-1.amazonaws.com/" + mockCertName
+	mockUnpinnedCert = "https://sns.eu-west-1.amazonaws.com/SimpleNotificationService-other.pem"
+)
+
+// pinMockCert generates a self-signed certificate, writes it
+// to a temporary dir and returns the dir and the private key.
+func pinMockCert(t *testing.T) (string, *rsa.PrivateKey) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %v", err)
+	}
+
+	dir := t.TempDir()
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, mockCertName), pemData, 0600); err != nil {
+		t.Fatalf("Error writing certificate: %v", err)
+	}
+
+	return dir, key
+}
+
+func signNotif(t *testing.T, key *rsa.PrivateKey, notif snsNotification) snsNotification {
+	t.Helper()
+
+	stringToSign, err := notif.stringToSign()
+	if err != nil {
+		t.Fatalf("Error building string to sign: %v", err)
+	}
+
+	var sig []byte
+	switch notif.SignatureVersion {
+	case snsSignatureV1:
+		digest := sha1.Sum([]byte(stringToSign))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA1, digest[:])
+	default:
+		digest := sha256.Sum256([]byte(stringToSign))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	}
+	if err != nil {
+		t.Fatalf("Error signing notification: %v", err)
+	}
+
+	notif.Signature = base64.StdEncoding.EncodeToString(sig)
+	return notif
+}
+
+func TestSNSVerify(t *testing.T) {
+	certsDir, key := pinMockCert(t)
+
+	baseNotif := snsNotification{
+		Type:           snsNotifType,
+		MessageID:      "22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324",
+		TopicArn:       mockTopicArn,
+		Message:        `{"type":"livereport"}`,
+		Timestamp:      "2021-05-02T00:54:06.655Z",
+		SigningCertURL: mockCertURL,
+	}
+
+	testCases := []struct {
+		name        string
+		notif       func() snsNotification
+		expectedErr error
+	}{
+		{
+			name: "Should verify SignatureVersion 1",
+			notif: func() snsNotification {
+				n := baseNotif
+				n.SignatureVersion = snsSignatureV1
+				return signNotif(t, key, n)
+			},
+		},
+		{
+			name: "Should verify SignatureVersion 2 with subject",
+			notif: func() snsNotification {
+				n := baseNotif
+				n.SignatureVersion = snsSignatureV2
+				n.Subject = "subject"
+				return signNotif(t, key, n)
+			},
+		},
+		{
+			name: "Should return ErrInvalidSNSSignature for tampered message",
+			notif: func() snsNotification {
+				n := baseNotif
+				n.SignatureVersion = snsSignatureV2
+				n = signNotif(t, key, n)
+				n.Message = `{"type":"livereport","auto_send":true}`
+				return n
+			},
+			expectedErr: ErrInvalidSNSSignature,
+		},
+		{
+			name: "Should return ErrTopicNotAllowed",
+			notif: func() snsNotification {
+				n := baseNotif
+				n.SignatureVersion = snsSignatureV2
+				n.TopicArn = "arn:aws:sns:eu-west-1:123456789012:other"
+				return signNotif(t, key, n)
+			},
+			expectedErr: ErrTopicNotAllowed,
+		},
+		{
+			name: "Should return ErrUnsupportedSignatureVersion",
+			notif: func() snsNotification {
+				n := baseNotif
+				n.SignatureVersion = "3"
+				return n
+			},
+			expectedErr: ErrUnsupportedSignatureVersion,
+		},
+		{
+			name: "Should return ErrInvalidSigningCertURL for untrusted host",
+			notif: func() snsNotification {
+				n := baseNotif
+				n.SignatureVersion = snsSignatureV2
+				n.SigningCertURL = "https://sns.eu-west-1.attacker.com/" + mockCertName
+				return signNotif(t, key, n)
+			},
+			expectedErr: ErrInvalidSigningCertURL,
+		},
+		{
+			name: "Should return ErrInvalidSigningCertURL for not pinned cert",
+			notif: func() snsNotification {
+				n := baseNotif
+				n.SignatureVersion = snsSignatureV2
+				n.SigningCertURL = mockUnpinnedCert
+				return signNotif(t, key, n)
+			},
+			expectedErr: ErrInvalidSigningCertURL,
+		},
+	}
+
+	verifier, err := newSNSVerifier(SNSVerificationConfig{
+		Enabled:          true,
+		AllowedTopicArns: []string{mockTopicArn},
+		CertsDir:         certsDir,
+	})
+	if err != nil {
+		t.Fatalf("Error building verifier: %v", err)
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := verifier.verify(tc.notif())
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected err: %v\nBut got: %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestUnwrapMessageVerified(t *testing.T) {
+	certsDir, _ := pinMockCert(t)
+	verifier, err := newSNSVerifier(SNSVerificationConfig{
+		Enabled:          true,
+		AllowedTopicArns: []string{mockTopicArn},
+		CertsDir:         certsDir,
+	})
+	if err != nil {
+		t.Fatalf("Error building verifier: %v", err)
+	}
+
+	// Raw messages can not be verified, so they must be rejected.
+	if _, err := unwrapMessage(EnvelopeAuto, mockRawMssg, verifier); !errors.Is(err, ErrMissingSNSEnvelope) {
+		t.Fatalf("Expected err: %v\nBut got: %v", ErrMissingSNSEnvelope, err)
+	}
+	// Mock SNS notification is not signed with the pinned cert.
+	if _, err := unwrapMessage(EnvelopeSNS, mockSNSNotif, verifier); err == nil {
+		t.Fatalf("Expected verification error but got none")
+	}
+}
+
+// failingTransport fails every request, as if SNS was not reachable.
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("mockErr")
+}
+
+func TestSNSVerifyCertUnavailable(t *testing.T) {
+	verifier, err := newSNSVerifier(SNSVerificationConfig{
+		Enabled:          true,
+		AllowedTopicArns: []string{mockTopicArn},
+	})
+	if err != nil {
+		t.Fatalf("Error building verifier: %v", err)
+	}
+	verifier.httpClient = &http.Client{Transport: failingTransport{}}
+
+	err = verifier.verify(snsNotification{
+		Type:             snsNotifType,
+		TopicArn:         mockTopicArn,
+		SignatureVersion: snsSignatureV2,
+		Signature:        "AAAA",
+		SigningCertURL:   mockCertURL,
+	})
+	if !errors.Is(err, ErrSigningCertUnavailable) {
+		t.Fatalf("Expected err: %v\nBut got: %v", ErrSigningCertUnavailable, err)
+	}
+}
+
+func TestNewSNSVerifierWithoutTopics(t *testing.T) {
+	_, err := newSNSVerifier(SNSVerificationConfig{Enabled: true})
+	if !errors.Is(err, ErrInvalidVerificationConfig) {
+		t.Fatalf("Expected err: %v\nBut got: %v", ErrInvalidVerificationConfig, err)
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package queue
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	spoolQueueName = "spool"
+	spoolExt       = ".json"
+	spoolDoneDir   = "done"
+	spoolFailedDir = "failed"
+
+	defSpoolInterval = 1
+)
+
+// SpoolConfig is the configuration for the SpoolConsumer.
+// Interval is expressed in seconds.
+type SpoolConfig struct {
+	Dir      string `toml:"dir"`
+	Interval int64  `toml:"interval"`
+}
+
+// SpoolConsumer is a Consumer which reads messages from JSON files
+// in a local directory. Processed files are moved to the "done"
+// subdirectory, or to the "failed" one if their processing failed,
+// named after the message ID. The ID is built from the file name and
+// a hash of its content, so files dropped under a reused name are not
+// taken as the same message. Files must be renamed into the directory
+// once written, so they are not read partially.
+type SpoolConsumer struct {
+	dir       string
+	interval  time.Duration
+	processor Processor
+	logger    *log.Logger
+}
+
+// NewSpoolConsumer creates a new SpoolConsumer.
+func NewSpoolConsumer(config SpoolConfig, processor Processor, logger *log.Logger) (*SpoolConsumer, error) {
+	if config.Dir == "" {
+		return nil, fmt.Errorf("%w: spool dir is required", ErrInvalidBackend)
+	}
+	for _, dir := range []string{spoolDoneDir, spoolFailedDir} {
+		if err := os.MkdirAll(filepath.Join(config.Dir, dir), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	interval := int64(defSpoolInterval)
+	if config.Interval > 0 {
+		interval = config.Interval
+	}
+
+	return &SpoolConsumer{
+		dir:       config.Dir,
+		interval:  time.Duration(interval) * time.Second,
+		processor: processor,
+		logger:    logger,
+	}, nil
+}
+
+// Start makes the consumer start watching the spool directory.
+func (c *SpoolConsumer) Start(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				c.logger.WithFields(log.Fields{
+					"err":   err,
+					"trace": string(debug.Stack()),
+				}).Error("Consumer stopping due to panic err")
+			}
+
+			wg.Done()
+		}()
+
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			if err := c.readAndProcess(ctx); err != nil {
+				c.logger.WithError(err).Error("Error reading spool dir")
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// readAndProcess processes the files in the spool
+// directory in lexical order of their names.
+func (c *SpoolConsumer) readAndProcess(ctx context.Context) error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), spoolExt) {
+			continue
+		}
+		files = append(files, entry.Name())
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		body, err := os.ReadFile(filepath.Join(c.dir, file))
+		if err != nil {
+			return err
+		}
+		mssg := Message{
+			ID:           spoolMessageID(file, body),
+			Body:         string(body),
+			Attributes:   map[string]string{},
+			Queue:        spoolQueueName,
+			ReceiveCount: 1,
+		}
+
+		dest := spoolDoneDir
+		if err := c.processor.ProcessMessage(ctx, mssg); err != nil {
+			c.logger.WithError(err).WithFields(log.Fields{
+				"file": file,
+			}).Error("Error processing spool message")
+			dest = spoolFailedDir
+		}
+
+		if err := os.Rename(filepath.Join(c.dir, file), filepath.Join(c.dir, dest, mssg.ID+spoolExt)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// spoolMessageID returns the ID of the message read from the file,
+// made of the file name and the hash of its content.
+func spoolMessageID(file string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return fmt.Sprintf("%s-%x", strings.TrimSuffix(file, spoolExt), sum[:8])
+}
+
+// SpoolProducer is a Producer which writes
+// messages as files into a spool directory.
+type SpoolProducer struct {
+	dir string
+}
+
+// NewSpoolProducer creates a new SpoolProducer.
+func NewSpoolProducer(config SpoolConfig) *SpoolProducer {
+	return &SpoolProducer{
+		dir: config.Dir,
+	}
+}
+
+// Send writes the message into the spool directory. The file is
+// named after the current time and the message ID, if any, so
+// messages are consumed in the same order they were sent.
+func (p *SpoolProducer) Send(ctx context.Context, mssg Message) error {
+	name := fmt.Sprintf("%d", time.Now().UnixNano())
+	if mssg.ID != "" {
+		name = fmt.Sprintf("%s-%s", name, filepath.Base(mssg.ID))
+	}
+
+	tmp, err := os.CreateTemp(p.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // nolint
+
+	if _, err := tmp.WriteString(mssg.Body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(p.dir, name+spoolExt))
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package queue
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type mockBodyProcessor struct {
+	failBody string
+	mssgs    []Message
+}
+
+func (p *mockBodyProcessor) ProcessMessage(ctx context.Context, mssg Message) error {
+	p.mssgs = append(p.mssgs, mssg)
+	if mssg.Body == p.failBody {
+		return errors.New("mockErr")
+	}
+	return nil
+}
+
+func TestSpoolReadAndProcess(t *testing.T) {
+	dir := t.TempDir()
+	processor := &mockBodyProcessor{failBody: "fail"}
+
+	consumer, err := NewSpoolConsumer(SpoolConfig{Dir: dir}, processor, log.New())
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+
+	producer := NewSpoolProducer(SpoolConfig{Dir: dir})
+	for _, mssg := range []Message{{ID: "1", Body: "ok"}, {ID: "2", Body: "fail"}} {
+		if err := producer.Send(context.Background(), mssg); err != nil {
+			t.Fatalf("Expected no error, but got: %v", err)
+		}
+	}
+	// Files with other extensions are ignored.
+	if err := os.WriteFile(filepath.Join(dir, "3.tmp"), []byte("ok"), 0644); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+
+	if err := consumer.readAndProcess(context.Background()); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+
+	var bodies []string
+	for _, mssg := range processor.mssgs {
+		if mssg.Queue != spoolQueueName {
+			t.Fatalf("Expected queue to be %s but got %s", spoolQueueName, mssg.Queue)
+		}
+		bodies = append(bodies, mssg.Body)
+	}
+	if !reflect.DeepEqual(bodies, []string{"ok", "fail"}) {
+		t.Fatalf("Expected processed bodies: %v\nBut got: %v", []string{"ok", "fail"}, bodies)
+	}
+
+	for dest, expected := range map[string][]string{
+		"":             {"3.tmp", spoolDoneDir, spoolFailedDir},
+		spoolDoneDir:   {processor.mssgs[0].ID + spoolExt},
+		spoolFailedDir: {processor.mssgs[1].ID + spoolExt},
+	} {
+		entries, err := os.ReadDir(filepath.Join(dir, dest))
+		if err != nil {
+			t.Fatalf("Expected no error, but got: %v", err)
+		}
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, expected) {
+			t.Fatalf("Expected files in %q: %v\nBut got: %v", dest, expected, names)
+		}
+	}
+}
+
+func TestSpoolReusedFileName(t *testing.T) {
+	dir := t.TempDir()
+	processor := &mockBodyProcessor{}
+
+	consumer, err := NewSpoolConsumer(SpoolConfig{Dir: dir}, processor, log.New())
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+
+	for _, body := range []string{"first", "second"} {
+		if err := os.WriteFile(filepath.Join(dir, "request"+spoolExt), []byte(body), 0644); err != nil {
+			t.Fatalf("Expected no error, but got: %v", err)
+		}
+		if err := consumer.readAndProcess(context.Background()); err != nil {
+			t.Fatalf("Expected no error, but got: %v", err)
+		}
+	}
+
+	if len(processor.mssgs) != 2 || processor.mssgs[0].ID == processor.mssgs[1].ID {
+		t.Fatalf("Expected 2 messages with different IDs, but got: %v", processor.mssgs)
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, spoolDoneDir))
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 done files, but got: %d", len(entries))
+	}
+}
@@ -6,10 +6,13 @@ package queue
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"runtime/debug"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/arn"
@@ -22,53 +25,196 @@ import (
 const (
 	maxNumberOfMsg = 10
 	defSQSWaitTime = 0
+
+	allMssgAttributes = "All"
+	fifoSuffix        = ".fifo"
 )
 
 // SQSConfig is the configuration required for an SQSConsumer.
+// QueueName identifies the queue within the service and
+// defaults to the name of the queue in its ARN.
 type SQSConfig struct {
 	QueueArn    string `toml:"queue_arn"`
 	Timeout     int64  `toml:"timeout"`
 	MaxWaitTime int64  `toml:"wait_time"`
 	QueueName   string `toml:"queue_name"`
 	Endpoint    string `toml:"endpoint"`
+	// Envelope specifies how messages are delivered
+	// to the queue: "sns", "raw" or "auto" (default).
+	Envelope        string                `toml:"envelope"`
+	SNSVerification SNSVerificationConfig `toml:"sns_verification"`
+	// FIFO indicates that the queue is a FIFO queue, so messages
+	// of the same group must be processed in order. It is also
+	// inferred from the ".fifo" suffix of the queue ARN.
+	FIFO bool `toml:"fifo"`
+}
+
+// SQSQueueConfig is the configuration for each
+// of the queues consumed by an SQSConsumerGroup.
+// Consumers of a queue do not read new messages while messages
+// from queues with a higher Priority are being processed, for up to
+// MaxPriorityWait seconds, 60 by default. Then they read a batch
+// of messages anyway, so the queue is not starved.
+// If Autoscale is enabled, NConsumers is the initial
+// number of consumers.
+type SQSQueueConfig struct {
+	SQSConfig
+	NConsumers      uint8           `toml:"number_of_processors"`
+	Priority        int             `toml:"priority"`
+	MaxPriorityWait int64           `toml:"max_priority_wait"`
+	Autoscale       AutoscaleConfig `toml:"autoscale"`
+}
+
+func (c SQSQueueConfig) maxPriorityWait() time.Duration {
+	return durationOrDefault(c.MaxPriorityWait, defMaxPriorityWait)
+}
+
+// Name returns the name that identifies the queue.
+func (c SQSConfig) Name() string {
+	if c.QueueName != "" {
+		return c.QueueName
+	}
+	if arn, err := arn.Parse(c.QueueArn); err == nil {
+		return arn.Resource
+	}
+	return c.QueueArn
+}
+
+// isFIFO returns true if the configured queue is a FIFO queue.
+func (c SQSConfig) isFIFO() bool {
+	return c.FIFO || strings.HasSuffix(c.QueueArn, fifoSuffix)
 }
 
-// SQSConsumer is the SQS implementation of the QueueConsumer interface.
+// SQSConsumer reads and processes messages from an SQS queue.
 type SQSConsumer struct {
 	config      SQSConfig
 	sqsURL      string
 	sqsWaitTime int64
 	sqs         sqsiface.SQSAPI
 	processor   Processor
+	verifier    *snsVerifier
+	priority    int
+	maxWait     time.Duration
+	gate        *priorityGate
+	pause       *pauseGate
+	state       consumerState
+	stats       *queueStats
+	backoff     time.Duration
+	stop        chan struct{}
 	logger      *log.Logger
 }
 
-// SQSConsumerGroup is a group of SQSConsumers.
+// SQSConsumerGroup is the SQS implementation of the Consumer
+// interface, a group of SQSConsumers reading from one or more queues.
+// It also implements the Controller interface.
 type SQSConsumerGroup struct {
+	mu        sync.Mutex
+	queues    []*sqsQueue
+	processor Processor
+	gate      *priorityGate
+	pause     *pauseGate
+	logger    *log.Logger
+	nextID    int
+
+	// Set once the group is started.
+	ctx context.Context
+	wg  *sync.WaitGroup
+}
+
+// sqsQueue holds the resources shared by the consumers of a queue.
+type sqsQueue struct {
+	config    SQSQueueConfig
+	sqsURL    string
+	sqs       sqsiface.SQSAPI
+	verifier  *snsVerifier
+	stats     *queueStats
 	consumers []*SQSConsumer
 }
 
-// NewSQSConsumerGroup creates a new SQSConsumerGroup.
-func NewSQSConsumerGroup(nConsumers uint8, config SQSConfig, processor Processor, logger *log.Logger) (*SQSConsumerGroup, error) {
-	var consumerGroup SQSConsumerGroup
+// NewSQSConsumerGroup creates a new SQSConsumerGroup with
+// the configured number of consumers for each queue.
+func NewSQSConsumerGroup(queues []SQSQueueConfig, processor Processor, logger *log.Logger) (*SQSConsumerGroup, error) {
+	consumerGroup := &SQSConsumerGroup{
+		processor: processor,
+		gate:      newPriorityGate(),
+		pause:     &pauseGate{},
+		logger:    logger,
+	}
 
-	awsSess, err := session.NewSession()
-	if err != nil {
+	names := map[string]bool{}
+	for _, queue := range queues {
+		if names[queue.Name()] {
+			return nil, fmt.Errorf("duplicated queue name %q", queue.Name())
+		}
+		names[queue.Name()] = true
+
+		q, err := newSQSQueue(queue)
+		if err != nil {
+			return nil, err
+		}
+		consumerGroup.queues = append(consumerGroup.queues, q)
+	}
+
+	return consumerGroup, nil
+}
+
+// newSQSQueue validates the queue configuration
+// and builds the client to read from it.
+func newSQSQueue(queue SQSQueueConfig) (*sqsQueue, error) {
+	config := queue.SQSConfig
+	if !isValidEnvelope(config.Envelope) {
+		return nil, ErrInvalidEnvelope
+	}
+	if err := queue.Autoscale.validate(); err != nil {
 		return nil, err
 	}
 
-	arn, err := arn.Parse(config.QueueArn)
+	var verifier *snsVerifier
+	if config.SNSVerification.Enabled {
+		if config.Envelope == EnvelopeRaw {
+			return nil, fmt.Errorf("%w: raw envelope can not be verified", ErrInvalidVerificationConfig)
+		}
+		v, err := newSNSVerifier(config.SNSVerification)
+		if err != nil {
+			return nil, err
+		}
+		verifier = v
+	}
+
+	sqsSvc, sqsURL, err := newSQSClient(config.QueueArn, config.Endpoint)
 	if err != nil {
 		return nil, err
 	}
 
+	return &sqsQueue{
+		config:   queue,
+		sqsURL:   sqsURL,
+		sqs:      sqsSvc,
+		verifier: verifier,
+		stats:    &queueStats{},
+	}, nil
+}
+
+// newSQSClient builds an SQS client for the queue identified
+// by queueArn and returns it along with the queue URL.
+func newSQSClient(queueArn, endpoint string) (*sqs.SQS, string, error) {
+	awsSess, err := session.NewSession()
+	if err != nil {
+		return nil, "", err
+	}
+
+	arn, err := arn.Parse(queueArn)
+	if err != nil {
+		return nil, "", err
+	}
+
 	awsCfg := aws.NewConfig()
 
 	if arn.Region != "" {
 		awsCfg = awsCfg.WithRegion(arn.Region)
 	}
-	if config.Endpoint != "" {
-		awsCfg = awsCfg.WithEndpoint(config.Endpoint)
+	if endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(endpoint)
 	}
 
 	input := sqs.GetQueueUrlInput{
@@ -81,30 +227,131 @@ func NewSQSConsumerGroup(nConsumers uint8, config SQSConfig, processor Processor
 	sqsSvc := sqs.New(awsSess, awsCfg)
 	sqsURLData, err := sqsSvc.GetQueueUrl(&input)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	var consumers []*SQSConsumer
-	for i := uint8(0); i < nConsumers; i++ {
-		consumers = append(consumers, &SQSConsumer{
-			config:      config,
-			sqsURL:      *sqsURLData.QueueUrl,
-			sqsWaitTime: defSQSWaitTime,
-			sqs:         sqsSvc,
-			processor:   processor,
-			logger:      logger,
-		})
+	return sqsSvc, *sqsURLData.QueueUrl, nil
+}
+
+// Start makes the consumer group start reading and processing messages from the queues.
+func (g *SQSConsumerGroup) Start(ctx context.Context, wg *sync.WaitGroup) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.ctx = ctx
+	g.wg = wg
+	for _, q := range g.queues {
+		n := q.config.NConsumers
+		if autoscale := q.config.Autoscale; autoscale.Enabled {
+			n = clampConsumers(autoscale, n)
+			wg.Add(1)
+			go g.autoscale(ctx, wg, q)
+		}
+		g.scale(q, n)
+	}
+}
+
+// startConsumer starts a new consumer for the queue.
+// It must be called holding the group lock.
+func (g *SQSConsumerGroup) startConsumer(q *sqsQueue) {
+	g.nextID++
+
+	consumer := &SQSConsumer{
+		config:      q.config.SQSConfig,
+		sqsURL:      q.sqsURL,
+		sqsWaitTime: defSQSWaitTime,
+		sqs:         q.sqs,
+		processor:   g.processor,
+		verifier:    q.verifier,
+		priority:    q.config.Priority,
+		maxWait:     q.config.maxPriorityWait(),
+		gate:        g.gate,
+		pause:       g.pause,
+		stats:       q.stats,
+		backoff:     q.config.Autoscale.throttleBackoff(),
+		stop:        make(chan struct{}),
+		logger:      g.logger,
+	}
+	consumer.state.state = ConsumerState{
+		ID:     g.nextID,
+		Queue:  q.config.Name(),
+		Status: ConsumerIdle,
 	}
+	q.consumers = append(q.consumers, consumer)
 
-	consumerGroup.consumers = consumers
-	return &consumerGroup, nil
+	g.wg.Add(1)
+	go consumer.start(g.ctx, g.wg)
 }
 
-// Start makes the consumer group start reading and processing messages from the queue.
-func (g *SQSConsumerGroup) Start(ctx context.Context, wg *sync.WaitGroup) {
-	for _, consumer := range g.consumers {
-		wg.Add(1)
-		go consumer.start(ctx, wg)
+// Pause stops the consumers from reading new messages.
+func (g *SQSConsumerGroup) Pause() {
+	g.pause.pause()
+}
+
+// Resume makes the consumers read messages again.
+func (g *SQSConsumerGroup) Resume() {
+	g.pause.resume()
+}
+
+// Paused returns true if the consumption is paused.
+func (g *SQSConsumerGroup) Paused() bool {
+	return g.pause.paused()
+}
+
+// State returns the state of every consumer of the group.
+func (g *SQSConsumerGroup) State() []ConsumerState {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	states := []ConsumerState{}
+	for _, q := range g.queues {
+		for _, c := range q.consumers {
+			states = append(states, c.state.get())
+		}
+	}
+	return states
+}
+
+// SetConsumers starts or stops consumers of the specified queue
+// until it has n consumers. Stopped consumers finish processing
+// the messages they already read. For autoscaled queues, the number
+// of consumers is adjusted again in the next autoscaling round.
+func (g *SQSConsumerGroup) SetConsumers(queue string, n uint8) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var q *sqsQueue
+	for _, sq := range g.queues {
+		if sq.config.Name() == queue {
+			q = sq
+		}
+	}
+	if q == nil {
+		return ErrQueueNotFound
+	}
+
+	q.config.NConsumers = n
+	if g.ctx == nil {
+		// Not started yet.
+		return nil
+	}
+
+	g.scale(q, n)
+	return nil
+}
+
+// scale starts or stops consumers of the queue until it has n.
+// Stopped consumers do not read new messages, but the processing
+// of the messages already read is not aborted.
+// It must be called holding the group lock.
+func (g *SQSConsumerGroup) scale(q *sqsQueue, n uint8) {
+	for len(q.consumers) < int(n) {
+		g.startConsumer(q)
+	}
+	for len(q.consumers) > int(n) {
+		last := q.consumers[len(q.consumers)-1]
+		close(last.stop)
+		q.consumers = q.consumers[:len(q.consumers)-1]
 	}
 }
 
@@ -124,6 +371,8 @@ func (c *SQSConsumer) start(ctx context.Context, wg *sync.WaitGroup) {
 		select {
 		case <-ctx.Done():
 			return
+		case <-c.stop:
+			return
 		default:
 			if err := c.readAndProcess(ctx); err != nil {
 				c.logger.WithError(err).Error("Error reading SQS messages")
@@ -133,10 +382,34 @@ func (c *SQSConsumer) start(ctx context.Context, wg *sync.WaitGroup) {
 }
 
 func (c *SQSConsumer) readAndProcess(ctx context.Context) error {
+	// Do not read while paused, and give way
+	// to the queues with higher priority.
+	waitCtx, cancel := c.stopContext(ctx)
+	defer cancel()
+	if c.pause != nil && c.pause.paused() {
+		c.state.setStatus(ConsumerPaused)
+		c.pause.wait(waitCtx)
+	}
+	if c.gate != nil && c.gate.blocked(c.priority) {
+		c.state.setStatus(ConsumerPaused)
+		c.gate.wait(waitCtx, c.priority, c.maxWait)
+	}
+	c.state.setStatus(ConsumerIdle)
+	if waitCtx.Err() != nil {
+		return nil
+	}
+
 	mssgs, err := c.readMssgs(ctx)
 	if err != nil {
 		return err
 	}
+	// Messages must be processed before their
+	// visibility timeout expires.
+	deadline := time.Now().Add(time.Duration(c.config.Timeout) * time.Second)
+	if c.gate != nil && len(mssgs) > 0 {
+		c.gate.acquire(c.priority)
+		defer c.gate.release(c.priority)
+	}
 
 	// Adjust SQS wait time based on
 	// number of retrieved messages
@@ -146,9 +419,25 @@ func (c *SQSConsumer) readAndProcess(ctx context.Context) error {
 		c.sqsWaitTime = defSQSWaitTime
 	}
 
-	for _, mssg := range mssgs {
+	// Groups of FIFO messages whose processing failed. Following
+	// messages of the same group are released back to the queue
+	// without processing them, so they keep their order.
+	failedGroups := map[string]bool{}
+
+	for i, mssg := range mssgs {
 		// Check for invalid mssg
-		mssgBody, err := validateMssg(mssg)
+		queueMssg, err := c.validateMssg(mssg)
+		if errors.Is(err, ErrSigningCertUnavailable) {
+			// The message is not known to be invalid, so it
+			// is left in the queue to be verified once redelivered.
+			c.logger.WithError(err).WithFields(log.Fields{
+				"mssgID": aws.StringValue(mssg.MessageId),
+			}).Warn("SQS message could not be verified")
+			if group := c.groupID(mssg); group != "" {
+				failedGroups[group] = true
+			}
+			continue
+		}
 		if err != nil {
 			c.logger.WithError(err).WithFields(log.Fields{
 				"mssg": mssg,
@@ -160,12 +449,47 @@ func (c *SQSConsumer) readAndProcess(ctx context.Context) error {
 			continue
 		}
 
+		if queueMssg.GroupID != "" && failedGroups[queueMssg.GroupID] {
+			if err = c.releaseMessage(mssg, 0); err != nil {
+				c.logger.WithError(err).Error("Error releasing FIFO message")
+			}
+			continue
+		}
+
 		// If message is valid, process it
-		if err = c.processor.ProcessMessage(mssgBody); err != nil {
+		c.state.setStatus(ConsumerProcessing)
+		start := time.Now()
+		err = c.process(ctx, deadline, queueMssg)
+		if c.stats != nil {
+			c.stats.record(time.Since(start), err)
+		}
+		c.state.processed(err)
+		c.state.setStatus(ConsumerIdle)
+		if errors.Is(err, ErrThrottled) {
+			c.backOff(ctx, mssgs[i:])
+			return nil
+		}
+		if errors.Is(err, ErrUnprocessable) {
+			// Retrying the message would fail the same way.
+			c.logger.WithError(err).WithFields(log.Fields{
+				"queue": queueMssg.Queue,
+				"body":  queueMssg.Body,
+				"attrs": mssg.Attributes,
+			}).Error("Discarding unprocessable SQS message")
+			if err = c.deleteMessage(mssg); err != nil {
+				c.logger.WithError(err).Error("Error deleting unprocessable message")
+			}
+			continue
+		}
+		if err != nil {
 			c.logger.WithError(err).WithFields(log.Fields{
-				"body":  mssgBody,
+				"queue": queueMssg.Queue,
+				"body":  queueMssg.Body,
 				"attrs": mssg.Attributes,
 			}).Error("Error processing SQS message")
+			if queueMssg.GroupID != "" {
+				failedGroups[queueMssg.GroupID] = true
+			}
 			continue
 		}
 
@@ -178,6 +502,24 @@ func (c *SQSConsumer) readAndProcess(ctx context.Context) error {
 	return nil
 }
 
+// stopContext returns a copy of ctx which is also done once the
+// consumer is stopped, to abort the waits for its turn to read.
+// The messages already read are processed within ctx instead, so
+// they are not aborted when the consumer is stopped.
+func (c *SQSConsumer) stopContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	if c.stop != nil {
+		go func() {
+			select {
+			case <-c.stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return ctx, cancel
+}
+
 func (c *SQSConsumer) readMssgs(ctx context.Context) ([]*sqs.Message, error) {
 	receiveQuery := sqs.ReceiveMessageInput{
 		QueueUrl:            aws.String(c.sqsURL),
@@ -187,6 +529,9 @@ func (c *SQSConsumer) readMssgs(ctx context.Context) ([]*sqs.Message, error) {
 		AttributeNames: []*string{
 			aws.String(sqs.QueueAttributeNameAll),
 		},
+		MessageAttributeNames: []*string{
+			aws.String(allMssgAttributes),
+		},
 	}
 	mssgsResp, err := c.sqs.ReceiveMessageWithContext(ctx, &receiveQuery)
 	if err != nil {
@@ -196,6 +541,49 @@ func (c *SQSConsumer) readMssgs(ctx context.Context) ([]*sqs.Message, error) {
 	return mssgsResp.Messages, nil
 }
 
+// releaseMessage makes the message visible again in
+// the queue once the specified seconds have elapsed.
+func (c *SQSConsumer) releaseMessage(mssg *sqs.Message, after int64) error {
+	_, err := c.sqs.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+		ReceiptHandle:     mssg.ReceiptHandle,
+		QueueUrl:          aws.String(c.sqsURL),
+		VisibilityTimeout: aws.Int64(after),
+	})
+
+	return err
+}
+
+// backOff releases the pending messages of the batch once the
+// backoff has elapsed and waits for it before reading again,
+// as processing is being throttled.
+func (c *SQSConsumer) backOff(ctx context.Context, pending []*sqs.Message) {
+	c.logger.WithField("backoff", c.backoff.String()).Warn("Processing throttled, backing off")
+
+	for _, mssg := range pending {
+		if err := c.releaseMessage(mssg, int64(c.backoff.Seconds())); err != nil {
+			c.logger.WithError(err).Error("Error releasing throttled message")
+		}
+	}
+
+	c.state.setStatus(ConsumerPaused)
+	defer c.state.setStatus(ConsumerIdle)
+	select {
+	case <-ctx.Done():
+	case <-time.After(c.backoff):
+	}
+}
+
+// process hands the message over to the processor, with a
+// deadline if the visibility timeout of the queue is configured.
+func (c *SQSConsumer) process(ctx context.Context, deadline time.Time, mssg Message) error {
+	if c.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	return c.processor.ProcessMessage(ctx, mssg)
+}
+
 func (c *SQSConsumer) deleteMessage(mssg *sqs.Message) error {
 	_, err := c.sqs.DeleteMessage(&sqs.DeleteMessageInput{
 		ReceiptHandle: mssg.ReceiptHandle,
@@ -205,22 +593,56 @@ func (c *SQSConsumer) deleteMessage(mssg *sqs.Message) error {
 	return err
 }
 
-func validateMssg(mssg *sqs.Message) (string, error) {
+// validateMssg checks the SQS message and removes the SNS envelope
+// around the actual message body, if any, according to the
+// configured envelope mode.
+// When the message is not wrapped in an SNS envelope,
+// the SQS message attributes are kept instead.
+func (c *SQSConsumer) validateMssg(mssg *sqs.Message) (Message, error) {
 	if mssg == nil || mssg.Body == nil {
-		return "", errors.New("unpexpected nil message")
+		return Message{}, errors.New("unpexpected nil message")
+	}
+
+	queueMssg, err := unwrapMessage(c.config.Envelope, *mssg.Body, c.verifier)
+	if err != nil {
+		return Message{}, err
+	}
+	if queueMssg.Attributes == nil {
+		queueMssg.Attributes = sqsMessageAttributes(mssg)
+	}
+	queueMssg.ID = aws.StringValue(mssg.MessageId)
+	queueMssg.Queue = c.config.Name()
+	receives := aws.StringValue(mssg.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount])
+	if n, err := strconv.Atoi(receives); err == nil {
+		queueMssg.ReceiveCount = n
 	}
-	return removeSNSEnvelope(*mssg.Body)
+	// SentTimestamp is expressed in epoch milliseconds.
+	sent := aws.StringValue(mssg.Attributes[sqs.MessageSystemAttributeNameSentTimestamp])
+	if ms, err := strconv.ParseInt(sent, 10, 64); err == nil {
+		queueMssg.SentAt = time.UnixMilli(ms)
+	}
+	queueMssg.GroupID = c.groupID(mssg)
+
+	return queueMssg, nil
 }
 
-// removeSNSEnvelope removes the SNS envelope around the
-// actual body message because messages received in queue
-// are pushed through an SNS topic.
-func removeSNSEnvelope(snsNotif string) (string, error) {
-	var mold = struct {
-		Message string `json:"Message"`
-	}{}
-	if err := json.Unmarshal([]byte(snsNotif), &mold); err != nil {
-		return "", errors.New("unexpected mssg format: Expected SNS envelope")
+// groupID returns the group of the message if the queue is a FIFO queue.
+func (c *SQSConsumer) groupID(mssg *sqs.Message) string {
+	if !c.config.isFIFO() {
+		return ""
+	}
+	return aws.StringValue(mssg.Attributes[sqs.MessageSystemAttributeNameMessageGroupId])
+}
+
+// sqsMessageAttributes returns the string and number
+// message attributes of an SQS message.
+func sqsMessageAttributes(mssg *sqs.Message) map[string]string {
+	attrs := map[string]string{}
+	for name, attr := range mssg.MessageAttributes {
+		if attr == nil || attr.StringValue == nil {
+			continue
+		}
+		attrs[name] = *attr.StringValue
 	}
-	return mold.Message, nil
+	return attrs
 }
@@ -7,7 +7,11 @@ package queue
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
 	"testing"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -39,10 +43,14 @@ type sqsMock struct {
 	sqsiface.SQSAPI
 
 	returnMssgs    uint8
+	body           string
+	mssgGroups     []string
 	wantReceiveErr bool
 	wantDeleteErr  bool
 	receiveCalls   uint8
 	deleteCalls    uint8
+	releaseCalls   uint8
+	releaseAfter   []int64
 }
 
 func (m *sqsMock) ReceiveMessageWithContext(aws.Context, *sqs.ReceiveMessageInput, ...request.Option) (*sqs.ReceiveMessageOutput, error) {
@@ -54,10 +62,23 @@ func (m *sqsMock) ReceiveMessageWithContext(aws.Context, *sqs.ReceiveMessageInpu
 
 	resp := &sqs.ReceiveMessageOutput{}
 	mssgs := make([]*sqs.Message, 0)
+	body := mockSNSMssg
+	if m.body != "" {
+		body = m.body
+	}
 
 	for i := uint8(0); i < m.returnMssgs; i++ {
 		mssgs = append(mssgs, &sqs.Message{
-			Body: aws.String(mockSNSMssg),
+			Body: aws.String(body),
+		})
+	}
+	for _, group := range m.mssgGroups {
+		mssgs = append(mssgs, &sqs.Message{
+			Body: aws.String(body),
+			Attributes: map[string]*string{
+				sqs.MessageSystemAttributeNameMessageGroupId:          aws.String(group),
+				sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String("2"),
+			},
 		})
 	}
 	resp.Messages = mssgs
@@ -75,12 +96,32 @@ func (m *sqsMock) DeleteMessage(*sqs.DeleteMessageInput) (*sqs.DeleteMessageOutp
 	return nil, nil
 }
 
+func (m *sqsMock) ChangeMessageVisibility(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+	m.releaseCalls++
+	m.releaseAfter = append(m.releaseAfter, aws.Int64Value(input.VisibilityTimeout))
+	return nil, nil
+}
+
 type mockProcessor struct {
+	wantErr      bool
+	err          error
 	processCalls uint8
+	mssgs        []Message
+	deadlines    []time.Time
 }
 
-func (p *mockProcessor) ProcessMessage(mssg string) error {
+func (p *mockProcessor) ProcessMessage(ctx context.Context, mssg Message) error {
 	p.processCalls++
+	p.mssgs = append(p.mssgs, mssg)
+	if deadline, ok := ctx.Deadline(); ok {
+		p.deadlines = append(p.deadlines, deadline)
+	}
+	if p.err != nil {
+		return p.err
+	}
+	if p.wantErr {
+		return errors.New("mockErr")
+	}
 	return nil
 }
 
@@ -105,6 +146,7 @@ func TestReadAndProcess(t *testing.T) {
 		expectedDelCalls  uint8
 		expectedRecCalls  uint8
 		expectedProcCalls uint8
+		expectedRelCalls  uint8
 		expectedWaitTime  int64
 	}{
 		{
@@ -171,6 +213,70 @@ func TestReadAndProcess(t *testing.T) {
 			expectedProcCalls: 5,
 			expectedWaitTime:  defSQSWaitTime,
 		},
+		{
+			name: "Should release FIFO messages of failed groups",
+			fields: fields{
+				config: SQSConfig{
+					MaxWaitTime: sqsMaxWaitTime,
+					FIFO:        true,
+				},
+				sqs: &sqsMock{
+					mssgGroups: []string{"team-1", "team-1", "team-2"},
+				},
+				processor: &mockProcessor{
+					wantErr: true,
+				},
+				logger: logger,
+			},
+			expectedErr:       false,
+			expectedRecCalls:  1,
+			expectedDelCalls:  0,
+			expectedProcCalls: 2,
+			expectedRelCalls:  1,
+			expectedWaitTime:  defSQSWaitTime,
+		},
+		{
+			name: "Should delete unprocessable messages without failing their group",
+			fields: fields{
+				config: SQSConfig{
+					MaxWaitTime: sqsMaxWaitTime,
+					FIFO:        true,
+				},
+				sqs: &sqsMock{
+					mssgGroups: []string{"team-1", "team-1", "team-2"},
+				},
+				processor: &mockProcessor{
+					err: fmt.Errorf("%w: mockErr", ErrUnprocessable),
+				},
+				logger: logger,
+			},
+			expectedErr:       false,
+			expectedRecCalls:  1,
+			expectedDelCalls:  3,
+			expectedProcCalls: 3,
+			expectedRelCalls:  0,
+			expectedWaitTime:  defSQSWaitTime,
+		},
+		{
+			name: "Should process FIFO messages of successful groups",
+			fields: fields{
+				config: SQSConfig{
+					MaxWaitTime: sqsMaxWaitTime,
+					FIFO:        true,
+				},
+				sqs: &sqsMock{
+					mssgGroups: []string{"team-1", "team-1", "team-2"},
+				},
+				processor: &mockProcessor{},
+				logger:    logger,
+			},
+			expectedErr:       false,
+			expectedRecCalls:  1,
+			expectedDelCalls:  3,
+			expectedProcCalls: 3,
+			expectedRelCalls:  0,
+			expectedWaitTime:  defSQSWaitTime,
+		},
 	}
 
 	for _, tt := range tests {
@@ -195,6 +301,9 @@ func TestReadAndProcess(t *testing.T) {
 			if tt.fields.processor.processCalls != tt.expectedProcCalls {
 				t.Fatalf("Process message calls do not match, expected %d but got %d", tt.expectedProcCalls, tt.fields.processor.processCalls)
 			}
+			if tt.fields.sqs.releaseCalls != tt.expectedRelCalls {
+				t.Fatalf("Release message calls do not match, expected %d but got %d", tt.expectedRelCalls, tt.fields.sqs.releaseCalls)
+			}
 			if consumer.sqsWaitTime != tt.expectedWaitTime {
 				t.Fatalf("Expected SQS wait time to be %d but got %d", tt.expectedWaitTime, consumer.sqsWaitTime)
 			}
@@ -202,3 +311,105 @@ func TestReadAndProcess(t *testing.T) {
 	}
 
 }
+
+func TestReadAndProcessMetadata(t *testing.T) {
+	sqsMock := &sqsMock{
+		mssgGroups: []string{"team-1"},
+	}
+	processor := &mockProcessor{}
+	consumer := &SQSConsumer{
+		config: SQSConfig{
+			QueueArn: "arn:aws:sqs:eu-west-1:123456789012:reports.fifo",
+			Timeout:  30,
+		},
+		sqs:       sqsMock,
+		processor: processor,
+		logger:    log.New(),
+	}
+
+	before := time.Now()
+	if err := consumer.readAndProcess(context.Background()); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+
+	if len(processor.mssgs) != 1 || len(processor.deadlines) != 1 {
+		t.Fatalf("Expected one message processed with deadline, but got: %v %v", processor.mssgs, processor.deadlines)
+	}
+	mssg := processor.mssgs[0]
+	if mssg.Queue != "reports.fifo" || mssg.GroupID != "team-1" || mssg.ReceiveCount != 2 {
+		t.Fatalf("Unexpected message metadata: %+v", mssg)
+	}
+	deadline := processor.deadlines[0]
+	if deadline.Before(before.Add(30*time.Second)) || deadline.After(time.Now().Add(30*time.Second)) {
+		t.Fatalf("Expected deadline to match the visibility timeout, but got: %v", deadline)
+	}
+}
+
+func TestReadAndProcessThrottled(t *testing.T) {
+	sqsMock := &sqsMock{
+		returnMssgs: 3,
+	}
+	processor := &mockProcessor{
+		err: fmt.Errorf("%w: mockErr", ErrThrottled),
+	}
+	stats := &queueStats{}
+	consumer := &SQSConsumer{
+		sqs:       sqsMock,
+		processor: processor,
+		stats:     stats,
+		backoff:   10 * time.Millisecond,
+		logger:    log.New(),
+	}
+
+	if err := consumer.readAndProcess(context.Background()); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+
+	// The rest of the batch is released after the backoff.
+	if processor.processCalls != 1 || sqsMock.deleteCalls != 0 {
+		t.Fatalf("Expected processing to stop, but got %d process and %d delete calls", processor.processCalls, sqsMock.deleteCalls)
+	}
+	if !reflect.DeepEqual(sqsMock.releaseAfter, []int64{0, 0, 0}) {
+		t.Fatalf("Expected released messages: %v\nBut got: %v", []int64{0, 0, 0}, sqsMock.releaseAfter)
+	}
+	if _, throttled := stats.reset(); !throttled {
+		t.Fatalf("Expected throttling to be recorded")
+	}
+}
+
+func TestReadAndProcessCertUnavailable(t *testing.T) {
+	verifier, err := newSNSVerifier(SNSVerificationConfig{
+		Enabled:          true,
+		AllowedTopicArns: []string{mockTopicArn},
+	})
+	if err != nil {
+		t.Fatalf("Error building verifier: %v", err)
+	}
+	verifier.httpClient = &http.Client{Transport: failingTransport{}}
+
+	sqsMock := &sqsMock{
+		body: `{"Type":"Notification","TopicArn":"` + mockTopicArn + `","Message":"{}",` +
+			`"SignatureVersion":"2","Signature":"AAAA","SigningCertURL":"` + mockCertURL + `"}`,
+		mssgGroups: []string{"team-1", "team-1"},
+	}
+	processor := &mockProcessor{}
+	consumer := &SQSConsumer{
+		config: SQSConfig{
+			FIFO: true,
+		},
+		sqs:       sqsMock,
+		processor: processor,
+		verifier:  verifier,
+		logger:    log.New(),
+	}
+
+	if err := consumer.readAndProcess(context.Background()); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+
+	// The messages are neither processed nor deleted, but left in the queue.
+	if processor.processCalls != 0 || sqsMock.deleteCalls != 0 {
+		t.Fatalf("Expected messages to be left in the queue, but got %d process and %d delete calls",
+			processor.processCalls, sqsMock.deleteCalls)
+	}
+}
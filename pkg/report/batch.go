@@ -0,0 +1,248 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+	"github.com/adevinta/vulcan-reports-generator/pkg/queue"
+)
+
+const (
+	defBatchParallelism = 4
+
+	// teamIDPlaceholder is replaced by the team ID
+	// in the string values of the batch shared data.
+	teamIDPlaceholder = "{team_id}"
+)
+
+var (
+	// ErrBatchFailed indicates that the processing of some teams of a batch request failed.
+	ErrBatchFailed = errors.New("Batch request partially failed")
+)
+
+// batchGenRequest represents a request to
+// generate the same report type for many teams.
+//
+//   - Batch.ID identifies the run, e.g.: a weekly digest.
+//     Each team request is deduplicated based on it or,
+//     if not supplied, on the queue message ID.
+//   - Batch.Data is the data shared by every team. Its string
+//     values can contain the team ID placeholder.
+//   - Batch.Teams contains the addressee teams along with
+//     their own data, which takes precedence over the shared one.
+type batchGenRequest struct {
+	Typ      model.ReportType `json:"type"`
+	Version  int              `json:"version,omitempty"`
+	Batch    *batch           `json:"batch"`
+	AutoSend bool             `json:"auto_send"`
+}
+
+type batch struct {
+	ID    string                 `json:"id"`
+	Data  map[string]interface{} `json:"data"`
+	Teams []batchTeam            `json:"teams"`
+}
+
+type batchTeam struct {
+	TeamInfo teamInfo               `json:"team_info"`
+	Data     map[string]interface{} `json:"data"`
+}
+
+// processBatch fans out a batch request into a request per team,
+// processed with bounded parallelism. The teams whose processing
+// failed are reported in the returned error, so the message is
+// retried and the teams already processed are skipped. If none of
+// the failed teams can ever be processed, the batch is not retried.
+func (p *reportsProcessor) processBatch(ctx context.Context, req batchGenRequest, mssg queue.Message) error {
+	if _, ok := p.generateUCC[req.Typ]; !ok {
+		return unprocessable(ErrUnsupportedReportType)
+	}
+	if !p.isAllowedInQueue(req.Typ, mssg.Queue) {
+		return unprocessable(ErrReportTypeNotAllowed)
+	}
+	// The data of each team is validated once merged.
+	if _, err := p.schemas.schema(req.Typ, req.Version); err != nil {
+		return unprocessable(err)
+	}
+
+	batchID, err := req.id(mssg)
+	if err != nil {
+		return unprocessable(err)
+	}
+
+	p.log.WithFields(log.Fields{
+		"batchID":  batchID,
+		"type":     req.Typ,
+		"send":     req.AutoSend,
+		"teams":    len(req.Batch.Teams),
+		"mssgID":   mssg.ID,
+		"receives": mssg.ReceiveCount,
+	}).Info("Processing batch report")
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		errs      []error
+		permanent int
+		throttled bool
+	)
+	failed := func(teamID string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if errors.Is(err, queue.ErrUnprocessable) {
+			// Not wrapped, so the batch is still
+			// retried for the rest of the teams.
+			permanent++
+			errs = append(errs, fmt.Errorf("team %s: %v", teamID, err))
+			return
+		}
+		errs = append(errs, fmt.Errorf("team %s: %w", teamID, err))
+		if errors.Is(err, queue.ErrThrottled) {
+			throttled = true
+		}
+	}
+
+	sem := make(chan struct{}, p.batchParallel)
+	for _, team := range req.Batch.Teams {
+		sem <- struct{}{}
+
+		// Teams not started yet are not processed
+		// once aborted or throttled.
+		mu.Lock()
+		stop := throttled
+		mu.Unlock()
+		if err := ctx.Err(); err != nil || stop {
+			if err == nil {
+				err = queue.ErrThrottled
+			}
+			failed(team.TeamInfo.ID, err)
+			<-sem
+			continue
+		}
+
+		teamReq, teamMssg, err := req.teamRequest(team, batchID, mssg)
+		if err != nil {
+			failed(team.TeamInfo.ID, err)
+			<-sem
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := p.processRequest(ctx, teamReq, teamMssg); err != nil {
+				p.log.WithError(err).WithFields(log.Fields{
+					"batchID": batchID,
+					"teamID":  teamReq.TeamInfo.ID,
+					"type":    teamReq.Typ,
+				}).Error("Error processing batch report for team")
+				failed(teamReq.TeamInfo.ID, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	p.log.WithFields(log.Fields{
+		"batchID": batchID,
+		"type":    req.Typ,
+		"teams":   len(req.Batch.Teams),
+		"failed":  len(errs),
+	}).Info("Batch report processed")
+
+	if len(errs) > 0 {
+		err := fmt.Errorf("%w: %d of %d teams: %w", ErrBatchFailed, len(errs), len(req.Batch.Teams), errors.Join(errs...))
+		if permanent == len(errs) {
+			// Retrying the batch would fail the same way.
+			err = fmt.Errorf("%w: %w", queue.ErrUnprocessable, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// id returns the ID of the batch run, which defaults to the ID of the
+// message. The team requests are deduplicated based on it, so batches
+// without an ID are rejected, as unrelated batches would collide.
+func (r batchGenRequest) id(mssg queue.Message) (string, error) {
+	if r.Batch.ID != "" {
+		return r.Batch.ID, nil
+	}
+	if mssg.ID != "" {
+		return mssg.ID, nil
+	}
+	return "", fmt.Errorf("%w: missing batch id", ErrInvalidRequest)
+}
+
+// teamRequest builds the request for a team of the batch, along
+// with the message it is processed as. The message keeps the batch
+// message metadata, but its body is the team request, so it can be
+// requeued or replayed on its own.
+func (r batchGenRequest) teamRequest(team batchTeam, batchID string, mssg queue.Message) (genRequest, queue.Message, error) {
+	data := map[string]interface{}{}
+	for k, v := range r.Batch.Data {
+		if s, ok := v.(string); ok {
+			v = strings.ReplaceAll(s, teamIDPlaceholder, team.TeamInfo.ID)
+		}
+		data[k] = v
+	}
+	for k, v := range team.Data {
+		data[k] = v
+	}
+
+	req := genRequest{
+		Typ:            r.Typ,
+		Version:        r.Version,
+		TeamInfo:       team.TeamInfo,
+		Data:           data,
+		AutoSend:       r.AutoSend,
+		IdempotencyKey: fmt.Sprintf("%s-%s", batchID, team.TeamInfo.ID),
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return genRequest{}, queue.Message{}, err
+	}
+
+	mssg.ID = req.IdempotencyKey
+	mssg.Body = string(body)
+	mssg.GroupID = team.TeamInfo.ID
+	return req, mssg, nil
+}
+
+// parseBatchGenRequest returns the batch request contained in reqData,
+// or nil if it is not a batch request.
+func parseBatchGenRequest(reqData string) (*batchGenRequest, error) {
+	var req batchGenRequest
+	err := json.Unmarshal([]byte(reqData), &req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	if req.Batch == nil {
+		return nil, nil
+	}
+
+	if req.Typ == "" || len(req.Batch.Teams) == 0 {
+		return nil, ErrInvalidRequest
+	}
+	teams := map[string]bool{}
+	for _, t := range req.Batch.Teams {
+		if t.TeamInfo.ID == "" || teams[t.TeamInfo.ID] {
+			return nil, ErrInvalidRequest
+		}
+		teams[t.TeamInfo.ID] = true
+	}
+
+	return &req, nil
+}
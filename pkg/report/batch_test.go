@@ -0,0 +1,200 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package report
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+	"github.com/adevinta/vulcan-reports-generator/pkg/queue"
+)
+
+func TestProcessBatch(t *testing.T) {
+	const input = `{
+		"type": "scan",
+		"batch": {
+			"id": "weekly-2021-05-03",
+			"data": {"date_from": "2021-04-26", "date_to": "2021-05-03", "live_report_url": "https://vulcan.example.com/{team_id}/live"},
+			"teams": [
+				{"team_info": {"id": "1", "recipients": ["one@vulcan.example.com"]}, "data": {"team_id": "1", "high": 1}},
+				{"team_info": {"id": "2", "recipients": ["two@vulcan.example.com"]}, "data": {"team_id": "2", "high": 2}},
+				{"team_info": {"id": "3", "recipients": ["three@vulcan.example.com"]}, "data": {"team_id": "3", "high": 3}}
+			]
+		}
+	}`
+
+	testCases := []struct {
+		name          string
+		input         string
+		noMssgID      bool
+		failTeams     map[string]bool
+		expectedErr   error
+		expectedTeams []string
+		retryTeams    []string
+	}{
+		{
+			name:          "Should process every team",
+			input:         input,
+			expectedTeams: []string{"1", "2", "3"},
+		},
+		{
+			name:          "Should return err for failed teams and retry only them",
+			input:         input,
+			failTeams:     map[string]bool{"2": true},
+			expectedErr:   ErrBatchFailed,
+			expectedTeams: []string{"1", "2", "3"},
+			retryTeams:    []string{"2"},
+		},
+		{
+			name:        "Should return unprocessable err for unsupported report type",
+			input:       `{"type": "other", "batch": {"id": "weekly", "teams": [{"team_info": {"id": "1"}}]}}`,
+			expectedErr: queue.ErrUnprocessable,
+		},
+		{
+			name:        "Should return err for batch without teams",
+			input:       `{"type": "scan", "batch": {"id": "weekly", "teams": []}}`,
+			expectedErr: ErrInvalidRequest,
+		},
+		{
+			name: "Should return err for duplicated teams",
+			input: `{"type": "scan", "batch": {"id": "weekly", "teams": [
+				{"team_info": {"id": "1"}}, {"team_info": {"id": "1"}}]}}`,
+			expectedErr: ErrInvalidRequest,
+		},
+		{
+			name:        "Should return unprocessable err for batch without id nor message id",
+			input:       `{"type": "scan", "batch": {"teams": [{"team_info": {"id": "1"}}]}}`,
+			noMssgID:    true,
+			expectedErr: queue.ErrUnprocessable,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				mu    sync.Mutex
+				teams []string
+				data  = map[string]map[string]interface{}{}
+			)
+			generateUC := &mockGenerateUC{
+				mockGenerateFunc: func(ctx context.Context, teamInfo teamInfo, reportData interface{}) (model.Report, error) {
+					mu.Lock()
+					defer mu.Unlock()
+					teams = append(teams, teamInfo.ID)
+					data[teamInfo.ID] = reportData.(map[string]interface{})
+					if tc.failTeams[teamInfo.ID] {
+						return nil, errMockGen
+					}
+					return mockReport, nil
+				},
+			}
+			processor, err := NewProcessor(log.New(), ProcessorConfig{BatchParallelism: 2}, map[model.ReportType]GenerateUC{"scan": generateUC},
+				&mockNotifier{}, &mockMetricsClient{}, nil, &mockProcessedMssgsRepository{mssgs: map[string]model.ProcessedMessage{}}, nil, nil)
+			if err != nil {
+				t.Fatalf("Error building processor: %v", err)
+			}
+
+			mssg := queue.Message{ID: "mssg-1", Body: tc.input}
+			if tc.noMssgID {
+				mssg.ID = ""
+			}
+			err = processor.ProcessMessage(context.Background(), mssg)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected err: %v\nBut got: %v", tc.expectedErr, err)
+			}
+			if tc.expectedErr != nil && !errors.Is(err, ErrBatchFailed) {
+				return
+			}
+
+			sort.Strings(teams)
+			if !reflect.DeepEqual(teams, tc.expectedTeams) {
+				t.Fatalf("Expected generated teams to be: %v\nBut got: %v", tc.expectedTeams, teams)
+			}
+			for _, id := range tc.expectedTeams {
+				url := "https://vulcan.example.com/" + id + "/live"
+				if data[id]["live_report_url"] != url || data[id]["team_id"] != id || data[id]["date_from"] != "2021-04-26" {
+					t.Fatalf("Unexpected data for team %s: %v", id, data[id])
+				}
+			}
+
+			// Redelivery only processes the failed teams.
+			teams = nil
+			tc.failTeams = nil
+			if err := processor.ProcessMessage(context.Background(), mssg); err != nil {
+				t.Fatalf("Expected no error, but got: %v", err)
+			}
+			if !reflect.DeepEqual(teams, tc.retryTeams) {
+				t.Fatalf("Expected retried teams to be: %v\nBut got: %v", tc.retryTeams, teams)
+			}
+		})
+	}
+}
+
+func TestProcessBatchUnprocessableTeams(t *testing.T) {
+	const inputFmt = `{
+		"type": "scan",
+		"auto_send": true,
+		"batch": {
+			"id": "weekly-2021-05-03",
+			"teams": [
+				{"team_info": {"id": "1", "recipients": ["invalid"]}, "data": {}},
+				{"team_info": {"id": "2", "recipients": ["%s"]}, "data": {}}
+			]
+		}
+	}`
+
+	testCases := []struct {
+		name                  string
+		recipient             string
+		failGen               bool
+		expectedUnprocessable bool
+	}{
+		{
+			name:                  "Should not retry batch if every failed team is unprocessable",
+			recipient:             "also-invalid",
+			expectedUnprocessable: true,
+		},
+		{
+			name:      "Should retry batch if some failed team can be processed",
+			recipient: "two@vulcan.example.com",
+			failGen:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			generateUC := &mockGenerateUC{
+				mockGenerateFunc: func(ctx context.Context, teamInfo teamInfo, reportData interface{}) (model.Report, error) {
+					if tc.failGen {
+						return nil, errMockGen
+					}
+					return mockReport, nil
+				},
+			}
+			processor, err := NewProcessor(log.New(), ProcessorConfig{}, map[model.ReportType]GenerateUC{"scan": generateUC},
+				&mockNotifier{}, &mockMetricsClient{}, nil, &mockProcessedMssgsRepository{mssgs: map[string]model.ProcessedMessage{}}, nil, nil)
+			if err != nil {
+				t.Fatalf("Error building processor: %v", err)
+			}
+
+			input := fmt.Sprintf(inputFmt, tc.recipient)
+			err = processor.ProcessMessage(context.Background(), queue.Message{ID: "mssg-1", Body: input})
+			if !errors.Is(err, ErrBatchFailed) {
+				t.Fatalf("Expected err: %v\nBut got: %v", ErrBatchFailed, err)
+			}
+			if errors.Is(err, queue.ErrUnprocessable) != tc.expectedUnprocessable {
+				t.Fatalf("Expected unprocessable to be %v, but got: %v", tc.expectedUnprocessable, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package report
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+
+	metrics "github.com/adevinta/vulcan-metrics-client"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/events"
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+	"github.com/adevinta/vulcan-reports-generator/pkg/notify"
+	"github.com/adevinta/vulcan-reports-generator/pkg/storage"
+)
+
+const (
+	defDispatcherInterval = 10 * time.Second
+	defMaxAttempts        = 5
+	defRetryBackoff       = time.Minute
+	// maxBackoffShift bounds the times the backoff is doubled.
+	maxBackoffShift = 10
+)
+
+var (
+	// ErrInvalidDispatcherConfig indicates that the dispatcher configuration is not valid.
+	ErrInvalidDispatcherConfig = errors.New("Invalid dispatcher configuration")
+)
+
+// DispatcherConfig is the configuration for the Dispatcher.
+//
+//   - Enabled makes the processor write the notifications to the
+//     outbox, instead of sending them, and starts the Dispatcher.
+//   - Interval is the time between polls of the outbox, in seconds.
+//     Defaults to 10.
+//   - MaxAttempts is the number of times a notification is tried
+//     before giving up on it. Defaults to 5.
+//   - RetryBackoff is the base time to wait before trying again a
+//     failed notification, in seconds, doubled on each attempt.
+//     Defaults to 60.
+type DispatcherConfig struct {
+	Enabled      bool  `toml:"enabled"`
+	Interval     int64 `toml:"interval"`
+	MaxAttempts  int   `toml:"max_attempts"`
+	RetryBackoff int64 `toml:"retry_backoff"`
+}
+
+// Dispatcher periodically sends the report notifications written to the
+// outbox. Each notification is locked while it is sent, so replicas can
+// dispatch concurrently, and the report status is updated in the same
+// transaction the send attempt is recorded. A notification is only
+// marked as sent once the transaction commits, so if the process dies
+// in between it is sent again, i.e.: at-least-once delivery.
+type Dispatcher struct {
+	*recorder
+	log          *log.Logger
+	cfg          DispatcherConfig
+	interval     time.Duration
+	retryBackoff time.Duration
+	generateUCC  map[model.ReportType]GenerateUC
+	notifier     notify.Notifier
+	outbox       storage.OutboxRepository
+}
+
+// NewDispatcher builds a new Dispatcher.
+// If publisher is nil, report lifecycle events are not published.
+// If deliveries is nil, report deliveries are not recorded.
+func NewDispatcher(log *log.Logger, cfg DispatcherConfig, generateUCC map[model.ReportType]GenerateUC,
+	notifier notify.Notifier, metricsClient metrics.Client, publisher events.Publisher,
+	deliveries storage.DeliveriesRepository, outbox storage.OutboxRepository) (*Dispatcher, error) {
+	if outbox == nil {
+		return nil, ErrInvalidDispatcherConfig
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defMaxAttempts
+	}
+
+	d := &Dispatcher{
+		recorder: &recorder{
+			log:           log,
+			metricsClient: metricsClient,
+			publisher:     publisher,
+			deliveries:    deliveries,
+		},
+		log:          log,
+		cfg:          cfg,
+		interval:     defDispatcherInterval,
+		retryBackoff: defRetryBackoff,
+		generateUCC:  generateUCC,
+		notifier:     notifier,
+		outbox:       outbox,
+	}
+	if cfg.Interval > 0 {
+		d.interval = time.Duration(cfg.Interval) * time.Second
+	}
+	if cfg.RetryBackoff > 0 {
+		d.retryBackoff = time.Duration(cfg.RetryBackoff) * time.Second
+	}
+
+	return d, nil
+}
+
+// Start makes the dispatcher send the due notifications periodically until ctx is done.
+func (d *Dispatcher) Start(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				d.log.WithFields(log.Fields{
+					"err":   err,
+					"trace": string(debug.Stack()),
+				}).Error("Dispatcher stopping due to panic err")
+			}
+
+			wg.Done()
+		}()
+
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := d.Dispatch(ctx); err != nil {
+					d.log.WithError(err).Error("Error dispatching notifications")
+				}
+			}
+		}
+	}()
+}
+
+// Dispatch sends the notifications of the outbox which are due,
+// one at a time, until there are none left or ctx is done.
+func (d *Dispatcher) Dispatch(ctx context.Context) error {
+	for ctx.Err() == nil {
+		var notif model.OutboxNotification
+		dispatched, err := d.outbox.DispatchNext(ctx, func(ctx context.Context, tx *sql.Tx, n *model.OutboxNotification) error {
+			err := d.send(ctx, tx, n)
+			notif = *n
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if !dispatched {
+			return nil
+		}
+		// The events are published once the outcome is committed.
+		switch notif.Status {
+		case model.OutboxStatusSent:
+			d.pushNotifMetric(notif.ReportType)
+			d.publishEvent(ctx, events.TypeReportSent, notif, model.StatusSent)
+		case model.OutboxStatusFailed:
+			d.publishEvent(ctx, events.TypeReportSendFailed, notif, model.StatusSendFailed)
+		}
+	}
+	return nil
+}
+
+// send sends the notification and sets its outcome. If the notification
+// is sent or given up on, the report status is updated within tx.
+// Otherwise, it is left pending to be tried again after the backoff.
+// Each attempt is only sent to the recipients which were not notified
+// by the previous ones.
+func (d *Dispatcher) send(ctx context.Context, tx *sql.Tx, n *model.OutboxNotification) error {
+	logger := d.log.WithFields(log.Fields{
+		"teamID":   n.TeamID,
+		"type":     n.ReportType,
+		"reportID": n.ReportID,
+		"attempts": n.Attempts,
+	})
+	logger.Debug("Sending notification")
+
+	notif := notify.Notification{
+		ReportType: n.ReportType,
+		TeamID:     n.TeamID,
+		Subject:    n.Notification.Subject,
+		Body:       n.Notification.Body,
+		Fmt:        n.Notification.Fmt,
+		Recipients: pendingRecipients(n.Recipients, n.DeliveredTo),
+	}
+	var (
+		res     notify.Result
+		sendErr error
+	)
+	// Nothing is sent if every recipient was already notified.
+	if len(n.DeliveredTo) == 0 || len(notif.Recipients) > 0 {
+		res, sendErr = d.notifier.Notify(ctx, notif)
+		d.saveDeliveries(ctx, res.Deliveries(n.ReportID, notif, sendErr))
+	}
+	n.DeliveredTo = slices.Concat(n.DeliveredTo, res.DeliveredTo(notif.Recipients))
+	n.Attempts++
+
+	if sendErr == nil {
+		n.Status, n.Error = model.OutboxStatusSent, ""
+		d.updateStatus(ctx, tx, n, model.StatusSent, n.DeliveredTo)
+		return nil
+	}
+
+	n.Error = sendErr.Error()
+	if n.Attempts < d.cfg.MaxAttempts && !isPermanentSendErr(sendErr) {
+		n.NextAttemptAt = time.Now().Add(d.retryBackoff << min(n.Attempts-1, maxBackoffShift))
+		logger.WithError(sendErr).WithField("nextAttemptAt", n.NextAttemptAt).Warn("Error sending notification, retrying later")
+		return nil
+	}
+
+	logger.WithError(sendErr).Error("Error sending notification, giving up")
+	n.Status = model.OutboxStatusFailed
+	d.updateStatus(ctx, tx, n, model.StatusSendFailed, nil)
+	return nil
+}
+
+// updateStatus transitions the report of the notification to the status
+// within tx, recording deliveredTo if it is sent. The update runs in a
+// savepoint, so if it fails the notification outcome is still recorded
+// and the notification is not sent again. The errors are logged.
+func (d *Dispatcher) updateStatus(ctx context.Context, tx *sql.Tx, n *model.OutboxNotification, status string, deliveredTo []string) {
+	logger := d.log.WithFields(log.Fields{
+		"type":     n.ReportType,
+		"reportID": n.ReportID,
+		"status":   status,
+	})
+
+	generateUC, ok := d.generateUCC[n.ReportType]
+	if !ok {
+		logger.Warn("Report type not supported, status not updated")
+		return
+	}
+	err := storage.Savepoint(ctx, tx, "report_status", func() error {
+		if status == model.StatusSent {
+			return generateUC.MarkSentTx(ctx, tx, n.ReportID, deliveredTo)
+		}
+		return generateUC.UpdateStatusTx(ctx, tx, n.ReportID, status, nil)
+	})
+	// The report may be gone or unable to transition to the
+	// status, e.g.: because it is being generated again.
+	if errors.Is(err, ErrInvalidStatusTransition) || errors.Is(err, storage.ErrReportNotFound) {
+		logger.WithError(err).Warn("Report status not updated")
+		return
+	}
+	if err != nil {
+		logger.WithError(err).Error("Error updating report status, notification outcome recorded anyway")
+	}
+}
+
+// publishEvent publishes a report lifecycle event for the dispatched notification.
+func (d *Dispatcher) publishEvent(ctx context.Context, typ string, n model.OutboxNotification, status string) {
+	data := events.ReportData{
+		ReportID:       n.ReportID,
+		ReportType:     string(n.ReportType),
+		TeamID:         n.TeamID,
+		Status:         status,
+		IdempotencyKey: n.IdempotencyKey,
+		Error:          n.Error,
+	}
+	if status == model.StatusSent {
+		data.Recipients = n.DeliveredTo
+	}
+	d.publish(ctx, typ, data)
+}
+
+// isPermanentSendErr returns true if sending
+// the notification again would fail the same way.
+func isPermanentSendErr(err error) bool {
+	return errors.Is(err, notify.ErrInvalidRecipients) ||
+		errors.Is(err, notify.ErrRecipientsSuppressed) ||
+		errors.Is(err, notify.ErrDailyCapExceeded) ||
+		errors.Is(err, notify.ErrUnsupportedFmt)
+}
@@ -0,0 +1,242 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package report
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/events"
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+	"github.com/adevinta/vulcan-reports-generator/pkg/notify"
+	"github.com/adevinta/vulcan-reports-generator/pkg/storage"
+)
+
+// OutboxRepository mock.
+type mockOutboxRepository struct {
+	storage.OutboxRepository
+	notifs []model.OutboxNotification
+}
+
+func (r *mockOutboxRepository) WithTx(tx *sql.Tx) storage.OutboxRepository {
+	return r
+}
+
+func (r *mockOutboxRepository) EnqueueNotification(ctx context.Context, n model.OutboxNotification) error {
+	r.notifs = append(r.notifs, n)
+	return nil
+}
+
+func (r *mockOutboxRepository) DispatchNext(ctx context.Context, fn func(ctx context.Context, tx *sql.Tx, n *model.OutboxNotification) error) (bool, error) {
+	for i, n := range r.notifs {
+		if n.Status != model.OutboxStatusPending || n.NextAttemptAt.After(time.Now()) {
+			continue
+		}
+		if err := fn(ctx, nil, &n); err != nil {
+			return false, err
+		}
+		r.notifs[i] = n
+		return true, nil
+	}
+	return false, nil
+}
+
+func TestDispatch(t *testing.T) {
+	recipients := []string{"a@vulcan.example.com", "b@vulcan.example.com"}
+
+	testCases := []struct {
+		name                string
+		attempts            int
+		deliveredTo         []string
+		notifyErr           error
+		delivered           []string
+		updateStatusErr     error
+		expectedStatus      string
+		expectedAttempts    int
+		expectedSentTo      []string
+		expectedDeliveredTo []string
+		expectedStatuses    []string
+		expectedEvents      []string
+		expectedMetrics     int
+	}{
+		{
+			name:                "Should send notification and mark report as sent",
+			expectedStatus:      model.OutboxStatusSent,
+			expectedAttempts:    1,
+			expectedSentTo:      recipients,
+			expectedDeliveredTo: recipients,
+			expectedStatuses:    []string{model.StatusSent},
+			expectedEvents:      []string{events.TypeReportSent},
+			expectedMetrics:     1,
+		},
+		{
+			name:             "Should keep failed notification pending to retry it",
+			notifyErr:        errMockNotify,
+			expectedStatus:   model.OutboxStatusPending,
+			expectedAttempts: 1,
+			expectedSentTo:   recipients,
+		},
+		{
+			name:                "Should record the recipients notified by a failed notification",
+			notifyErr:           errMockNotify,
+			delivered:           []string{"a@vulcan.example.com"},
+			expectedStatus:      model.OutboxStatusPending,
+			expectedAttempts:    1,
+			expectedSentTo:      recipients,
+			expectedDeliveredTo: []string{"a@vulcan.example.com"},
+		},
+		{
+			name:                "Should retry notification only to the recipients not notified",
+			attempts:            1,
+			deliveredTo:         []string{"a@vulcan.example.com"},
+			expectedStatus:      model.OutboxStatusSent,
+			expectedAttempts:    2,
+			expectedSentTo:      []string{"b@vulcan.example.com"},
+			expectedDeliveredTo: recipients,
+			expectedStatuses:    []string{model.StatusSent},
+			expectedEvents:      []string{events.TypeReportSent},
+			expectedMetrics:     1,
+		},
+		{
+			name:             "Should give up notification after max attempts",
+			attempts:         2,
+			notifyErr:        errMockNotify,
+			expectedStatus:   model.OutboxStatusFailed,
+			expectedAttempts: 3,
+			expectedSentTo:   recipients,
+			expectedStatuses: []string{model.StatusSendFailed},
+			expectedEvents:   []string{events.TypeReportSendFailed},
+		},
+		{
+			name:             "Should not retry notification to suppressed recipients",
+			notifyErr:        fmt.Errorf("%w: %v", notify.ErrRecipientsSuppressed, errMockNotify),
+			expectedStatus:   model.OutboxStatusFailed,
+			expectedAttempts: 1,
+			expectedSentTo:   recipients,
+			expectedStatuses: []string{model.StatusSendFailed},
+			expectedEvents:   []string{events.TypeReportSendFailed},
+		},
+		{
+			name:                "Should mark notification as sent if report status can not transition",
+			updateStatusErr:     ErrInvalidStatusTransition,
+			expectedStatus:      model.OutboxStatusSent,
+			expectedAttempts:    1,
+			expectedSentTo:      recipients,
+			expectedDeliveredTo: recipients,
+			expectedStatuses:    []string{model.StatusSent},
+			expectedEvents:      []string{events.TypeReportSent},
+			expectedMetrics:     1,
+		},
+		{
+			name:                "Should mark notification as sent if report status update fails",
+			updateStatusErr:     errMockUpdateStatus,
+			expectedStatus:      model.OutboxStatusSent,
+			expectedAttempts:    1,
+			expectedSentTo:      recipients,
+			expectedDeliveredTo: recipients,
+			expectedStatuses:    []string{model.StatusSent},
+			expectedEvents:      []string{events.TypeReportSent},
+			expectedMetrics:     1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var statuses []string
+			var sentTo []string
+			generateUC := &mockGenerateUC{
+				mockUpdateStatusFunc: func(ctx context.Context, reportID, status string) error {
+					statuses = append(statuses, status)
+					return tc.updateStatusErr
+				},
+			}
+			notifier := &mockNotifier{
+				mockFunc: func(subject, mssg string, fmt model.NotifFmt, recipients []string) error {
+					sentTo = recipients
+					return tc.notifyErr
+				},
+				providerID: "mssg-id",
+				delivered:  tc.delivered,
+			}
+			metricsClient := &mockMetricsClient{}
+			publisher := &mockPublisher{}
+			deliveries := &mockDeliveriesRepository{}
+			outbox := &mockOutboxRepository{
+				notifs: []model.OutboxNotification{
+					{
+						ID:           1,
+						ReportID:     mockReport.GetID(),
+						ReportType:   model.LiveReportType,
+						TeamID:       "1",
+						Notification: mockReport.GetNotification(),
+						Recipients:   recipients,
+						DeliveredTo:  tc.deliveredTo,
+						Status:       model.OutboxStatusPending,
+						Attempts:     tc.attempts,
+					},
+				},
+			}
+
+			dispatcher, err := NewDispatcher(log.New(), DispatcherConfig{MaxAttempts: 3},
+				map[model.ReportType]GenerateUC{model.LiveReportType: generateUC},
+				notifier, metricsClient, publisher, deliveries, outbox)
+			if err != nil {
+				t.Fatalf("Error building dispatcher: %v", err)
+			}
+
+			if err = dispatcher.Dispatch(context.Background()); err != nil {
+				t.Fatalf("Error dispatching notifications: %v", err)
+			}
+
+			n := outbox.notifs[0]
+			if n.Status != tc.expectedStatus || n.Attempts != tc.expectedAttempts {
+				t.Fatalf("Expected status and attempts: %s %d\nBut got: %s %d",
+					tc.expectedStatus, tc.expectedAttempts, n.Status, n.Attempts)
+			}
+			if n.Status == model.OutboxStatusPending && n.Attempts > 0 &&
+				(n.Error != tc.notifyErr.Error() || !n.NextAttemptAt.After(time.Now())) {
+				t.Fatalf("Expected notification to be retried later, but got: %+v", n)
+			}
+			if !reflect.DeepEqual(n.Recipients, recipients) {
+				t.Fatalf("Expected recipients: %v\nBut got: %v", recipients, n.Recipients)
+			}
+			if !reflect.DeepEqual(sentTo, tc.expectedSentTo) {
+				t.Fatalf("Expected notification sent to: %v\nBut got: %v", tc.expectedSentTo, sentTo)
+			}
+			if !reflect.DeepEqual(n.DeliveredTo, tc.expectedDeliveredTo) {
+				t.Fatalf("Expected notification delivered to: %v\nBut got: %v", tc.expectedDeliveredTo, n.DeliveredTo)
+			}
+			if !reflect.DeepEqual(statuses, tc.expectedStatuses) {
+				t.Fatalf("Expected statuses: %v\nBut got: %v", tc.expectedStatuses, statuses)
+			}
+			var published []string
+			for _, e := range publisher.published {
+				published = append(published, e.Type)
+				data := e.Data.(events.ReportData)
+				if e.Type == events.TypeReportSent && !reflect.DeepEqual(data.Recipients, tc.expectedDeliveredTo) {
+					t.Fatalf("Expected event recipients: %v\nBut got: %v", tc.expectedDeliveredTo, data.Recipients)
+				}
+			}
+			if !reflect.DeepEqual(published, tc.expectedEvents) {
+				t.Fatalf("Expected events: %v\nBut got: %v", tc.expectedEvents, published)
+			}
+			if metricsClient.calls != tc.expectedMetrics {
+				t.Fatalf("Expected metrics: %d\nBut got: %d", tc.expectedMetrics, metricsClient.calls)
+			}
+			if tc.notifyErr == nil && len(deliveries.deliveries) != len(tc.expectedSentTo) {
+				t.Fatalf("Expected %d deliveries, but got: %v", len(tc.expectedSentTo), deliveries.deliveries)
+			}
+			if tc.notifyErr == nil && !reflect.DeepEqual(generateUC.deliveredTo, tc.expectedDeliveredTo) {
+				t.Fatalf("Expected report delivered to: %v\nBut got: %v", tc.expectedDeliveredTo, generateUC.deliveredTo)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+	"github.com/adevinta/vulcan-reports-generator/pkg/queue"
+	"github.com/adevinta/vulcan-reports-generator/pkg/storage"
+)
+
+const (
+	// SES notification types.
+	sesBounce    = "Bounce"
+	sesComplaint = "Complaint"
+	sesDelivery  = "Delivery"
+
+	// sesPermanentBounce is the bounce type of the recipients
+	// that must not be notified again, e.g.: the mailbox does
+	// not exist anymore.
+	sesPermanentBounce = "Permanent"
+)
+
+var (
+	// ErrInvalidFeedback indicates that the SES feedback notification is not valid.
+	ErrInvalidFeedback = errors.New("Invalid SES feedback notification")
+)
+
+// sesFeedback is the SES feedback notification. Notifications
+// published through configuration sets set EventType instead
+// of NotificationType.
+type sesFeedback struct {
+	NotificationType string `json:"notificationType"`
+	EventType        string `json:"eventType"`
+	Mail             struct {
+		MessageID string `json:"messageId"`
+	} `json:"mail"`
+	Bounce *struct {
+		BounceType        string `json:"bounceType"`
+		BounceSubType     string `json:"bounceSubType"`
+		BouncedRecipients []struct {
+			EmailAddress   string `json:"emailAddress"`
+			DiagnosticCode string `json:"diagnosticCode"`
+		} `json:"bouncedRecipients"`
+	} `json:"bounce"`
+	Complaint *struct {
+		ComplaintFeedbackType string `json:"complaintFeedbackType"`
+		ComplainedRecipients  []struct {
+			EmailAddress string `json:"emailAddress"`
+		} `json:"complainedRecipients"`
+	} `json:"complaint"`
+	Delivery *struct {
+		Recipients []string `json:"recipients"`
+	} `json:"delivery"`
+}
+
+func (f sesFeedback) typ() string {
+	if f.NotificationType != "" {
+		return f.NotificationType
+	}
+	return f.EventType
+}
+
+// feedbackOutcome is the outcome of a notification for a recipient.
+type feedbackOutcome struct {
+	recipient string
+	status    string
+	detail    string
+	// suppress is the suppression reason, if the
+	// recipient must not be notified anymore.
+	suppress string
+}
+
+// SESFeedbackProcessor is a queue.Processor for the SES bounce,
+// complaint and delivery notifications. It updates the deliveries
+// of the notifications and adds the recipients that bounce
+// permanently or complain to the suppression list.
+type SESFeedbackProcessor struct {
+	log          *log.Logger
+	deliveries   storage.DeliveriesRepository
+	suppressions storage.SuppressionsRepository
+}
+
+// NewSESFeedbackProcessor builds a new SESFeedbackProcessor.
+func NewSESFeedbackProcessor(log *log.Logger, deliveries storage.DeliveriesRepository,
+	suppressions storage.SuppressionsRepository) *SESFeedbackProcessor {
+	return &SESFeedbackProcessor{
+		log:          log,
+		deliveries:   deliveries,
+		suppressions: suppressions,
+	}
+}
+
+// ProcessMessage processes an SES feedback notification.
+// Notifications of other types are ignored.
+func (p *SESFeedbackProcessor) ProcessMessage(ctx context.Context, mssg queue.Message) error {
+	var feedback sesFeedback
+	if err := json.Unmarshal([]byte(mssg.Body), &feedback); err != nil {
+		return unprocessable(fmt.Errorf("%w: %v", ErrInvalidFeedback, err))
+	}
+
+	outcomes, err := parseFeedback(feedback)
+	if err != nil {
+		return unprocessable(err)
+	}
+
+	messageID := feedback.Mail.MessageID
+	for _, o := range outcomes {
+		logger := p.log.WithFields(log.Fields{
+			"messageID": messageID,
+			"recipient": o.recipient,
+			"status":    o.status,
+		})
+
+		if o.suppress != "" {
+			err := p.suppressions.SaveSuppression(ctx, model.Suppression{
+				Email:             o.recipient,
+				Reason:            o.suppress,
+				Detail:            o.detail,
+				ProviderMessageID: messageID,
+				CreatedAt:         time.Now(),
+			})
+			if err != nil {
+				return err
+			}
+			logger.Info("Recipient added to the suppression list")
+		}
+
+		err := p.deliveries.UpdateDeliveryStatus(ctx, messageID, o.recipient, o.status, o.detail)
+		if errors.Is(err, storage.ErrDeliveryNotFound) {
+			// Notifications sent before deliveries were
+			// tracked, or by other senders of the identity.
+			logger.Warn("Delivery not found for SES feedback")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseFeedback returns the outcome for each recipient of the feedback.
+func parseFeedback(f sesFeedback) ([]feedbackOutcome, error) {
+	var outcomes []feedbackOutcome
+
+	switch f.typ() {
+	case sesBounce:
+		if f.Bounce == nil {
+			return nil, fmt.Errorf("%w: missing bounce", ErrInvalidFeedback)
+		}
+		var suppress string
+		if f.Bounce.BounceType == sesPermanentBounce {
+			suppress = model.SuppressionReasonBounce
+		}
+		for _, r := range f.Bounce.BouncedRecipients {
+			detail := f.Bounce.BounceType + "/" + f.Bounce.BounceSubType
+			if r.DiagnosticCode != "" {
+				detail += ": " + r.DiagnosticCode
+			}
+			outcomes = append(outcomes, feedbackOutcome{
+				recipient: r.EmailAddress,
+				status:    model.DeliveryStatusBounced,
+				detail:    detail,
+				suppress:  suppress,
+			})
+		}
+	case sesComplaint:
+		if f.Complaint == nil {
+			return nil, fmt.Errorf("%w: missing complaint", ErrInvalidFeedback)
+		}
+		for _, r := range f.Complaint.ComplainedRecipients {
+			outcomes = append(outcomes, feedbackOutcome{
+				recipient: r.EmailAddress,
+				status:    model.DeliveryStatusComplained,
+				detail:    f.Complaint.ComplaintFeedbackType,
+				suppress:  model.SuppressionReasonComplaint,
+			})
+		}
+	case sesDelivery:
+		if f.Delivery == nil {
+			return nil, fmt.Errorf("%w: missing delivery", ErrInvalidFeedback)
+		}
+		for _, r := range f.Delivery.Recipients {
+			outcomes = append(outcomes, feedbackOutcome{
+				recipient: r,
+				status:    model.DeliveryStatusDelivered,
+			})
+		}
+	default:
+		return nil, nil
+	}
+
+	if f.Mail.MessageID == "" {
+		return nil, fmt.Errorf("%w: missing message ID", ErrInvalidFeedback)
+	}
+	for i := range outcomes {
+		outcomes[i].recipient = strings.TrimSpace(outcomes[i].recipient)
+	}
+	return outcomes, nil
+}
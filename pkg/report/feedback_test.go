@@ -0,0 +1,121 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package report
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+	"github.com/adevinta/vulcan-reports-generator/pkg/queue"
+	"github.com/adevinta/vulcan-reports-generator/pkg/storage"
+)
+
+func (r *mockDeliveriesRepository) UpdateDeliveryStatus(ctx context.Context, providerMessageID, recipient, status, errMssg string) error {
+	if recipient == "unknown@vulcan.example.com" {
+		return storage.ErrDeliveryNotFound
+	}
+	r.updates = append(r.updates, providerMessageID+" "+recipient+" "+status)
+	return nil
+}
+
+// SuppressionsRepository mock.
+type mockSuppressionsRepository struct {
+	storage.SuppressionsRepository
+	suppressions []model.Suppression
+}
+
+func (r *mockSuppressionsRepository) SaveSuppression(ctx context.Context, suppression model.Suppression) error {
+	r.suppressions = append(r.suppressions, suppression)
+	return nil
+}
+
+func TestProcessFeedback(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		body                 string
+		expectedErr          error
+		expectedUpdates      []string
+		expectedSuppressions []string
+	}{
+		{
+			name: "Should suppress permanent bounces",
+			body: `{"notificationType": "Bounce", "mail": {"messageId": "mssg-1"},
+				"bounce": {"bounceType": "Permanent", "bounceSubType": "General",
+				"bouncedRecipients": [{"emailAddress": "tom@vulcan.example.com", "diagnosticCode": "550 user unknown"}]}}`,
+			expectedUpdates:      []string{"mssg-1 tom@vulcan.example.com BOUNCED"},
+			expectedSuppressions: []string{"tom@vulcan.example.com BOUNCE Permanent/General: 550 user unknown"},
+		},
+		{
+			name: "Should not suppress transient bounces",
+			body: `{"notificationType": "Bounce", "mail": {"messageId": "mssg-1"},
+				"bounce": {"bounceType": "Transient", "bounceSubType": "MailboxFull",
+				"bouncedRecipients": [{"emailAddress": "tom@vulcan.example.com"}]}}`,
+			expectedUpdates: []string{"mssg-1 tom@vulcan.example.com BOUNCED"},
+		},
+		{
+			name: "Should suppress complaints",
+			body: `{"eventType": "Complaint", "mail": {"messageId": "mssg-1"},
+				"complaint": {"complaintFeedbackType": "abuse",
+				"complainedRecipients": [{"emailAddress": "tom@vulcan.example.com"}]}}`,
+			expectedUpdates:      []string{"mssg-1 tom@vulcan.example.com COMPLAINED"},
+			expectedSuppressions: []string{"tom@vulcan.example.com COMPLAINT abuse"},
+		},
+		{
+			name: "Should update deliveries and skip unknown ones",
+			body: `{"notificationType": "Delivery", "mail": {"messageId": "mssg-1"},
+				"delivery": {"recipients": ["tom@vulcan.example.com", "unknown@vulcan.example.com"]}}`,
+			expectedUpdates: []string{"mssg-1 tom@vulcan.example.com DELIVERED"},
+		},
+		{
+			name: "Should ignore other notification types",
+			body: `{"eventType": "Open", "mail": {"messageId": "mssg-1"}}`,
+		},
+		{
+			name:        "Should return ErrInvalidFeedback for missing message ID",
+			body:        `{"notificationType": "Delivery", "delivery": {"recipients": ["tom@vulcan.example.com"]}}`,
+			expectedErr: ErrInvalidFeedback,
+		},
+		{
+			name:        "Should return ErrInvalidFeedback for malformed body",
+			body:        `{`,
+			expectedErr: ErrInvalidFeedback,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			deliveries := &mockDeliveriesRepository{}
+			suppressions := &mockSuppressionsRepository{}
+			processor := NewSESFeedbackProcessor(log.New(), deliveries, suppressions)
+
+			err := processor.ProcessMessage(context.Background(), queue.Message{Body: tc.body})
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected err: %v\nBut got: %v", tc.expectedErr, err)
+			}
+			// Invalid feedback is never retried.
+			if tc.expectedErr != nil && !errors.Is(err, queue.ErrUnprocessable) {
+				t.Fatalf("Expected err: %v\nBut got: %v", queue.ErrUnprocessable, err)
+			}
+			if !reflect.DeepEqual(deliveries.updates, tc.expectedUpdates) {
+				t.Fatalf("Expected updates: %v\nBut got: %v", tc.expectedUpdates, deliveries.updates)
+			}
+			var suppressed []string
+			for _, s := range suppressions.suppressions {
+				if s.ProviderMessageID != "mssg-1" {
+					t.Fatalf("Unexpected suppression: %+v", s)
+				}
+				suppressed = append(suppressed, s.Email+" "+s.Reason+" "+s.Detail)
+			}
+			if !reflect.DeepEqual(suppressed, tc.expectedSuppressions) {
+				t.Fatalf("Expected suppressions: %v\nBut got: %v", tc.expectedSuppressions, suppressed)
+			}
+		})
+	}
+}
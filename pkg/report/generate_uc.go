@@ -6,6 +6,8 @@ package report
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 
 	log "github.com/sirupsen/logrus"
 
@@ -13,12 +15,28 @@ import (
 	"github.com/adevinta/vulcan-reports-generator/pkg/storage"
 )
 
+var (
+	// ErrInvalidStatusTransition indicates that the report can not transition to the requested status.
+	ErrInvalidStatusTransition = errors.New("Invalid report status transition")
+)
+
 // GenerateUC represents the Use Case interface for a report generation.
 type GenerateUC interface {
 	// Generate generates the report based on request data.
 	Generate(ctx context.Context, teamInfo teamInfo, reportData interface{}) (model.Report, error)
-	// Finish finishes report generation updating its status.
-	Finish(ctx context.Context, reportID, status string) error
+	// GetReport returns the report for the specified ID.
+	GetReport(ctx context.Context, reportID string) (model.Report, error)
+	// UpdateStatus transitions the report to the specified status.
+	UpdateStatus(ctx context.Context, reportID, status string) error
+	// UpdateStatusTx transitions the report to the specified status within
+	// tx or, if nil, a new transaction, and calls fn, if not nil, within the
+	// same transaction, so its changes are only committed along with the
+	// status change.
+	UpdateStatusTx(ctx context.Context, tx *sql.Tx, reportID, status string, fn func(tx *sql.Tx) error) error
+	// MarkSentTx transitions the report to the sent status within tx or,
+	// if nil, a new transaction, recording the recipients its notification
+	// was delivered to.
+	MarkSentTx(ctx context.Context, tx *sql.Tx, reportID string, deliveredTo []string) error
 }
 
 // NewGenerateUC creates a new report generate use case based on specified type.
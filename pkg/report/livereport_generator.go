@@ -14,6 +14,8 @@ import (
 	"strings"
 
 	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/notify"
 )
 
 const (
@@ -92,6 +94,9 @@ func (g *liveReportGenerator) Generate(ctx context.Context, teamInfo teamInfo, r
 	if err != nil {
 		return nil, err
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	// Print report files.
 	g.log.WithFields(log.Fields{
@@ -104,8 +109,10 @@ func (g *liveReportGenerator) Generate(ctx context.Context, teamInfo teamInfo, r
 		return nil, err
 	}
 
-	liveReportData.EmailSubject = fmt.Sprintf(liveEmailSubjectFmt,
-		g.cfg.EmailSubject, teamInfo.Name)
+	// The team name comes from the request, so it must
+	// not be able to inject headers through the subject.
+	liveReportData.EmailSubject = notify.SanitizeHeader(fmt.Sprintf(liveEmailSubjectFmt,
+		g.cfg.EmailSubject, teamInfo.Name))
 	return liveReportData, nil
 }
 
@@ -23,6 +23,9 @@ func TestGenerateLiveReport(t *testing.T) {
 	}
 
 	mockLog := log.New()
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
 	type fields struct {
 		cfg          liveReportGeneratorCfg
 		retEmailBody string
@@ -49,6 +52,7 @@ func TestGenerateLiveReport(t *testing.T) {
 				retEmailBody: "notif",
 			},
 			input: input{
+				ctx: context.Background(),
 				reportData: liveReportRequest{
 					TeamID:   "1",
 					DateFrom: "2020-09-01",
@@ -69,12 +73,28 @@ func TestGenerateLiveReport(t *testing.T) {
 				cfg: mockCfg,
 			},
 			input: input{
+				ctx: context.Background(),
 				reportData: liveReportRequest{
 					DateFrom: "2020-09-01",
 				},
 			},
 			expectedErr: ErrInvalidRequest,
 		},
+		{
+			name: "Should return err due to ctx done",
+			fields: fields{
+				cfg: mockCfg,
+			},
+			input: input{
+				ctx: canceledCtx,
+				reportData: liveReportRequest{
+					TeamID:   "1",
+					DateFrom: "2020-09-01",
+					DateTo:   "2020-09-07",
+				},
+			},
+			expectedErr: context.Canceled,
+		},
 	}
 
 	for _, tc := range testCases {
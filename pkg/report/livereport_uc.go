@@ -6,6 +6,8 @@ package report
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
 
 	"github.com/mitchellh/mapstructure"
 	log "github.com/sirupsen/logrus"
@@ -58,7 +60,8 @@ func (uc *livereportUC) Generate(ctx context.Context, teamInfo teamInfo, reportD
 
 	data, err := uc.generator.Generate(ctx, teamInfo, liveReportReq)
 	if err != nil {
-		uc.Finish(ctx, report.ID, model.StatusFailed)
+		// The failure is recorded even if ctx is already done.
+		uc.UpdateStatus(context.WithoutCancel(ctx), report.ID, model.StatusGenerationFailed)
 		return nil, err
 	}
 	liveReportData := data.(liveReportData)
@@ -74,7 +77,7 @@ func (uc *livereportUC) Generate(ctx context.Context, teamInfo teamInfo, reportD
 	report.Notification.Subject = liveReportData.EmailSubject
 	report.Notification.Body = liveReportData.EmailBody
 	report.Notification.Fmt = model.NotifFmtHTML
-	report.DeliveredTo = teamInfo.Recipients
+	report.Status = model.StatusGenerated
 
 	err = uc.repository.SaveReport(ctx, report)
 	if err != nil {
@@ -84,24 +87,59 @@ func (uc *livereportUC) Generate(ctx context.Context, teamInfo teamInfo, reportD
 	return report, nil
 }
 
-func (uc *livereportUC) Finish(ctx context.Context, reportID, status string) error {
-	report, err := uc.repository.GetReport(ctx, reportID)
-	if err != nil {
-		return err
-	}
-	liveReport := report.(*model.LiveReport)
+func (uc *livereportUC) GetReport(ctx context.Context, reportID string) (model.Report, error) {
+	return uc.repository.GetReport(ctx, reportID)
+}
 
-	uc.log.WithFields(log.Fields{
-		"reportID": liveReport.ID,
-		"type":     "livereport",
-		"TeamID":   liveReport.TeamID,
-		"DateFrom": liveReport.DateFrom,
-		"DateTo":   liveReport.DateTo,
-		"status":   status,
-	}).Info("Finishing report generation")
-
-	liveReport.Status = status
-	return uc.repository.SaveReport(ctx, liveReport)
+func (uc *livereportUC) UpdateStatus(ctx context.Context, reportID, status string) error {
+	return uc.UpdateStatusTx(ctx, nil, reportID, status, nil)
+}
+
+func (uc *livereportUC) UpdateStatusTx(ctx context.Context, tx *sql.Tx, reportID, status string, fn func(tx *sql.Tx) error) error {
+	return uc.updateStatus(ctx, tx, reportID, status, nil, fn)
+}
+
+func (uc *livereportUC) MarkSentTx(ctx context.Context, tx *sql.Tx, reportID string, deliveredTo []string) error {
+	return uc.updateStatus(ctx, tx, reportID, model.StatusSent, deliveredTo, nil)
+}
+
+// updateStatus transitions the report to status and, if deliveredTo
+// is not nil, records it as the recipients of the report notification.
+func (uc *livereportUC) updateStatus(ctx context.Context, tx *sql.Tx, reportID, status string, deliveredTo []string, fn func(tx *sql.Tx) error) error {
+	// The report is locked while its status transition is checked and saved.
+	return uc.repository.WithTx(tx).Transact(ctx, func(r storage.ReportsRepository) error {
+		report, err := r.GetReport(ctx, reportID)
+		if err != nil {
+			return err
+		}
+		liveReport := report.(*model.LiveReport)
+
+		uc.log.WithFields(log.Fields{
+			"reportID": liveReport.ID,
+			"type":     "livereport",
+			"TeamID":   liveReport.TeamID,
+			"DateFrom": liveReport.DateFrom,
+			"DateTo":   liveReport.DateTo,
+			"from":     liveReport.Status,
+			"status":   status,
+		}).Info("Updating report status")
+
+		if !model.IsValidTransition(liveReport.Status, status) {
+			return fmt.Errorf("%w: from %s to %s", ErrInvalidStatusTransition, liveReport.Status, status)
+		}
+
+		liveReport.Status = status
+		if deliveredTo != nil {
+			liveReport.DeliveredTo = deliveredTo
+		}
+		if err := r.SaveReport(ctx, liveReport); err != nil {
+			return err
+		}
+		if fn == nil {
+			return nil
+		}
+		return fn(r.Tx())
+	})
 }
 
 func parseLiveReportRequest(req interface{}) (liveReportRequest, error) {
@@ -6,9 +6,11 @@ package report
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"reflect"
 	"testing"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -35,20 +37,40 @@ func (g *mockGenerator) Generate(ctx context.Context, teamInfo teamInfo, reportD
 // Repository mock.
 type mockGetFunc func(ctx context.Context, reportID string) (model.Report, error)
 type mockSaveFunc func(ctx context.Context, report model.Report) error
+type mockGetByStatusFunc func(ctx context.Context, status string, statusUpdatedBefore time.Time) ([]model.Report, error)
 type mockReportsRepository struct {
 	storage.ReportsRepository
-	mockGetFunc  mockGetFunc
-	mockSaveFunc mockSaveFunc
+	mockGetFunc         mockGetFunc
+	mockSaveFunc        mockSaveFunc
+	mockGetByStatusFunc mockGetByStatusFunc
+	transactions        int
 }
 
 func (r *mockReportsRepository) GetReport(ctx context.Context, reportID string) (model.Report, error) {
 	return r.mockGetFunc(ctx, reportID)
 }
 
+func (r *mockReportsRepository) GetReportsByStatus(ctx context.Context, status string, statusUpdatedBefore time.Time) ([]model.Report, error) {
+	return r.mockGetByStatusFunc(ctx, status, statusUpdatedBefore)
+}
+
 func (r *mockReportsRepository) SaveReport(ctx context.Context, report model.Report) error {
 	return r.mockSaveFunc(ctx, report)
 }
 
+func (r *mockReportsRepository) WithTx(tx *sql.Tx) storage.ReportsRepository {
+	return r
+}
+
+func (r *mockReportsRepository) Tx() *sql.Tx {
+	return nil
+}
+
+func (r *mockReportsRepository) Transact(ctx context.Context, fn func(r storage.ReportsRepository) error) error {
+	r.transactions++
+	return fn(r)
+}
+
 type fields struct {
 	generator  Generator
 	repository storage.ReportsRepository
@@ -72,7 +94,8 @@ func TestGenerateUCLiveReport(t *testing.T) {
 						if !ok {
 							return errors.New("Report is not LiveReport")
 						}
-						if r.TeamID != "11" || r.Status != model.StatusGenerating {
+						if r.TeamID != "11" ||
+							(r.Status != model.StatusGenerating && r.Status != model.StatusGenerated) {
 							return errors.New("Report does not match input")
 						}
 						// Set mock ID.
@@ -108,7 +131,7 @@ func TestGenerateUCLiveReport(t *testing.T) {
 			expectedReport: &model.LiveReport{
 				BaseReport: model.BaseReport{
 					ID:     "12345",
-					Status: model.StatusGenerating,
+					Status: model.StatusGenerated,
 					Notification: model.Notification{
 						Subject: "emailSubject",
 						Body:    "emailBody",
@@ -220,7 +243,7 @@ func TestGenerateUCLiveReport(t *testing.T) {
 	}
 }
 
-func TestFinishLiveReport(t *testing.T) {
+func TestUpdateStatusLiveReport(t *testing.T) {
 	testCases := []struct {
 		name        string
 		fields      fields
@@ -250,7 +273,7 @@ func TestFinishLiveReport(t *testing.T) {
 						if r.ID != "1" || r.TeamID != "111" {
 							return errors.New("Report data does not match input")
 						}
-						if r.Status != model.StatusFinished {
+						if r.Status != model.StatusGenerated {
 							return errors.New("Status or recipients not updated")
 						}
 						return nil
@@ -258,7 +281,25 @@ func TestFinishLiveReport(t *testing.T) {
 				},
 			},
 			reportID: "1",
-			status:   model.StatusFinished,
+			status:   model.StatusGenerated,
+		},
+		{
+			name: "Should return ErrInvalidStatusTransition",
+			fields: fields{
+				repository: &mockReportsRepository{
+					mockGetFunc: func(ctx context.Context, reportID string) (model.Report, error) {
+						return &model.LiveReport{
+							BaseReport: model.BaseReport{
+								ID:     reportID,
+								Status: model.StatusGenerating,
+							},
+						}, nil
+					},
+				},
+			},
+			reportID:    "1",
+			status:      model.StatusSent,
+			expectedErr: ErrInvalidStatusTransition,
 		},
 		{
 			name: "Should return ErrMockGet",
@@ -286,6 +327,7 @@ func TestFinishLiveReport(t *testing.T) {
 					},
 				},
 			},
+			status:      model.StatusGenerating,
 			expectedErr: errMockSave,
 		},
 	}
@@ -301,10 +343,44 @@ func TestFinishLiveReport(t *testing.T) {
 				repository: tc.fields.repository,
 			}
 
-			err := genUC.Finish(ctx, tc.reportID, tc.status)
+			err := genUC.UpdateStatus(ctx, tc.reportID, tc.status)
 			if !errors.Is(err, tc.expectedErr) {
 				t.Fatalf("Expected err: %v\nBut got: %v", tc.expectedErr, err)
 			}
+			// The status is read and saved within a transaction.
+			if r := tc.fields.repository.(*mockReportsRepository); r.transactions != 1 {
+				t.Fatalf("Expected transactions to be: %d\nBut got: %d", 1, r.transactions)
+			}
 		})
 	}
 }
+
+func TestMarkSentLiveReport(t *testing.T) {
+	var saved *model.LiveReport
+	repository := &mockReportsRepository{
+		mockGetFunc: func(ctx context.Context, reportID string) (model.Report, error) {
+			return &model.LiveReport{
+				BaseReport: model.BaseReport{
+					ID:     reportID,
+					Status: model.StatusSending,
+				},
+			}, nil
+		},
+		mockSaveFunc: func(ctx context.Context, report model.Report) error {
+			saved = report.(*model.LiveReport)
+			return nil
+		},
+	}
+	genUC := &livereportUC{
+		log:        log.New(),
+		repository: repository,
+	}
+
+	recipients := []string{"a@vulcan.example.com"}
+	if err := genUC.MarkSentTx(context.Background(), nil, "1", recipients); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if saved == nil || saved.Status != model.StatusSent || !reflect.DeepEqual(saved.DeliveredTo, recipients) {
+		t.Fatalf("Expected report sent to: %v\nBut got: %+v", recipients, saved)
+	}
+}
@@ -6,16 +6,26 @@ package report
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
+	"sync"
+	"time"
 
 	metrics "github.com/adevinta/vulcan-metrics-client"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/adevinta/vulcan-reports-generator/pkg/events"
 	"github.com/adevinta/vulcan-reports-generator/pkg/model"
 	"github.com/adevinta/vulcan-reports-generator/pkg/notify"
 	"github.com/adevinta/vulcan-reports-generator/pkg/queue"
+	"github.com/adevinta/vulcan-reports-generator/pkg/storage"
+)
+
+const (
+	defClaimLease = time.Hour
 )
 
 var (
@@ -23,6 +33,8 @@ var (
 	ErrInvalidRequest = errors.New("Invalid request")
 	// ErrUnsupportedReportType indicates that the specified report type is not supported.
 	ErrUnsupportedReportType = errors.New("The requested report type is not supported")
+	// ErrReportTypeNotAllowed indicates that the report type is not allowed in the queue the request was read from.
+	ErrReportTypeNotAllowed = errors.New("The requested report type is not allowed in the queue")
 )
 
 // genRequest represents the expected
@@ -35,11 +47,17 @@ var (
 //     parsed by the specified generator.
 //   - AutoSend indicates if report notification
 //     must be sent automatically.
+//   - IdempotencyKey optionally identifies the request
+//     to deduplicate it, instead of the queue message ID.
+//   - Version is the version of the Data schema for
+//     the report type. Defaults to 1.
 type genRequest struct {
-	Typ      model.ReportType `json:"type"`
-	TeamInfo teamInfo         `json:"team_info"`
-	Data     interface{}      `json:"data"`
-	AutoSend bool             `json:"auto_send"`
+	Typ            model.ReportType `json:"type"`
+	Version        int              `json:"version,omitempty"`
+	TeamInfo       teamInfo         `json:"team_info"`
+	Data           interface{}      `json:"data"`
+	AutoSend       bool             `json:"auto_send"`
+	IdempotencyKey string           `json:"idempotency_key"`
 }
 
 type teamInfo struct {
@@ -48,93 +66,434 @@ type teamInfo struct {
 	Recipients []string `json:"recipients"`
 }
 
+// ProcessorConfig is the configuration for the reports processor.
+//
+//   - TeamLock makes the processor handle the requests of the
+//     same team one at a time within the process. It is meant for
+//     standard queues, as FIFO queues already provide this
+//     guarantee across replicas through per-team message groups.
+//   - BatchParallelism is the max number of teams of a batch
+//     request processed concurrently. Defaults to 4.
+//   - QueueReportTypes restricts the report types that can be
+//     requested through each queue, identified by its name.
+//     Queues not present accept every report type.
+//   - Recipients restricts the recipients of the requests
+//     that must be sent once generated.
+//   - ClaimLease is the time, in seconds, a request is locked for
+//     while it is processed, so concurrent deliveries of the same
+//     request are skipped. It must be longer than the processing
+//     of a request. Defaults to 3600.
+type ProcessorConfig struct {
+	TeamLock         bool                          `toml:"team_lock"`
+	BatchParallelism int                           `toml:"batch_parallelism"`
+	ClaimLease       int64                         `toml:"claim_lease"`
+	QueueReportTypes map[string][]model.ReportType `toml:"-"`
+	Recipients       notify.RecipientsConfig       `toml:"-"`
+}
+
 type reportsProcessor struct {
-	log           *log.Logger
-	generateUCC   map[model.ReportType]GenerateUC
-	notifier      notify.Notifier
-	metricsClient metrics.Client
+	*recorder
+	log            *log.Logger
+	generateUCC    map[model.ReportType]GenerateUC
+	notifier       notify.Notifier
+	processedMssgs storage.ProcessedMessagesRepository
+	outbox         storage.OutboxRepository
+	queueTypes     map[string][]model.ReportType
+	recipients     notify.RecipientsConfig
+	teamLocks      *teamLocks
+	batchParallel  int
+	claimLease     time.Duration
+	schemas        requestSchemas
 }
 
 // NewProcessor builds and returns a new Reports Processor.
-func NewProcessor(log *log.Logger, generateUCC map[model.ReportType]GenerateUC,
-	notifier notify.Notifier, metricsClient metrics.Client) (queue.Processor, error) {
-	return &reportsProcessor{
-		log:           log,
-		generateUCC:   generateUCC,
-		notifier:      notifier,
-		metricsClient: metricsClient,
-	}, nil
+// If publisher is nil, report lifecycle events are not published.
+// If deliveries is nil, report deliveries are not recorded.
+// If outbox is not nil, the report notifications are written to it,
+// to be sent by the Dispatcher, instead of being sent right away.
+func NewProcessor(log *log.Logger, cfg ProcessorConfig, generateUCC map[model.ReportType]GenerateUC,
+	notifier notify.Notifier, metricsClient metrics.Client, publisher events.Publisher,
+	processedMssgs storage.ProcessedMessagesRepository, deliveries storage.DeliveriesRepository,
+	outbox storage.OutboxRepository) (queue.Processor, error) {
+	p := &reportsProcessor{
+		recorder: &recorder{
+			log:           log,
+			metricsClient: metricsClient,
+			publisher:     publisher,
+			deliveries:    deliveries,
+		},
+		log:            log,
+		generateUCC:    generateUCC,
+		notifier:       notifier,
+		processedMssgs: processedMssgs,
+		outbox:         outbox,
+		queueTypes:     cfg.QueueReportTypes,
+		recipients:     cfg.Recipients,
+		batchParallel:  cfg.BatchParallelism,
+		claimLease:     defClaimLease,
+	}
+	schemas, err := loadRequestSchemas()
+	if err != nil {
+		return nil, err
+	}
+	p.schemas = schemas
+	if p.batchParallel <= 0 {
+		p.batchParallel = defBatchParallelism
+	}
+	if cfg.ClaimLease > 0 {
+		p.claimLease = time.Duration(cfg.ClaimLease) * time.Second
+	}
+	if cfg.TeamLock {
+		p.teamLocks = newTeamLocks()
+	}
+	return p, nil
 }
 
 // ProcessMessage processes a report generation request read
-// from the queue.
-func (p *reportsProcessor) ProcessMessage(mssg string) error {
-	req, err := parseGenRequest(mssg)
+// from the queue along with the message metadata.
+// The processing is aborted once ctx is done.
+func (p *reportsProcessor) ProcessMessage(ctx context.Context, mssg queue.Message) error {
+	batch, err := parseBatchGenRequest(mssg.Body)
 	if err != nil {
-		return err
+		return unprocessable(err)
+	}
+	if batch != nil {
+		return p.processBatch(ctx, *batch, mssg)
 	}
-	ctx := context.Background()
 
+	req, err := parseGenRequest(mssg.Body)
+	if err != nil {
+		return unprocessable(err)
+	}
+	return p.processRequest(ctx, req, mssg)
+}
+
+// processRequest processes a single report generation request.
+func (p *reportsProcessor) processRequest(ctx context.Context, req genRequest, mssg queue.Message) error {
 	p.log.WithFields(log.Fields{
-		"teamID":   req.TeamInfo.ID,
-		"teamName": req.TeamInfo.Name,
-		"type":     req.Typ,
-		"send":     req.AutoSend,
+		"teamID":    req.TeamInfo.ID,
+		"teamName":  req.TeamInfo.Name,
+		"type":      req.Typ,
+		"send":      req.AutoSend,
+		"version":   req.Version,
+		"mssgID":    mssg.ID,
+		"mssgAttrs": mssg.Attributes,
+		"receives":  mssg.ReceiveCount,
 	}).Info("Processing report")
 
+	if p.teamLocks != nil {
+		unlock := p.teamLocks.lock(req.TeamInfo.ID)
+		defer unlock()
+	}
+
 	generateUC, ok := p.generateUCC[req.Typ]
 	if !ok {
-		return ErrUnsupportedReportType
+		return unprocessable(ErrUnsupportedReportType)
+	}
+	if !p.isAllowedInQueue(req.Typ, mssg.Queue) {
+		return unprocessable(ErrReportTypeNotAllowed)
+	}
+	if err := p.schemas.validate(req.Typ, req.Version, req.Data); err != nil {
+		return unprocessable(err)
+	}
+	if req.AutoSend {
+		if err := p.recipients.ValidateRecipients(req.TeamInfo.Recipients); err != nil {
+			return unprocessable(fmt.Errorf("%w: %w", ErrInvalidRequest, err))
+		}
 	}
 
-	// Generate.
-	report, err := generateUC.Generate(ctx, req.TeamInfo, req.Data)
+	// Check if the request was already processed,
+	// or it is being processed by another consumer.
+	processed, claimed, err := p.claimProcessedMessage(ctx, req, mssg)
 	if err != nil {
 		return err
 	}
-
-	p.pushGenMetric(req.Typ)
-
-	// Notify.
-	if req.AutoSend {
+	if !claimed {
+		p.log.WithFields(log.Fields{
+			"teamID": req.TeamInfo.ID,
+			"type":   req.Typ,
+			"mssgID": mssg.ID,
+		}).Info("Skipping report request being processed by another consumer")
+		return nil
+	}
+	defer p.releaseProcessedMessage(ctx, processed)
+	if processed != nil && processed.Stage == model.StageFinished {
 		p.log.WithFields(log.Fields{
 			"teamID":   req.TeamInfo.ID,
 			"type":     req.Typ,
-			"reportID": report.GetID(),
-		}).Debug("Sending notification")
-		notif := report.GetNotification()
-		err = p.notifier.Notify(notif.Subject, notif.Body, notif.Fmt, req.TeamInfo.Recipients)
+			"reportID": processed.ReportID,
+			"key":      processed.Key,
+		}).Info("Skipping already processed report request")
+		return nil
+	}
+
+	// Generate, or resume from the already generated report.
+	report, err := p.resumeReport(ctx, generateUC, processed)
+	if err != nil {
+		return err
+	}
+	if report == nil {
+		report, err = generateUC.Generate(ctx, req.TeamInfo, req.Data)
 		if err != nil {
-			generateUC.Finish(ctx, report.GetID(), model.StatusFailed)
+			p.publishEvent(ctx, events.TypeReportGenerationFailed, req, "", model.StatusGenerationFailed, err)
+			return err
+		}
+		p.pushGenMetric(req.Typ)
+		p.publishEvent(ctx, events.TypeReportGenerated, req, report.GetID(), model.StatusGenerated, nil)
+		if err = p.saveStage(ctx, processed, report.GetID(), model.StageGenerated); err != nil {
 			return err
 		}
-		p.pushNotifMetric(req.Typ)
 	}
 
-	// Set report as finished.
-	return generateUC.Finish(ctx, report.GetID(), model.StatusFinished)
+	// Notify, unless the resumed report was already sent.
+	if req.AutoSend && report.GetStatus() != model.StatusSent {
+		if err = p.notify(ctx, generateUC, req, report, processed); err != nil {
+			return err
+		}
+		if err = p.saveStage(ctx, processed, report.GetID(), model.StageNotified); err != nil {
+			return err
+		}
+	}
+
+	return p.saveStage(ctx, processed, report.GetID(), model.StageFinished)
 }
 
-// pushGenMetric increments the number of generated reports for reportType.
-func (p *reportsProcessor) pushGenMetric(reportType model.ReportType) {
-	p.metricsClient.Push(metrics.Metric{
-		Name:  "vulcan.report.generated",
-		Typ:   metrics.Count,
-		Value: 1,
-		Tags:  []string{fmt.Sprint("reporttype:", reportType)},
-	})
+// resumeReport returns the report previously generated for the request
+// if it can be resumed from its last completed stage. If there's no
+// report to resume from, nil is returned, so it must be generated.
+func (p *reportsProcessor) resumeReport(ctx context.Context, generateUC GenerateUC, processed *model.ProcessedMessage) (model.Report, error) {
+	if processed == nil || processed.ReportID == "" ||
+		(processed.Stage != model.StageGenerated && processed.Stage != model.StageNotified) {
+		return nil, nil
+	}
+
+	report, err := generateUC.GetReport(ctx, processed.ReportID)
+	if err != nil {
+		if errors.Is(err, storage.ErrReportNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !model.IsGenerated(report.GetStatus()) {
+		return nil, nil
+	}
+
+	p.log.WithFields(log.Fields{
+		"type":     processed.ReportType,
+		"reportID": report.GetID(),
+		"status":   report.GetStatus(),
+		"key":      processed.Key,
+	}).Info("Resuming report processing")
+	return report, nil
+}
+
+// notify sends the report notification to the request
+// recipients, persisting every status transition.
+// The recipients already notified while processing the
+// request, according to processed, are not sent it again.
+// If there is an outbox, the notification is enqueued instead.
+func (p *reportsProcessor) notify(ctx context.Context, generateUC GenerateUC, req genRequest, report model.Report, processed *model.ProcessedMessage) error {
+	if p.outbox != nil {
+		return p.enqueueNotification(ctx, generateUC, req, report)
+	}
+
+	p.log.WithFields(log.Fields{
+		"teamID":   req.TeamInfo.ID,
+		"type":     req.Typ,
+		"reportID": report.GetID(),
+	}).Debug("Sending notification")
+
+	if err := generateUC.UpdateStatus(ctx, report.GetID(), model.StatusSending); err != nil {
+		return err
+	}
+
+	var notified []string
+	if processed != nil {
+		notified = processed.Notified
+	}
+	notif := notify.Notification{
+		ReportType: req.Typ,
+		TeamID:     req.TeamInfo.ID,
+		Subject:    report.GetNotification().Subject,
+		Body:       report.GetNotification().Body,
+		Fmt:        report.GetNotification().Fmt,
+		Recipients: pendingRecipients(req.TeamInfo.Recipients, notified),
+	}
+	var (
+		res notify.Result
+		err error
+	)
+	// Nothing is sent if every recipient was already notified.
+	if len(notified) == 0 || len(notif.Recipients) > 0 {
+		res, err = p.notifier.Notify(ctx, notif)
+		p.saveDeliveries(ctx, res.Deliveries(report.GetID(), notif, err))
+	}
+	deliveredTo := slices.Concat(notified, res.DeliveredTo(notif.Recipients))
+	if err != nil {
+		p.saveNotified(ctx, processed, deliveredTo)
+	}
+	if errors.Is(err, notify.ErrThrottled) {
+		// Make the queue consumers back off.
+		err = fmt.Errorf("%w: %w", queue.ErrThrottled, err)
+	}
+	if err != nil {
+		// The failure is recorded even if ctx is already done.
+		if updateErr := generateUC.UpdateStatus(context.WithoutCancel(ctx), report.GetID(), model.StatusSendFailed); updateErr != nil {
+			p.log.WithError(updateErr).WithFields(log.Fields{
+				"reportID": report.GetID(),
+			}).Error("Error updating report status")
+		}
+		p.publishEvent(ctx, events.TypeReportSendFailed, req, report.GetID(), model.StatusSendFailed, err)
+		if isPermanentSendErr(err) {
+			// Retrying would fail the same way, e.g.: every
+			// recipient is suppressed, so the request is finished.
+			p.log.WithError(err).WithFields(log.Fields{
+				"teamID":   req.TeamInfo.ID,
+				"type":     req.Typ,
+				"reportID": report.GetID(),
+			}).Warn("Error sending notification, giving up")
+			return nil
+		}
+		return err
+	}
+	p.pushNotifMetric(req.Typ)
+
+	if processed != nil {
+		processed.Notified = deliveredTo
+	}
+	if err = generateUC.MarkSentTx(ctx, nil, report.GetID(), deliveredTo); err != nil {
+		return err
+	}
+	p.publishEvent(ctx, events.TypeReportSent, req, report.GetID(), model.StatusSent, nil)
+	return nil
 }
 
-// pushNotifMetric increments the number of notified reports for reportType.
-func (p *reportsProcessor) pushNotifMetric(reportType model.ReportType) {
-	p.metricsClient.Push(metrics.Metric{
-		Name:  "vulcan.report.notified",
-		Typ:   metrics.Count,
-		Value: 1,
-		Tags:  []string{fmt.Sprint("reporttype:", reportType)},
+// enqueueNotification transitions the report to SENDING and writes its
+// notification to the outbox within the same transaction, so it is only
+// sent, by the Dispatcher, if the status change is committed.
+func (p *reportsProcessor) enqueueNotification(ctx context.Context, generateUC GenerateUC, req genRequest, report model.Report) error {
+	p.log.WithFields(log.Fields{
+		"teamID":   req.TeamInfo.ID,
+		"type":     req.Typ,
+		"reportID": report.GetID(),
+	}).Debug("Enqueuing notification")
+
+	notif := model.OutboxNotification{
+		ReportID:       report.GetID(),
+		ReportType:     req.Typ,
+		TeamID:         req.TeamInfo.ID,
+		IdempotencyKey: req.IdempotencyKey,
+		Notification:   report.GetNotification(),
+		Recipients:     req.TeamInfo.Recipients,
+	}
+	return generateUC.UpdateStatusTx(ctx, nil, report.GetID(), model.StatusSending, func(tx *sql.Tx) error {
+		return p.outbox.WithTx(tx).EnqueueNotification(ctx, notif)
 	})
 }
 
+// claimProcessedMessage returns the processing state for the request,
+// based on its idempotency key or, if not supplied, on the message ID,
+// once it is locked for the consumer. It returns false if the request
+// is locked by another consumer. If the request can not be identified,
+// nil is returned.
+func (p *reportsProcessor) claimProcessedMessage(ctx context.Context, req genRequest, mssg queue.Message) (*model.ProcessedMessage, bool, error) {
+	key := req.IdempotencyKey
+	if key == "" {
+		key = mssg.ID
+	}
+	if key == "" || p.processedMssgs == nil {
+		return nil, true, nil
+	}
+
+	processed := &model.ProcessedMessage{
+		Key:        key,
+		ReportType: req.Typ,
+		Stage:      model.StageReceived,
+		Payload:    mssg.Body,
+	}
+	claimed, err := p.processedMssgs.ClaimProcessedMessage(ctx, processed, p.claimLease)
+	if err != nil || !claimed {
+		return nil, false, err
+	}
+	return processed, true, nil
+}
+
+// releaseProcessedMessage unlocks the request, if any, so it can be
+// processed again, e.g.: when redelivered after a failure.
+func (p *reportsProcessor) releaseProcessedMessage(ctx context.Context, processed *model.ProcessedMessage) {
+	if processed == nil {
+		return
+	}
+	if err := p.processedMssgs.ReleaseProcessedMessage(context.WithoutCancel(ctx), processed.Key); err != nil {
+		p.log.WithError(err).WithFields(log.Fields{
+			"key": processed.Key,
+		}).Error("Error releasing report request")
+	}
+}
+
+// saveStage records the stage reached
+// while processing the request, if any.
+func (p *reportsProcessor) saveStage(ctx context.Context, processed *model.ProcessedMessage, reportID, stage string) error {
+	if processed == nil {
+		return nil
+	}
+	processed.ReportID = reportID
+	processed.Stage = stage
+	return p.processedMssgs.SaveProcessedMessage(ctx, processed)
+}
+
+// saveNotified records the recipients notified while processing the
+// request, after a failed send attempt, so the next attempt does not
+// send the notification to them again. The notified recipients are
+// recorded even if ctx is already done.
+func (p *reportsProcessor) saveNotified(ctx context.Context, processed *model.ProcessedMessage, notified []string) {
+	if processed == nil || len(notified) == len(processed.Notified) {
+		return
+	}
+	processed.Notified = notified
+	if err := p.processedMssgs.SaveProcessedMessage(context.WithoutCancel(ctx), processed); err != nil {
+		p.log.WithError(err).WithFields(log.Fields{
+			"reportID": processed.ReportID,
+			"key":      processed.Key,
+		}).Error("Error saving notified recipients")
+	}
+}
+
+// pendingRecipients returns the recipients which are not notified.
+func pendingRecipients(recipients, notified []string) []string {
+	var pending []string
+	for _, rcpt := range recipients {
+		if !slices.Contains(notified, rcpt) {
+			pending = append(pending, rcpt)
+		}
+	}
+	return pending
+}
+
+// publishEvent publishes a report lifecycle event for the request.
+func (p *reportsProcessor) publishEvent(ctx context.Context, typ string, req genRequest, reportID, status string, procErr error) {
+	data := events.ReportData{
+		ReportID:       reportID,
+		ReportType:     string(req.Typ),
+		TeamID:         req.TeamInfo.ID,
+		Status:         status,
+		IdempotencyKey: req.IdempotencyKey,
+	}
+	if status == model.StatusSent {
+		data.Recipients = req.TeamInfo.Recipients
+	}
+	if procErr != nil {
+		data.Error = procErr.Error()
+	}
+	p.publish(ctx, typ, data)
+}
+
+// unprocessable wraps err, returned for a request that can never
+// be processed, e.g.: because it is invalid, so it is not retried.
+func unprocessable(err error) error {
+	return fmt.Errorf("%w: %w", queue.ErrUnprocessable, err)
+}
+
 func parseGenRequest(reqData string) (genRequest, error) {
 	// Validate generic fields.
 	var req genRequest
@@ -149,3 +508,62 @@ func parseGenRequest(reqData string) (genRequest, error) {
 
 	return req, nil
 }
+
+// isAllowedInQueue returns true if the report type
+// can be requested through the specified queue.
+func (p *reportsProcessor) isAllowedInQueue(typ model.ReportType, queue string) bool {
+	types, ok := p.queueTypes[queue]
+	if !ok {
+		return true
+	}
+	for _, t := range types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// teamLocks holds a mutex per team, so requests of the
+// same team are not processed concurrently.
+// Mutexes are released once no request is waiting for them.
+type teamLocks struct {
+	mu    sync.Mutex
+	locks map[string]*teamLock
+}
+
+type teamLock struct {
+	sync.Mutex
+	refs int
+}
+
+func newTeamLocks() *teamLocks {
+	return &teamLocks{
+		locks: map[string]*teamLock{},
+	}
+}
+
+// lock blocks until the lock for the team is acquired
+// and returns the function to release it.
+func (l *teamLocks) lock(teamID string) func() {
+	l.mu.Lock()
+	tl, ok := l.locks[teamID]
+	if !ok {
+		tl = &teamLock{}
+		l.locks[teamID] = tl
+	}
+	tl.refs++
+	l.mu.Unlock()
+
+	tl.Lock()
+	return func() {
+		tl.Unlock()
+
+		l.mu.Lock()
+		tl.refs--
+		if tl.refs == 0 {
+			delete(l.locks, teamID)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -6,21 +6,35 @@ package report
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"fmt"
+	"io"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	metrics "github.com/adevinta/vulcan-metrics-client"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/adevinta/vulcan-reports-generator/pkg/events"
 	"github.com/adevinta/vulcan-reports-generator/pkg/model"
 	"github.com/adevinta/vulcan-reports-generator/pkg/notify"
+	"github.com/adevinta/vulcan-reports-generator/pkg/queue"
+	"github.com/adevinta/vulcan-reports-generator/pkg/storage"
 )
 
 var (
-	errMockGen    = errors.New("ErrGen")
-	errMockNotify = errors.New("ErrNotify")
-	errMockFinish = errors.New("ErrFinish")
+	errMockGen          = errors.New("ErrGen")
+	errMockNotify       = errors.New("ErrNotify")
+	errMockUpdateStatus = errors.New("ErrUpdateStatus")
+	errMockThrottled    = fmt.Errorf("%w: %v", notify.ErrThrottled, errMockNotify)
 
 	mockReport = &model.LiveReport{
 		BaseReport: model.BaseReport{
@@ -37,30 +51,119 @@ var (
 
 // Generator mock.
 type mockGenerateFunc func(ctx context.Context, teamInfo teamInfo, reportData interface{}) (model.Report, error)
-type mockFinishFunc func(ctx context.Context, reportID, status string) error
+type mockGetReportFunc func(ctx context.Context, reportID string) (model.Report, error)
+type mockUpdateStatusFunc func(ctx context.Context, reportID, status string) error
 type mockGenerateUC struct {
 	GenerateUC
-	mockGenerateFunc mockGenerateFunc
-	mockFinishFunc   mockFinishFunc
+	mockGenerateFunc     mockGenerateFunc
+	mockGetReportFunc    mockGetReportFunc
+	mockUpdateStatusFunc mockUpdateStatusFunc
+	deliveredTo          []string
 }
 
 func (g *mockGenerateUC) Generate(ctx context.Context, teamInfo teamInfo, reportData interface{}) (model.Report, error) {
 	return g.mockGenerateFunc(ctx, teamInfo, reportData)
 }
 
-func (g *mockGenerateUC) Finish(ctx context.Context, reportID, status string) error {
-	return g.mockFinishFunc(ctx, reportID, status)
+func (g *mockGenerateUC) GetReport(ctx context.Context, reportID string) (model.Report, error) {
+	return g.mockGetReportFunc(ctx, reportID)
+}
+
+func (g *mockGenerateUC) UpdateStatus(ctx context.Context, reportID, status string) error {
+	return g.mockUpdateStatusFunc(ctx, reportID, status)
+}
+
+func (g *mockGenerateUC) UpdateStatusTx(ctx context.Context, tx *sql.Tx, reportID, status string, fn func(tx *sql.Tx) error) error {
+	if err := g.mockUpdateStatusFunc(ctx, reportID, status); err != nil {
+		return err
+	}
+	if fn == nil {
+		return nil
+	}
+	return fn(tx)
+}
+
+func (g *mockGenerateUC) MarkSentTx(ctx context.Context, tx *sql.Tx, reportID string, deliveredTo []string) error {
+	g.deliveredTo = deliveredTo
+	return g.mockUpdateStatusFunc(ctx, reportID, model.StatusSent)
 }
 
 // Notifier mock.
 type mockNotifyFunc func(subject, mssg string, fmt model.NotifFmt, recipients []string) error
 type mockNotifier struct {
 	notify.Notifier
-	mockFunc mockNotifyFunc
+	mockFunc   mockNotifyFunc
+	providerID string
+	// delivered are the recipients notified even if mockFunc fails.
+	delivered []string
 }
 
-func (n *mockNotifier) Notify(subject, mssg string, fmt model.NotifFmt, recipients []string) error {
-	return n.mockFunc(subject, mssg, fmt, recipients)
+func (n *mockNotifier) Notify(ctx context.Context, notif notify.Notification) (notify.Result, error) {
+	res := notify.Result{ProviderIDs: map[string]string{}}
+	if err := n.mockFunc(notif.Subject, notif.Body, notif.Fmt, notif.Recipients); err != nil {
+		for _, r := range n.delivered {
+			res.ProviderIDs[r] = n.providerID
+		}
+		return res, err
+	}
+	for _, r := range notif.Recipients {
+		res.ProviderIDs[r] = n.providerID
+	}
+	return res, nil
+}
+
+// ProcessedMessagesRepository mock.
+type mockProcessedMssgsRepository struct {
+	storage.ProcessedMessagesRepository
+	mu     sync.Mutex
+	mssgs  map[string]model.ProcessedMessage
+	stages []string
+}
+
+func (r *mockProcessedMssgsRepository) GetStaleProcessedMessages(ctx context.Context, stage string, updatedBefore time.Time) ([]*model.ProcessedMessage, error) {
+	var mssgs []*model.ProcessedMessage
+	for _, mssg := range r.mssgs {
+		if mssg.Stage == stage && mssg.UpdatedAt.Before(updatedBefore) {
+			m := mssg
+			mssgs = append(mssgs, &m)
+		}
+	}
+	return mssgs, nil
+}
+
+func (r *mockProcessedMssgsRepository) SaveProcessedMessage(ctx context.Context, mssg *model.ProcessedMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	mssg.LockedUntil = r.mssgs[mssg.Key].LockedUntil
+	r.mssgs[mssg.Key] = *mssg
+	r.stages = append(r.stages, mssg.Stage)
+	return nil
+}
+
+func (r *mockProcessedMssgsRepository) ClaimProcessedMessage(ctx context.Context, mssg *model.ProcessedMessage, lease time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.mssgs[mssg.Key]
+	if ok && stored.LockedUntil.After(time.Now()) {
+		return false, nil
+	}
+	if !ok {
+		stored = *mssg
+		r.stages = append(r.stages, mssg.Stage)
+	}
+	stored.LockedUntil = time.Now().Add(lease)
+	r.mssgs[mssg.Key] = stored
+	*mssg = stored
+	return true, nil
+}
+
+func (r *mockProcessedMssgsRepository) ReleaseProcessedMessage(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	mssg := r.mssgs[key]
+	mssg.LockedUntil = time.Time{}
+	r.mssgs[key] = mssg
+	return nil
 }
 
 // MetricsClient mock.
@@ -141,6 +244,29 @@ func TestProcess(t *testing.T) {
 			expectedMetricCalls: 0,
 			expectedErr:         ErrUnsupportedReportType,
 		},
+		{
+			name: "Should return ErrInvalidRecipients",
+			fields: fields{
+				log: log,
+				generateUCC: map[model.ReportType]GenerateUC{
+					"scan": &mockGenerateUC{},
+				},
+				metricsClient: &mockMetricsClient{},
+			},
+			input: `
+			{
+				"team_info": {
+					"id": "1",
+					"name": "myTeam",
+					"recipients": ["Tom <tom@vulcan.example.com>"]
+				},
+				"data": {},
+				"type": "scan",
+				"auto_send": true
+			}`,
+			expectedMetricCalls: 0,
+			expectedErr:         notify.ErrInvalidRecipients,
+		},
 		{
 			name: "Happy path",
 			fields: fields{
@@ -151,14 +277,14 @@ func TestProcess(t *testing.T) {
 							// Return mock report.
 							return mockReport, nil
 						},
-						mockFinishFunc: func(ctx context.Context, reportID, status string) error {
+						mockUpdateStatusFunc: func(ctx context.Context, reportID, status string) error {
 							// Verify input reportID matches returned mock data from Generate.
 							if reportID != mockReport.ID {
 								return errors.New("reportID does not match returned mock report ID")
 							}
-							// Verify input status is FINISHED.
-							if status != model.StatusFinished {
-								return errors.New("status is not set to FINISHED")
+							// Verify input status is SENDING or SENT.
+							if status != model.StatusSending && status != model.StatusSent {
+								return errors.New("status is not set to SENDING or SENT")
 							}
 							return nil
 						},
@@ -207,16 +333,9 @@ func TestProcess(t *testing.T) {
 							// Return mock report.
 							return mockReport, nil
 						},
-						mockFinishFunc: func(ctx context.Context, reportID, status string) error {
-							// Verify input reportID matches returned mock data from Generate.
-							if reportID != mockReport.ID {
-								return errors.New("reportID does not match returned mock report ID")
-							}
-							// Verify input status is FINISHED.
-							if status != model.StatusFinished {
-								return errors.New("status is not set to FINISHED")
-							}
-							return nil
+						mockUpdateStatusFunc: func(ctx context.Context, reportID, status string) error {
+							// There should be no status update as report is not sent.
+							return errors.New("No call expected to update status, but got one")
 						},
 					},
 				},
@@ -284,14 +403,14 @@ func TestProcess(t *testing.T) {
 							// Return mock report.
 							return mockReport, nil
 						},
-						mockFinishFunc: func(ctx context.Context, reportID, status string) error {
+						mockUpdateStatusFunc: func(ctx context.Context, reportID, status string) error {
 							// Verify input reportID matches returned mock data from Generate.
 							if reportID != mockReport.ID {
 								return errors.New("reportID does not match returned mock report ID")
 							}
-							// Verify input status is failed.
-							if status != model.StatusFailed {
-								return errors.New("status is not set to FAILED after error")
+							// Verify input status is SENDING or SEND_FAILED after error.
+							if status != model.StatusSending && status != model.StatusSendFailed {
+								return errors.New("status is not set to SEND_FAILED after error")
 							}
 							return nil
 						},
@@ -323,7 +442,55 @@ func TestProcess(t *testing.T) {
 			expectedErr:         errMockNotify,
 		},
 		{
-			name: "Should return ErrMockFinish",
+			name: "Should return queue ErrThrottled due to notification throttling",
+			fields: fields{
+				log: log,
+				generateUCC: map[model.ReportType]GenerateUC{
+					"scan": &mockGenerateUC{
+						mockGenerateFunc: func(ctx context.Context, teamInfo teamInfo, reportData interface{}) (model.Report, error) {
+							// Return mock report.
+							return mockReport, nil
+						},
+						mockUpdateStatusFunc: func(ctx context.Context, reportID, status string) error {
+							// Verify input reportID matches returned mock data from Generate.
+							if reportID != mockReport.ID {
+								return errors.New("reportID does not match returned mock report ID")
+							}
+							// Verify input status is SENDING or SEND_FAILED after error.
+							if status != model.StatusSending && status != model.StatusSendFailed {
+								return errors.New("status is not set to SEND_FAILED after error")
+							}
+							return nil
+						},
+					},
+				},
+				notifier: &mockNotifier{
+					mockFunc: func(subject, mssg string, fmt model.NotifFmt, recipients []string) error {
+						// Return throttling Err.
+						return errMockThrottled
+					},
+				},
+				metricsClient: &mockMetricsClient{},
+			},
+			input: `
+			{
+				"team_info": {
+					"id": "1",
+					"name": "myTeam",
+					"recipients": []
+				},
+				"data": {
+					"scan_id": "1",
+					"program_name": "progName"
+				},
+				"type": "scan",
+				"auto_send": true
+			}`,
+			expectedMetricCalls: 1,
+			expectedErr:         queue.ErrThrottled,
+		},
+		{
+			name: "Should return ErrMockUpdateStatus",
 			fields: fields{
 				log: log,
 				generateUCC: map[model.ReportType]GenerateUC{
@@ -332,9 +499,9 @@ func TestProcess(t *testing.T) {
 							// All good.
 							return &model.LiveReport{}, nil
 						},
-						mockFinishFunc: func(ctx context.Context, reportID, status string) error {
+						mockUpdateStatusFunc: func(ctx context.Context, reportID, status string) error {
 							// Return Err.
-							return errMockFinish
+							return errMockUpdateStatus
 						},
 					},
 				},
@@ -360,19 +527,19 @@ func TestProcess(t *testing.T) {
 				"type": "scan",
 				"auto_send": true
 			}`,
-			expectedMetricCalls: 2,
-			expectedErr:         errMockFinish,
+			expectedMetricCalls: 1,
+			expectedErr:         errMockUpdateStatus,
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			processor, err := NewProcessor(tc.fields.log, tc.fields.generateUCC, tc.fields.notifier, tc.fields.metricsClient)
+			processor, err := NewProcessor(tc.fields.log, ProcessorConfig{}, tc.fields.generateUCC, tc.fields.notifier, tc.fields.metricsClient, nil, nil, nil, nil)
 			if err != nil {
 				t.Fatalf("Error building processor: %v", err)
 			}
 
-			err = processor.ProcessMessage(tc.input)
+			err = processor.ProcessMessage(context.Background(), queue.Message{Body: tc.input})
 			if !errors.Is(err, tc.expectedErr) {
 				t.Fatalf("Expected err: %v\nBut got: %v", tc.expectedErr, err)
 			}
@@ -383,3 +550,640 @@ func TestProcess(t *testing.T) {
 		})
 	}
 }
+
+func TestProcessDeduplication(t *testing.T) {
+	const input = `
+	{
+		"team_info": {
+			"id": "1",
+			"name": "myTeam",
+			"recipients": ["testteam@vulcan.example.com"]
+		},
+		"data": {},
+		"type": "scan",
+		"auto_send": true
+	}`
+
+	testCases := []struct {
+		name              string
+		processed         map[string]model.ProcessedMessage
+		report            model.Report
+		mssg              queue.Message
+		expectedGenCalls  int
+		expectedNotifCall int
+		expectedStages    []string
+		expectedLocked    bool
+	}{
+		{
+			name:              "Should record every stage for new message",
+			processed:         map[string]model.ProcessedMessage{},
+			mssg:              queue.Message{ID: "mssg-1", Body: input},
+			expectedGenCalls:  1,
+			expectedNotifCall: 1,
+			expectedStages:    []string{model.StageReceived, model.StageGenerated, model.StageNotified, model.StageFinished},
+		},
+		{
+			name: "Should skip already finished message",
+			processed: map[string]model.ProcessedMessage{
+				"mssg-1": {Key: "mssg-1", ReportID: "1", Stage: model.StageFinished},
+			},
+			mssg: queue.Message{ID: "mssg-1", Body: input},
+		},
+		{
+			name: "Should skip already sent notification",
+			processed: map[string]model.ProcessedMessage{
+				"mssg-1": {Key: "mssg-1", ReportID: "1", Stage: model.StageNotified},
+			},
+			report: &model.LiveReport{
+				BaseReport: model.BaseReport{ID: "1", Status: model.StatusSent},
+			},
+			mssg:           queue.Message{ID: "mssg-1", Body: input},
+			expectedStages: []string{model.StageFinished},
+		},
+		{
+			name: "Should resume sending already generated report",
+			processed: map[string]model.ProcessedMessage{
+				"mssg-1": {Key: "mssg-1", ReportID: "1", Stage: model.StageGenerated},
+			},
+			report: &model.LiveReport{
+				BaseReport: model.BaseReport{ID: "1", Status: model.StatusSendFailed},
+			},
+			mssg:              queue.Message{ID: "mssg-1", Body: input},
+			expectedNotifCall: 1,
+			expectedStages:    []string{model.StageNotified, model.StageFinished},
+		},
+		{
+			name: "Should generate again if report was not generated",
+			processed: map[string]model.ProcessedMessage{
+				"mssg-1": {Key: "mssg-1", ReportID: "1", Stage: model.StageGenerated},
+			},
+			report: &model.LiveReport{
+				BaseReport: model.BaseReport{ID: "1", Status: model.StatusGenerating},
+			},
+			mssg:              queue.Message{ID: "mssg-1", Body: input},
+			expectedGenCalls:  1,
+			expectedNotifCall: 1,
+			expectedStages:    []string{model.StageGenerated, model.StageNotified, model.StageFinished},
+		},
+		{
+			name: "Should skip message being processed by another consumer",
+			processed: map[string]model.ProcessedMessage{
+				"mssg-1": {Key: "mssg-1", ReportID: "1", Stage: model.StageGenerated, LockedUntil: time.Now().Add(time.Hour)},
+			},
+			mssg:           queue.Message{ID: "mssg-1", Body: input},
+			expectedLocked: true,
+		},
+		{
+			name: "Should resume message whose consumer lock expired",
+			processed: map[string]model.ProcessedMessage{
+				"mssg-1": {Key: "mssg-1", ReportID: "1", Stage: model.StageGenerated, LockedUntil: time.Now().Add(-time.Minute)},
+			},
+			report: &model.LiveReport{
+				BaseReport: model.BaseReport{ID: "1", Status: model.StatusGenerated},
+			},
+			mssg:              queue.Message{ID: "mssg-1", Body: input},
+			expectedNotifCall: 1,
+			expectedStages:    []string{model.StageNotified, model.StageFinished},
+		},
+		{
+			name: "Should use idempotency key instead of message ID",
+			processed: map[string]model.ProcessedMessage{
+				"key-1": {Key: "key-1", ReportID: "1", Stage: model.StageFinished},
+			},
+			mssg: queue.Message{ID: "mssg-2", Body: `
+			{
+				"team_info": {"id": "1", "name": "myTeam"},
+				"data": {},
+				"type": "scan",
+				"auto_send": true,
+				"idempotency_key": "key-1"
+			}`},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var genCalls, notifCalls int
+			generateUC := &mockGenerateUC{
+				mockGenerateFunc: func(ctx context.Context, teamInfo teamInfo, reportData interface{}) (model.Report, error) {
+					genCalls++
+					return mockReport, nil
+				},
+				mockGetReportFunc: func(ctx context.Context, reportID string) (model.Report, error) {
+					return tc.report, nil
+				},
+				mockUpdateStatusFunc: func(ctx context.Context, reportID, status string) error {
+					return nil
+				},
+			}
+			notifier := &mockNotifier{
+				mockFunc: func(subject, mssg string, fmt model.NotifFmt, recipients []string) error {
+					notifCalls++
+					return nil
+				},
+			}
+			repository := &mockProcessedMssgsRepository{mssgs: tc.processed}
+
+			processor, err := NewProcessor(log.New(), ProcessorConfig{}, map[model.ReportType]GenerateUC{"scan": generateUC},
+				notifier, &mockMetricsClient{}, nil, repository, nil, nil)
+			if err != nil {
+				t.Fatalf("Error building processor: %v", err)
+			}
+
+			if err = processor.ProcessMessage(context.Background(), tc.mssg); err != nil {
+				t.Fatalf("Expected no error, but got: %v", err)
+			}
+			if genCalls != tc.expectedGenCalls {
+				t.Fatalf("Expected generate calls to be: %d\nBut got: %d", tc.expectedGenCalls, genCalls)
+			}
+			if notifCalls != tc.expectedNotifCall {
+				t.Fatalf("Expected notify calls to be: %d\nBut got: %d", tc.expectedNotifCall, notifCalls)
+			}
+			if !reflect.DeepEqual(repository.stages, tc.expectedStages) {
+				t.Fatalf("Expected stages: %v\nBut got: %v", tc.expectedStages, repository.stages)
+			}
+			for key, mssg := range repository.mssgs {
+				if locked := mssg.LockedUntil.After(time.Now()); locked != tc.expectedLocked {
+					t.Fatalf("Expected message %s locked: %v\nBut got: %v", key, tc.expectedLocked, locked)
+				}
+			}
+		})
+	}
+}
+
+// Publisher mock.
+type mockPublisher struct {
+	events.Publisher
+	published []events.Event
+}
+
+func (p *mockPublisher) Publish(ctx context.Context, event events.Event) error {
+	p.published = append(p.published, event)
+	return nil
+}
+
+func TestProcessEvents(t *testing.T) {
+	const input = `{"team_info": {"id": "1", "name": "myTeam", "recipients": ["testteam@vulcan.example.com"]}, "data": {}, "type": "scan", "auto_send": true}`
+
+	testCases := []struct {
+		name           string
+		generateErr    error
+		notifyErr      error
+		expectedErr    error
+		expectedEvents []string
+	}{
+		{
+			name:           "Should publish generated and sent events",
+			expectedEvents: []string{events.TypeReportGenerated, events.TypeReportSent},
+		},
+		{
+			name:           "Should publish generation failed event",
+			generateErr:    errMockGen,
+			expectedErr:    errMockGen,
+			expectedEvents: []string{events.TypeReportGenerationFailed},
+		},
+		{
+			name:           "Should publish send failed event",
+			notifyErr:      errMockNotify,
+			expectedErr:    errMockNotify,
+			expectedEvents: []string{events.TypeReportGenerated, events.TypeReportSendFailed},
+		},
+		{
+			name:           "Should publish send failed event and not retry suppressed recipients",
+			notifyErr:      fmt.Errorf("%w: %v", notify.ErrRecipientsSuppressed, errMockNotify),
+			expectedEvents: []string{events.TypeReportGenerated, events.TypeReportSendFailed},
+		},
+		{
+			name:           "Should publish send failed event and not retry capped recipients",
+			notifyErr:      fmt.Errorf("%w: %v", notify.ErrDailyCapExceeded, errMockNotify),
+			expectedEvents: []string{events.TypeReportGenerated, events.TypeReportSendFailed},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			generateUC := &mockGenerateUC{
+				mockGenerateFunc: func(ctx context.Context, teamInfo teamInfo, reportData interface{}) (model.Report, error) {
+					if tc.generateErr != nil {
+						return nil, tc.generateErr
+					}
+					return mockReport, nil
+				},
+				mockUpdateStatusFunc: func(ctx context.Context, reportID, status string) error {
+					return nil
+				},
+			}
+			notifier := &mockNotifier{
+				mockFunc: func(subject, mssg string, fmt model.NotifFmt, recipients []string) error {
+					return tc.notifyErr
+				},
+			}
+			publisher := &mockPublisher{}
+			processor, err := NewProcessor(log.New(), ProcessorConfig{}, map[model.ReportType]GenerateUC{"scan": generateUC},
+				notifier, &mockMetricsClient{}, publisher, nil, nil, nil)
+			if err != nil {
+				t.Fatalf("Error building processor: %v", err)
+			}
+
+			err = processor.ProcessMessage(context.Background(), queue.Message{ID: "mssg-1", Body: input})
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected err: %v\nBut got: %v", tc.expectedErr, err)
+			}
+
+			var types []string
+			for _, e := range publisher.published {
+				types = append(types, e.Type)
+				data := e.Data.(events.ReportData)
+				if data.TeamID != "1" || data.ReportType != "scan" {
+					t.Fatalf("Unexpected event data: %+v", data)
+				}
+				if (e.Type == events.TypeReportGenerationFailed || e.Type == events.TypeReportSendFailed) && data.Error == "" {
+					t.Fatalf("Expected error in failure event: %+v", data)
+				}
+			}
+			if !reflect.DeepEqual(types, tc.expectedEvents) {
+				t.Fatalf("Expected events to be: %v\nBut got: %v", tc.expectedEvents, types)
+			}
+		})
+	}
+}
+
+// DeliveriesRepository mock.
+type mockDeliveriesRepository struct {
+	storage.DeliveriesRepository
+	deliveries []model.Delivery
+	updates    []string
+}
+
+func (r *mockDeliveriesRepository) SaveDeliveries(ctx context.Context, deliveries []model.Delivery) error {
+	r.deliveries = append(r.deliveries, deliveries...)
+	return nil
+}
+
+func TestProcessDeliveries(t *testing.T) {
+	const input = `{"team_info": {"id": "1", "name": "myTeam", "recipients": ["a@vulcan.example.com", "b@vulcan.example.com"]}, "data": {}, "type": "scan", "auto_send": true}`
+
+	testCases := []struct {
+		name           string
+		notifyErr      error
+		expectedErr    error
+		expectedStatus string
+		expectedID     string
+		expectedError  string
+		expectedSentTo []string
+	}{
+		{
+			name:           "Should record sent deliveries",
+			expectedStatus: model.DeliveryStatusSent,
+			expectedID:     "mssg-id",
+			expectedSentTo: []string{"a@vulcan.example.com", "b@vulcan.example.com"},
+		},
+		{
+			name:           "Should record failed deliveries",
+			notifyErr:      errMockNotify,
+			expectedErr:    errMockNotify,
+			expectedStatus: model.DeliveryStatusFailed,
+			expectedError:  errMockNotify.Error(),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			generateUC := &mockGenerateUC{
+				mockGenerateFunc: func(ctx context.Context, teamInfo teamInfo, reportData interface{}) (model.Report, error) {
+					return mockReport, nil
+				},
+				mockUpdateStatusFunc: func(ctx context.Context, reportID, status string) error {
+					return nil
+				},
+			}
+			notifier := &mockNotifier{
+				mockFunc: func(subject, mssg string, fmt model.NotifFmt, recipients []string) error {
+					return tc.notifyErr
+				},
+				providerID: "mssg-id",
+			}
+			repository := &mockDeliveriesRepository{}
+			processor, err := NewProcessor(log.New(), ProcessorConfig{}, map[model.ReportType]GenerateUC{"scan": generateUC},
+				notifier, &mockMetricsClient{}, nil, nil, repository, nil)
+			if err != nil {
+				t.Fatalf("Error building processor: %v", err)
+			}
+
+			err = processor.ProcessMessage(context.Background(), queue.Message{ID: "mssg-1", Body: input})
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected err: %v\nBut got: %v", tc.expectedErr, err)
+			}
+
+			var recipients []string
+			for _, d := range repository.deliveries {
+				recipients = append(recipients, d.Recipient)
+				if d.ReportID != mockReport.GetID() || d.Channel != model.DeliveryChannelEmail ||
+					d.Status != tc.expectedStatus || d.ProviderMessageID != tc.expectedID || d.Error != tc.expectedError {
+					t.Fatalf("Unexpected delivery: %+v", d)
+				}
+			}
+			expectedRecipients := []string{"a@vulcan.example.com", "b@vulcan.example.com"}
+			if !reflect.DeepEqual(recipients, expectedRecipients) {
+				t.Fatalf("Expected recipients to be: %v\nBut got: %v", expectedRecipients, recipients)
+			}
+			if !reflect.DeepEqual(generateUC.deliveredTo, tc.expectedSentTo) {
+				t.Fatalf("Expected report delivered to: %v\nBut got: %v", tc.expectedSentTo, generateUC.deliveredTo)
+			}
+		})
+	}
+}
+
+func TestProcessResendPending(t *testing.T) {
+	const input = `{"team_info": {"id": "1", "name": "myTeam", "recipients": ["a@vulcan.example.com", "b@vulcan.example.com"]}, "data": {}, "type": "scan", "auto_send": true}`
+
+	generateUC := &mockGenerateUC{
+		mockGenerateFunc: func(ctx context.Context, teamInfo teamInfo, reportData interface{}) (model.Report, error) {
+			return mockReport, nil
+		},
+		mockGetReportFunc: func(ctx context.Context, reportID string) (model.Report, error) {
+			return &model.LiveReport{
+				BaseReport: model.BaseReport{ID: reportID, Status: model.StatusSendFailed},
+			}, nil
+		},
+		mockUpdateStatusFunc: func(ctx context.Context, reportID, status string) error {
+			return nil
+		},
+	}
+	// The first attempt only notifies the first recipient.
+	var sentTo [][]string
+	notifier := &mockNotifier{
+		mockFunc: func(subject, mssg string, fmt model.NotifFmt, recipients []string) error {
+			sentTo = append(sentTo, recipients)
+			if len(sentTo) == 1 {
+				return errMockNotify
+			}
+			return nil
+		},
+		providerID: "mssg-id",
+		delivered:  []string{"a@vulcan.example.com"},
+	}
+	repository := &mockProcessedMssgsRepository{mssgs: map[string]model.ProcessedMessage{}}
+	processor, err := NewProcessor(log.New(), ProcessorConfig{}, map[model.ReportType]GenerateUC{"scan": generateUC},
+		notifier, &mockMetricsClient{}, nil, repository, nil, nil)
+	if err != nil {
+		t.Fatalf("Error building processor: %v", err)
+	}
+
+	// The same message is delivered again after the failure.
+	mssg := queue.Message{ID: "mssg-1", Body: input}
+	if err = processor.ProcessMessage(context.Background(), mssg); !errors.Is(err, errMockNotify) {
+		t.Fatalf("Expected err: %v\nBut got: %v", errMockNotify, err)
+	}
+	if err = processor.ProcessMessage(context.Background(), mssg); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+
+	expectedSentTo := [][]string{
+		{"a@vulcan.example.com", "b@vulcan.example.com"},
+		{"b@vulcan.example.com"},
+	}
+	if !reflect.DeepEqual(sentTo, expectedSentTo) {
+		t.Fatalf("Expected notifications sent to: %v\nBut got: %v", expectedSentTo, sentTo)
+	}
+	expectedDeliveredTo := []string{"a@vulcan.example.com", "b@vulcan.example.com"}
+	if !reflect.DeepEqual(generateUC.deliveredTo, expectedDeliveredTo) {
+		t.Fatalf("Expected report delivered to: %v\nBut got: %v", expectedDeliveredTo, generateUC.deliveredTo)
+	}
+}
+
+func TestProcessOutbox(t *testing.T) {
+	const input = `{"team_info": {"id": "1", "name": "myTeam", "recipients": ["a@vulcan.example.com"]}, "data": {}, "type": "scan", "auto_send": true, "idempotency_key": "key-1"}`
+
+	var statuses []string
+	generateUC := &mockGenerateUC{
+		mockGenerateFunc: func(ctx context.Context, teamInfo teamInfo, reportData interface{}) (model.Report, error) {
+			return mockReport, nil
+		},
+		mockUpdateStatusFunc: func(ctx context.Context, reportID, status string) error {
+			statuses = append(statuses, status)
+			return nil
+		},
+	}
+	notifier := &mockNotifier{
+		mockFunc: func(subject, mssg string, fmt model.NotifFmt, recipients []string) error {
+			t.Fatalf("Expected notification to be enqueued, but it was sent")
+			return nil
+		},
+	}
+	outbox := &mockOutboxRepository{}
+	processor, err := NewProcessor(log.New(), ProcessorConfig{}, map[model.ReportType]GenerateUC{"scan": generateUC},
+		notifier, &mockMetricsClient{}, nil, nil, nil, outbox)
+	if err != nil {
+		t.Fatalf("Error building processor: %v", err)
+	}
+
+	if err := processor.ProcessMessage(context.Background(), queue.Message{ID: "mssg-1", Body: input}); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+
+	expectedStatuses := []string{model.StatusSending}
+	if !reflect.DeepEqual(statuses, expectedStatuses) {
+		t.Fatalf("Expected statuses: %v\nBut got: %v", expectedStatuses, statuses)
+	}
+	expectedNotifs := []model.OutboxNotification{
+		{
+			ReportID:       mockReport.GetID(),
+			ReportType:     "scan",
+			TeamID:         "1",
+			IdempotencyKey: "key-1",
+			Notification:   mockReport.GetNotification(),
+			Recipients:     []string{"a@vulcan.example.com"},
+		},
+	}
+	if !reflect.DeepEqual(outbox.notifs, expectedNotifs) {
+		t.Fatalf("Expected outbox notifications: %+v\nBut got: %+v", expectedNotifs, outbox.notifs)
+	}
+}
+
+func TestProcessFileNotifier(t *testing.T) {
+	const input = `{"team_info": {"id": "1", "name": "myTeam", "recipients": ["a@vulcan.example.com", "b@vulcan.example.com"]}, "data": {}, "type": "scan", "auto_send": true}`
+
+	dir := t.TempDir()
+	notifier, err := notify.NewFileNotifier(notify.FileConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("Error building notifier: %v", err)
+	}
+	generateUC := &mockGenerateUC{
+		mockGenerateFunc: func(ctx context.Context, teamInfo teamInfo, reportData interface{}) (model.Report, error) {
+			return mockReport, nil
+		},
+		mockUpdateStatusFunc: func(ctx context.Context, reportID, status string) error {
+			return nil
+		},
+	}
+	repository := &mockDeliveriesRepository{}
+	processor, err := NewProcessor(log.New(), ProcessorConfig{}, map[model.ReportType]GenerateUC{"scan": generateUC},
+		notifier, &mockMetricsClient{}, nil, nil, repository, nil)
+	if err != nil {
+		t.Fatalf("Error building processor: %v", err)
+	}
+
+	if err := processor.ProcessMessage(context.Background(), queue.Message{ID: "mssg-1", Body: input}); err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected 1 email file, but got: %v %v", files, err)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatalf("Error opening email file: %v", err)
+	}
+	defer f.Close()
+	mssg, err := mail.ReadMessage(f)
+	if err != nil {
+		t.Fatalf("Error parsing email file: %v", err)
+	}
+	body, err := io.ReadAll(quotedprintable.NewReader(mssg.Body))
+	if err != nil {
+		t.Fatalf("Error reading email body: %v", err)
+	}
+
+	if to := mssg.Header.Get("To"); to != "a@vulcan.example.com, b@vulcan.example.com" {
+		t.Fatalf("Unexpected recipients: %s", to)
+	}
+	if subject := mssg.Header.Get("Subject"); subject != mockReport.Notification.Subject {
+		t.Fatalf("Expected subject: %s\nBut got: %s", mockReport.Notification.Subject, subject)
+	}
+	if strings.TrimSpace(string(body)) != mockReport.Notification.Body {
+		t.Fatalf("Expected body: %s\nBut got: %s", mockReport.Notification.Body, body)
+	}
+	id := strings.TrimSuffix(filepath.Base(files[0]), ".eml")
+	for _, d := range repository.deliveries {
+		if d.ProviderMessageID != id || d.Status != model.DeliveryStatusSent || d.TeamID != "1" {
+			t.Fatalf("Unexpected delivery: %+v", d)
+		}
+	}
+}
+
+func TestProcessQueueReportTypes(t *testing.T) {
+	const input = `{"team_info": {"id": "1", "name": "myTeam"}, "data": {}, "type": "scan"}`
+
+	cfg := ProcessorConfig{
+		QueueReportTypes: map[string][]model.ReportType{
+			"scans":   {"scan"},
+			"digests": {"digest"},
+		},
+	}
+
+	testCases := []struct {
+		name        string
+		queue       string
+		expectedErr error
+	}{
+		{
+			name:  "Should process allowed report type",
+			queue: "scans",
+		},
+		{
+			name:        "Should return err for not allowed report type",
+			queue:       "digests",
+			expectedErr: ErrReportTypeNotAllowed,
+		},
+		{
+			name:  "Should process any report type from unrestricted queue",
+			queue: "default",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			generateUC := &mockGenerateUC{
+				mockGenerateFunc: func(ctx context.Context, teamInfo teamInfo, reportData interface{}) (model.Report, error) {
+					return mockReport, nil
+				},
+			}
+			processor, err := NewProcessor(log.New(), cfg, map[model.ReportType]GenerateUC{"scan": generateUC},
+				&mockNotifier{}, &mockMetricsClient{}, nil, nil, nil, nil)
+			if err != nil {
+				t.Fatalf("Error building processor: %v", err)
+			}
+
+			err = processor.ProcessMessage(context.Background(), queue.Message{ID: "mssg-1", Body: input, Queue: tc.queue})
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected err: %v\nBut got: %v", tc.expectedErr, err)
+			}
+			// Requests of not allowed types are never retried.
+			if tc.expectedErr != nil && !errors.Is(err, queue.ErrUnprocessable) {
+				t.Fatalf("Expected err: %v\nBut got: %v", queue.ErrUnprocessable, err)
+			}
+		})
+	}
+}
+
+func TestProcessMemoryQueue(t *testing.T) {
+	var genCalls int
+	generateUC := &mockGenerateUC{
+		mockGenerateFunc: func(ctx context.Context, teamInfo teamInfo, reportData interface{}) (model.Report, error) {
+			genCalls++
+			return mockReport, nil
+		},
+	}
+	processor, err := NewProcessor(log.New(), ProcessorConfig{}, map[model.ReportType]GenerateUC{"scan": generateUC},
+		&mockNotifier{}, &mockMetricsClient{}, nil, &mockProcessedMssgsRepository{mssgs: map[string]model.ProcessedMessage{}}, nil, nil)
+	if err != nil {
+		t.Fatalf("Error building processor: %v", err)
+	}
+
+	consumer := queue.NewMemoryConsumer(1, 1, processor, log.New())
+	consumer.Errors = make(chan error)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	consumer.Start(ctx, &wg)
+
+	// The same message is delivered twice.
+	mssg := queue.Message{ID: "mssg-1", Body: `{"team_info": {"id": "1"}, "data": {}, "type": "scan"}`}
+	for i := 0; i < 2; i++ {
+		if err := consumer.Send(ctx, mssg); err != nil {
+			t.Fatalf("Expected no error, but got: %v", err)
+		}
+		if err := <-consumer.Errors; err != nil {
+			t.Fatalf("Expected no error, but got: %v", err)
+		}
+	}
+
+	if genCalls != 1 {
+		t.Fatalf("Expected generate calls to be: %d\nBut got: %d", 1, genCalls)
+	}
+}
+
+func TestTeamLocks(t *testing.T) {
+	locks := newTeamLocks()
+
+	unlock := locks.lock("team-1")
+	acquired := make(chan struct{})
+	released := make(chan struct{})
+	go func() {
+		unlock := locks.lock("team-1")
+		close(acquired)
+		unlock()
+		close(released)
+	}()
+
+	// Other teams are not blocked.
+	locks.lock("team-2")()
+
+	select {
+	case <-acquired:
+		t.Fatalf("Expected team lock to be held")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	<-acquired
+	<-released
+
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+	if len(locks.locks) != 0 {
+		t.Fatalf("Expected team locks to be released, but got: %v", locks.locks)
+	}
+}
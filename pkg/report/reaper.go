@@ -0,0 +1,275 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+	"github.com/adevinta/vulcan-reports-generator/pkg/queue"
+	"github.com/adevinta/vulcan-reports-generator/pkg/storage"
+)
+
+const (
+	// ReaperActionFail indicates that stale reports are marked as failed.
+	ReaperActionFail = "fail"
+	// ReaperActionRequeue indicates that the requests for stale reports
+	// are requeued, besides marking the stale reports as failed.
+	ReaperActionRequeue = "requeue"
+
+	// reaperLockKey identifies the advisory lock
+	// held by the reaper while reaping.
+	reaperLockKey int64 = 7415023730
+
+	defReaperInterval = 5 * time.Minute
+	defStaleAfter     = 2 * time.Hour
+	defMaxRequeues    = 3
+)
+
+var (
+	// ErrInvalidReaperConfig indicates that the reaper configuration is not valid.
+	ErrInvalidReaperConfig = errors.New("Invalid reaper configuration")
+)
+
+// ReaperConfig is the configuration for the Reaper.
+// Interval and StaleAfter are expressed in seconds.
+// StaleAfter defaults to 7200 and it must be greater than QueueTimeout,
+// the max visibility timeout of the queues in seconds, so the requests
+// the queue is about to redeliver are not requeued as well.
+type ReaperConfig struct {
+	Enabled      bool   `toml:"enabled"`
+	Interval     int64  `toml:"interval"`
+	StaleAfter   int64  `toml:"stale_after"`
+	Action       string `toml:"action"`
+	MaxRequeues  int    `toml:"max_requeues"`
+	QueueTimeout int64  `toml:"-"`
+}
+
+// Reaper periodically looks for reports stuck in GENERATING status,
+// which can be left behind when the service crashes while generating
+// them, and marks them as failed or requeues their requests.
+// Only one replica reaps at a time, guarded by a distributed lock.
+type Reaper struct {
+	log            *log.Logger
+	cfg            ReaperConfig
+	interval       time.Duration
+	staleAfter     time.Duration
+	generateUCC    map[model.ReportType]GenerateUC
+	repositories   map[model.ReportType]storage.ReportsRepository
+	processedMssgs storage.ProcessedMessagesRepository
+	producer       queue.Producer
+	locker         storage.Locker
+}
+
+// NewReaper builds a new Reaper. The producer is only
+// required if the configured action is requeue.
+func NewReaper(log *log.Logger, cfg ReaperConfig, generateUCC map[model.ReportType]GenerateUC,
+	repositories map[model.ReportType]storage.ReportsRepository, processedMssgs storage.ProcessedMessagesRepository,
+	producer queue.Producer, locker storage.Locker) (*Reaper, error) {
+	if cfg.Action == "" {
+		cfg.Action = ReaperActionFail
+	}
+	if cfg.Action != ReaperActionFail && cfg.Action != ReaperActionRequeue {
+		return nil, ErrInvalidReaperConfig
+	}
+	if cfg.Action == ReaperActionRequeue && producer == nil {
+		return nil, ErrInvalidReaperConfig
+	}
+	if cfg.MaxRequeues <= 0 {
+		cfg.MaxRequeues = defMaxRequeues
+	}
+
+	r := &Reaper{
+		log:            log,
+		cfg:            cfg,
+		interval:       defReaperInterval,
+		staleAfter:     defStaleAfter,
+		generateUCC:    generateUCC,
+		repositories:   repositories,
+		processedMssgs: processedMssgs,
+		producer:       producer,
+		locker:         locker,
+	}
+	if cfg.Interval > 0 {
+		r.interval = time.Duration(cfg.Interval) * time.Second
+	}
+	if cfg.StaleAfter > 0 {
+		r.staleAfter = time.Duration(cfg.StaleAfter) * time.Second
+	}
+	if queueTimeout := time.Duration(cfg.QueueTimeout) * time.Second; r.staleAfter <= queueTimeout {
+		return nil, fmt.Errorf("%w: stale after %v must be greater than the queue timeout %v",
+			ErrInvalidReaperConfig, r.staleAfter, queueTimeout)
+	}
+
+	return r, nil
+}
+
+// Start makes the reaper reap stale reports periodically until ctx is done.
+func (r *Reaper) Start(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				r.log.WithFields(log.Fields{
+					"err":   err,
+					"trace": string(debug.Stack()),
+				}).Error("Reaper stopping due to panic err")
+			}
+
+			wg.Done()
+		}()
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.Reap(ctx); err != nil {
+					r.log.WithError(err).Error("Error reaping stale reports")
+				}
+			}
+		}
+	}()
+}
+
+// Reap reaps the stale reports if no other replica is already doing it.
+func (r *Reaper) Reap(ctx context.Context) error {
+	acquired, err := r.locker.TryLock(ctx, reaperLockKey, r.reap)
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		r.log.Debug("Reaper lock held by another replica")
+	}
+	return nil
+}
+
+func (r *Reaper) reap(ctx context.Context) error {
+	staleBefore := time.Now().Add(-r.staleAfter)
+
+	if r.cfg.Action == ReaperActionRequeue {
+		if err := r.requeueStaleRequests(ctx, staleBefore); err != nil {
+			return err
+		}
+	}
+
+	for typ, repository := range r.repositories {
+		reports, err := repository.GetReportsByStatus(ctx, model.StatusGenerating, staleBefore)
+		if err != nil {
+			return err
+		}
+		generateUC, ok := r.generateUCC[typ]
+		if !ok {
+			continue
+		}
+		for _, report := range reports {
+			r.log.WithFields(log.Fields{
+				"type":     typ,
+				"reportID": report.GetID(),
+			}).Warn("Marking stale report as failed")
+			if err := generateUC.UpdateStatus(ctx, report.GetID(), model.StatusGenerationFailed); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// requeueStaleRequests sends again to the queue the requests whose
+// report generation never completed. Requeued requests keep their
+// key so they are still deduplicated.
+func (r *Reaper) requeueStaleRequests(ctx context.Context, staleBefore time.Time) error {
+	mssgs, err := r.processedMssgs.GetStaleProcessedMessages(ctx, model.StageReceived, staleBefore)
+	if err != nil {
+		return err
+	}
+
+	for _, mssg := range mssgs {
+		logger := r.log.WithFields(log.Fields{
+			"type":     mssg.ReportType,
+			"key":      mssg.Key,
+			"requeues": mssg.Requeues,
+		})
+
+		if mssg.Requeues >= r.cfg.MaxRequeues {
+			logger.Warn("Giving up stale request after max requeues")
+			mssg.Stage = model.StageFailed
+			if err := r.processedMssgs.SaveProcessedMessage(ctx, mssg); err != nil {
+				return err
+			}
+			continue
+		}
+
+		payload, req, err := withIdempotencyKey(mssg.Payload, mssg.Key)
+		if err != nil {
+			logger.WithError(err).Error("Invalid stale request payload")
+			mssg.Stage = model.StageFailed
+			if err := r.processedMssgs.SaveProcessedMessage(ctx, mssg); err != nil {
+				return err
+			}
+			continue
+		}
+
+		logger.Warn("Requeuing stale request")
+		// Requests of the same team are kept in the same
+		// message group for FIFO queues.
+		err = r.producer.Send(ctx, queue.Message{
+			ID:      fmt.Sprintf("%s-%d", mssg.Key, mssg.Requeues+1),
+			Body:    payload,
+			GroupID: req.TeamInfo.ID,
+		})
+		if err != nil {
+			return err
+		}
+		mssg.Requeues++
+		if err := r.processedMssgs.SaveProcessedMessage(ctx, mssg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// withIdempotencyKey sets the idempotency key of the request
+// payload if it was not already supplied. The parsed request
+// is also returned.
+func withIdempotencyKey(payload, key string) (string, genRequest, error) {
+	req, err := parseGenRequest(payload)
+	if err != nil {
+		return "", genRequest{}, err
+	}
+	if req.IdempotencyKey != "" {
+		return payload, req, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(payload), &fields); err != nil {
+		return "", genRequest{}, err
+	}
+	rawKey, err := json.Marshal(key)
+	if err != nil {
+		return "", genRequest{}, err
+	}
+	fields["idempotency_key"] = rawKey
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return "", genRequest{}, err
+	}
+	req.IdempotencyKey = key
+	return string(data), req, nil
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package report
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+	"github.com/adevinta/vulcan-reports-generator/pkg/queue"
+	"github.com/adevinta/vulcan-reports-generator/pkg/storage"
+)
+
+// Producer mock.
+type mockProducer struct {
+	sent []queue.Message
+}
+
+func (p *mockProducer) Send(ctx context.Context, mssg queue.Message) error {
+	p.sent = append(p.sent, mssg)
+	return nil
+}
+
+// Locker mock.
+type mockLocker struct {
+	locked bool
+}
+
+func (l *mockLocker) TryLock(ctx context.Context, key int64, fn func(ctx context.Context) error) (bool, error) {
+	if l.locked {
+		return false, nil
+	}
+	return true, fn(ctx)
+}
+
+const mockPayload = `{"type":"livereport","team_info":{"id":"1"}}`
+
+func TestReap(t *testing.T) {
+	staleTime := time.Now().Add(-2 * time.Hour)
+
+	testCases := []struct {
+		name             string
+		cfg              ReaperConfig
+		locked           bool
+		processed        map[string]model.ProcessedMessage
+		expectedStatuses []string
+		expectedSent     []queue.Message
+		expectedStages   []string
+	}{
+		{
+			name: "Should mark stale reports as failed",
+			cfg:  ReaperConfig{Action: ReaperActionFail},
+			processed: map[string]model.ProcessedMessage{
+				"mssg-1": {Key: "mssg-1", Stage: model.StageReceived, Payload: mockPayload, UpdatedAt: staleTime},
+			},
+			expectedStatuses: []string{model.StatusGenerationFailed},
+		},
+		{
+			name: "Should requeue stale requests keeping their key",
+			cfg:  ReaperConfig{Action: ReaperActionRequeue},
+			processed: map[string]model.ProcessedMessage{
+				"mssg-1": {Key: "mssg-1", Stage: model.StageReceived, Payload: mockPayload, UpdatedAt: staleTime},
+			},
+			expectedStatuses: []string{model.StatusGenerationFailed},
+			expectedSent: []queue.Message{
+				{
+					ID:      "mssg-1-1",
+					Body:    `{"idempotency_key":"mssg-1","team_info":{"id":"1"},"type":"livereport"}`,
+					GroupID: "1",
+				},
+			},
+			expectedStages: []string{model.StageReceived},
+		},
+		{
+			name: "Should give up stale requests after max requeues",
+			cfg:  ReaperConfig{Action: ReaperActionRequeue, MaxRequeues: 1},
+			processed: map[string]model.ProcessedMessage{
+				"mssg-1": {Key: "mssg-1", Stage: model.StageReceived, Payload: mockPayload, Requeues: 1, UpdatedAt: staleTime},
+			},
+			expectedStatuses: []string{model.StatusGenerationFailed},
+			expectedStages:   []string{model.StageFailed},
+		},
+		{
+			name:   "Should do nothing if lock is held by another replica",
+			cfg:    ReaperConfig{Action: ReaperActionRequeue},
+			locked: true,
+			processed: map[string]model.ProcessedMessage{
+				"mssg-1": {Key: "mssg-1", Stage: model.StageReceived, Payload: mockPayload, UpdatedAt: staleTime},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var statuses []string
+			generateUC := &mockGenerateUC{
+				mockUpdateStatusFunc: func(ctx context.Context, reportID, status string) error {
+					statuses = append(statuses, status)
+					return nil
+				},
+			}
+			repository := &mockReportsRepository{
+				mockGetByStatusFunc: func(ctx context.Context, status string, statusUpdatedBefore time.Time) ([]model.Report, error) {
+					if status != model.StatusGenerating {
+						return nil, nil
+					}
+					return []model.Report{&model.LiveReport{BaseReport: model.BaseReport{ID: "1"}}}, nil
+				},
+			}
+			processedMssgs := &mockProcessedMssgsRepository{mssgs: tc.processed}
+			producer := &mockProducer{}
+
+			reaper, err := NewReaper(log.New(), tc.cfg,
+				map[model.ReportType]GenerateUC{model.LiveReportType: generateUC},
+				map[model.ReportType]storage.ReportsRepository{model.LiveReportType: repository},
+				processedMssgs, producer, &mockLocker{locked: tc.locked})
+			if err != nil {
+				t.Fatalf("Error building reaper: %v", err)
+			}
+
+			if err := reaper.Reap(context.Background()); err != nil {
+				t.Fatalf("Expected no error, but got: %v", err)
+			}
+			if !reflect.DeepEqual(statuses, tc.expectedStatuses) {
+				t.Fatalf("Expected statuses: %v\nBut got: %v", tc.expectedStatuses, statuses)
+			}
+			if !reflect.DeepEqual(producer.sent, tc.expectedSent) {
+				t.Fatalf("Expected sent messages: %v\nBut got: %v", tc.expectedSent, producer.sent)
+			}
+			if !reflect.DeepEqual(processedMssgs.stages, tc.expectedStages) {
+				t.Fatalf("Expected stages: %v\nBut got: %v", tc.expectedStages, processedMssgs.stages)
+			}
+		})
+	}
+}
+
+func TestNewReaper(t *testing.T) {
+	testCases := []struct {
+		name        string
+		cfg         ReaperConfig
+		expectedErr error
+	}{
+		{
+			name: "Should build reaper",
+			cfg:  ReaperConfig{StaleAfter: 7200, QueueTimeout: 3600},
+		},
+		{
+			name:        "Should return ErrInvalidReaperConfig if stale after is not greater than the queue timeout",
+			cfg:         ReaperConfig{StaleAfter: 3600, QueueTimeout: 3600},
+			expectedErr: ErrInvalidReaperConfig,
+		},
+		{
+			name:        "Should return ErrInvalidReaperConfig if default stale after is not greater than the queue timeout",
+			cfg:         ReaperConfig{QueueTimeout: 7200},
+			expectedErr: ErrInvalidReaperConfig,
+		},
+		{
+			name:        "Should return ErrInvalidReaperConfig if there is no producer to requeue",
+			cfg:         ReaperConfig{Action: ReaperActionRequeue},
+			expectedErr: ErrInvalidReaperConfig,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewReaper(log.New(), tc.cfg, nil, nil, nil, nil, &mockLocker{})
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected err: %v\nBut got: %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestWithIdempotencyKey(t *testing.T) {
+	payload := `{"type":"livereport","team_info":{"id":"1"},"idempotency_key":"key-1"}`
+	requeued, req, err := withIdempotencyKey(payload, "mssg-1")
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if requeued != payload || req.IdempotencyKey != "key-1" {
+		t.Fatalf("Expected supplied idempotency key to be kept, but got: %s", req.IdempotencyKey)
+	}
+}
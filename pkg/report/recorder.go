@@ -0,0 +1,78 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package report
+
+import (
+	"context"
+	"fmt"
+
+	metrics "github.com/adevinta/vulcan-metrics-client"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/events"
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+	"github.com/adevinta/vulcan-reports-generator/pkg/storage"
+)
+
+// recorder records the outcomes of processing and sending the reports:
+// the deliveries, the lifecycle events and the metrics. They are recorded
+// on a best-effort basis, so errors are logged but do not fail the caller.
+// It is shared by the processor and the Dispatcher.
+type recorder struct {
+	log           *log.Logger
+	metricsClient metrics.Client
+	publisher     events.Publisher
+	deliveries    storage.DeliveriesRepository
+}
+
+// saveDeliveries records the deliveries of a send
+// attempt, if a repository is configured.
+func (r *recorder) saveDeliveries(ctx context.Context, deliveries []model.Delivery) {
+	if r.deliveries == nil || len(deliveries) == 0 {
+		return
+	}
+	if err := r.deliveries.SaveDeliveries(context.WithoutCancel(ctx), deliveries); err != nil {
+		r.log.WithError(err).WithFields(log.Fields{
+			"reportID": deliveries[0].ReportID,
+		}).Error("Error saving report deliveries")
+	}
+}
+
+// publish publishes a report lifecycle event, if a publisher is configured.
+// Failures are published even if ctx is already done.
+func (r *recorder) publish(ctx context.Context, typ string, data events.ReportData) {
+	if r.publisher == nil {
+		return
+	}
+
+	event := events.NewEvent(typ, data.ReportID, data)
+	if err := r.publisher.Publish(context.WithoutCancel(ctx), event); err != nil {
+		r.log.WithError(err).WithFields(log.Fields{
+			"eventID":  event.ID,
+			"event":    typ,
+			"reportID": data.ReportID,
+			"teamID":   data.TeamID,
+		}).Error("Error publishing report event")
+	}
+}
+
+// pushGenMetric increments the number of generated reports for reportType.
+func (r *recorder) pushGenMetric(reportType model.ReportType) {
+	r.pushMetric("vulcan.report.generated", reportType)
+}
+
+// pushNotifMetric increments the number of notified reports for reportType.
+func (r *recorder) pushNotifMetric(reportType model.ReportType) {
+	r.pushMetric("vulcan.report.notified", reportType)
+}
+
+func (r *recorder) pushMetric(name string, reportType model.ReportType) {
+	r.metricsClient.Push(metrics.Metric{
+		Name:  name,
+		Typ:   metrics.Count,
+		Value: 1,
+		Tags:  []string{fmt.Sprint("reporttype:", reportType)},
+	})
+}
@@ -0,0 +1,311 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package report
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+	"github.com/adevinta/vulcan-reports-generator/pkg/queue"
+	"github.com/adevinta/vulcan-reports-generator/pkg/storage"
+)
+
+const (
+	replayQueueName = "replay"
+
+	// maxReplayLineSize is the max size of the
+	// requests read from a replay file.
+	maxReplayLineSize = 10 * 1024 * 1024
+)
+
+// ReplaySource represents a source of report generation requests to replay.
+type ReplaySource interface {
+	// Read calls fn for every request of the source.
+	// fn returns true if the request was replayed.
+	Read(ctx context.Context, fn func(ctx context.Context, mssg queue.Message) (bool, error)) error
+}
+
+// ReplayConfig is the configuration for the Replayer.
+//
+//   - TeamID and Type filter the requests to replay, if set.
+//   - From and To filter the requests by the time they were
+//     originally sent, if set. Requests whose original time
+//     is unknown are not filtered by time.
+//   - DryRun makes the replayer only log the requests to replay.
+//   - Rate limits the requests replayed per second, if set.
+type ReplayConfig struct {
+	TeamID string
+	Type   model.ReportType
+	From   time.Time
+	To     time.Time
+	DryRun bool
+	Rate   float64
+}
+
+// ReplayResult summarizes a replay.
+type ReplayResult struct {
+	Read     int
+	Matched  int
+	Replayed int
+	Failed   int
+}
+
+// Replayer feeds report generation requests from a
+// ReplaySource through the reports processor.
+type Replayer struct {
+	log       *log.Logger
+	cfg       ReplayConfig
+	processor queue.Processor
+}
+
+// NewReplayer builds a new Replayer.
+func NewReplayer(log *log.Logger, cfg ReplayConfig, processor queue.Processor) *Replayer {
+	return &Replayer{
+		log:       log,
+		cfg:       cfg,
+		processor: processor,
+	}
+}
+
+// Replay replays the requests from the source that match the
+// configured filters. Requests whose processing fails are
+// logged and counted, but do not stop the replay.
+func (r *Replayer) Replay(ctx context.Context, source ReplaySource) (ReplayResult, error) {
+	var result ReplayResult
+
+	var tick <-chan time.Time
+	if r.cfg.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / r.cfg.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	replay := func(ctx context.Context, req genRequest, mssg queue.Message) (bool, error) {
+		if !r.matches(req, mssg) {
+			return false, nil
+		}
+		result.Matched++
+
+		logger := r.log.WithFields(log.Fields{
+			"teamID": req.TeamInfo.ID,
+			"type":   req.Typ,
+			"mssgID": mssg.ID,
+			"sentAt": mssg.SentAt,
+		})
+		if r.cfg.DryRun {
+			logger.Info("Request would be replayed")
+			return false, nil
+		}
+
+		if tick != nil {
+			select {
+			case <-ctx.Done():
+				return false, ctx.Err()
+			case <-tick:
+			}
+		}
+
+		if mssg.Queue == "" {
+			mssg.Queue = replayQueueName
+		}
+		if err := r.processor.ProcessMessage(ctx, mssg); err != nil {
+			logger.WithError(err).Error("Error replaying request")
+			result.Failed++
+			return false, nil
+		}
+		logger.Info("Request replayed")
+		result.Replayed++
+		return true, nil
+	}
+
+	err := source.Read(ctx, func(ctx context.Context, mssg queue.Message) (bool, error) {
+		result.Read++
+
+		batch, err := parseBatchGenRequest(mssg.Body)
+		if err != nil {
+			r.log.WithError(err).WithField("mssgID", mssg.ID).Error("Invalid replay request")
+			return false, nil
+		}
+		if batch != nil {
+			return r.replayBatch(ctx, *batch, mssg, replay)
+		}
+
+		req, err := parseGenRequest(mssg.Body)
+		if err != nil {
+			r.log.WithError(err).WithField("mssgID", mssg.ID).Error("Invalid replay request")
+			return false, nil
+		}
+		return replay(ctx, req, mssg)
+	})
+
+	return result, err
+}
+
+// replayBatch fans out the batch request into the request of each team,
+// as processed originally, so they are filtered and replayed on their own
+// and the teams already processed are deduplicated. It only returns true
+// if every team request was replayed, so the batch is kept in the source,
+// e.g.: the DLQ, while any team is left to replay.
+func (r *Replayer) replayBatch(ctx context.Context, batch batchGenRequest, mssg queue.Message,
+	replay func(ctx context.Context, req genRequest, mssg queue.Message) (bool, error)) (bool, error) {
+	logger := r.log.WithField("mssgID", mssg.ID)
+	batchID, err := batch.id(mssg)
+	if err != nil {
+		logger.WithError(err).Error("Invalid replay request")
+		return false, nil
+	}
+
+	replayed := true
+	for _, team := range batch.Batch.Teams {
+		teamReq, teamMssg, err := batch.teamRequest(team, batchID, mssg)
+		if err != nil {
+			logger.WithError(err).WithField("teamID", team.TeamInfo.ID).Error("Invalid replay request")
+			replayed = false
+			continue
+		}
+		ok, err := replay(ctx, teamReq, teamMssg)
+		if err != nil {
+			return false, err
+		}
+		replayed = replayed && ok
+	}
+	return replayed, nil
+}
+
+func (r *Replayer) matches(req genRequest, mssg queue.Message) bool {
+	if r.cfg.TeamID != "" && req.TeamInfo.ID != r.cfg.TeamID {
+		return false
+	}
+	if r.cfg.Type != "" && req.Typ != r.cfg.Type {
+		return false
+	}
+	if mssg.SentAt.IsZero() {
+		return true
+	}
+	if !r.cfg.From.IsZero() && mssg.SentAt.Before(r.cfg.From) {
+		return false
+	}
+	if !r.cfg.To.IsZero() && mssg.SentAt.After(r.cfg.To) {
+		return false
+	}
+	return true
+}
+
+// FileReplaySource reads requests from JSONL
+// content, one request per line.
+type FileReplaySource struct {
+	r io.Reader
+}
+
+// NewFileReplaySource builds a new FileReplaySource.
+func NewFileReplaySource(r io.Reader) *FileReplaySource {
+	return &FileReplaySource{
+		r: r,
+	}
+}
+
+// Read calls fn for every request. Requests are identified by
+// the hash of their content, so replaying the same request again
+// is deduplicated unless it supplies its own idempotency key.
+func (s *FileReplaySource) Read(ctx context.Context, fn func(ctx context.Context, mssg queue.Message) (bool, error)) error {
+	scanner := bufio.NewScanner(s.r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxReplayLineSize)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		hash := sha256.Sum256([]byte(line))
+		mssg := queue.Message{
+			ID:           "replay-" + hex.EncodeToString(hash[:]),
+			Body:         line,
+			Attributes:   map[string]string{},
+			ReceiveCount: 1,
+		}
+		if _, err := fn(ctx, mssg); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// unfinishedStages are the stages of the requests whose processing
+// was left halfway, e.g.: due to an SES or DB outage, and which are
+// not requeued by the reaper.
+var unfinishedStages = []string{model.StageReceived, model.StageGenerated, model.StageNotified}
+
+// FailedReplaySource reads the requests whose processing was given
+// up, e.g.: by the reaper, or left unfinished for too long.
+type FailedReplaySource struct {
+	processedMssgs storage.ProcessedMessagesRepository
+	before         time.Time
+	staleAfter     time.Duration
+}
+
+// NewFailedReplaySource builds a new FailedReplaySource which reads the
+// requests that failed before the specified time, and the unfinished
+// ones not updated for staleAfter, which defaults to one hour, so the
+// requests being processed are not replayed.
+func NewFailedReplaySource(processedMssgs storage.ProcessedMessagesRepository, before time.Time, staleAfter time.Duration) *FailedReplaySource {
+	if staleAfter <= 0 {
+		staleAfter = defStaleAfter
+	}
+	return &FailedReplaySource{
+		processedMssgs: processedMssgs,
+		before:         before,
+		staleAfter:     staleAfter,
+	}
+}
+
+// Read calls fn for every failed or stale unfinished request. Requests
+// keep their key, so their processing state is resumed when replayed.
+func (s *FailedReplaySource) Read(ctx context.Context, fn func(ctx context.Context, mssg queue.Message) (bool, error)) error {
+	failed, err := s.processedMssgs.GetStaleProcessedMessages(ctx, model.StageFailed, s.before)
+	if err != nil {
+		return err
+	}
+
+	staleBefore := time.Now().Add(-s.staleAfter)
+	if s.before.Before(staleBefore) {
+		staleBefore = s.before
+	}
+	for _, stage := range unfinishedStages {
+		unfinished, err := s.processedMssgs.GetStaleProcessedMessages(ctx, stage, staleBefore)
+		if err != nil {
+			return err
+		}
+		failed = append(failed, unfinished...)
+	}
+
+	for _, processed := range failed {
+		payload, _, err := withIdempotencyKey(processed.Payload, processed.Key)
+		if err != nil {
+			// Let the replayer report the invalid request.
+			payload = processed.Payload
+		}
+		mssg := queue.Message{
+			ID:           processed.Key,
+			Body:         payload,
+			Attributes:   map[string]string{},
+			ReceiveCount: processed.Requeues + 1,
+			SentAt:       processed.CreatedAt,
+		}
+		if _, err := fn(ctx, mssg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package report
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+	"github.com/adevinta/vulcan-reports-generator/pkg/queue"
+)
+
+type mockReplayProcessor struct {
+	failTeam string
+	mssgs    []queue.Message
+}
+
+func (p *mockReplayProcessor) ProcessMessage(ctx context.Context, mssg queue.Message) error {
+	req, err := parseGenRequest(mssg.Body)
+	if err != nil {
+		return err
+	}
+	if req.TeamInfo.ID == p.failTeam {
+		return errors.New("mockErr")
+	}
+	p.mssgs = append(p.mssgs, mssg)
+	return nil
+}
+
+const mockReplayFile = `
+{"type": "livereport", "team_info": {"id": "1"}}
+{"type": "livereport", "team_info": {"id": "2"}}
+{"type": "scan", "team_info": {"id": "1"}}
+not a request
+{"type": "scan", "batch": {"id": "weekly", "teams": [{"team_info": {"id": "2"}}, {"team_info": {"id": "3"}}]}}
+`
+
+func TestReplayFile(t *testing.T) {
+	testCases := []struct {
+		name             string
+		cfg              ReplayConfig
+		failTeam         string
+		expectedResult   ReplayResult
+		expectedReplayed int
+	}{
+		{
+			name:             "Should replay every request",
+			expectedResult:   ReplayResult{Read: 5, Matched: 5, Replayed: 5},
+			expectedReplayed: 5,
+		},
+		{
+			name:             "Should filter by team and type",
+			cfg:              ReplayConfig{TeamID: "1", Type: "livereport"},
+			expectedResult:   ReplayResult{Read: 5, Matched: 1, Replayed: 1},
+			expectedReplayed: 1,
+		},
+		{
+			name:             "Should filter the teams of batch requests",
+			cfg:              ReplayConfig{TeamID: "3"},
+			expectedResult:   ReplayResult{Read: 5, Matched: 1, Replayed: 1},
+			expectedReplayed: 1,
+		},
+		{
+			name:           "Should not replay on dry run",
+			cfg:            ReplayConfig{DryRun: true},
+			expectedResult: ReplayResult{Read: 5, Matched: 5},
+		},
+		{
+			name:             "Should count failed requests",
+			failTeam:         "2",
+			expectedResult:   ReplayResult{Read: 5, Matched: 5, Replayed: 3, Failed: 2},
+			expectedReplayed: 3,
+		},
+		{
+			name:             "Should replay rate limited",
+			cfg:              ReplayConfig{Rate: 1000},
+			expectedResult:   ReplayResult{Read: 5, Matched: 5, Replayed: 5},
+			expectedReplayed: 5,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			processor := &mockReplayProcessor{failTeam: tc.failTeam}
+			replayer := NewReplayer(log.New(), tc.cfg, processor)
+
+			result, err := replayer.Replay(context.Background(), NewFileReplaySource(strings.NewReader(mockReplayFile)))
+			if err != nil {
+				t.Fatalf("Expected no error, but got: %v", err)
+			}
+			if result != tc.expectedResult {
+				t.Fatalf("Expected result: %+v\nBut got: %+v", tc.expectedResult, result)
+			}
+			if len(processor.mssgs) != tc.expectedReplayed {
+				t.Fatalf("Expected replayed requests to be: %d\nBut got: %d", tc.expectedReplayed, len(processor.mssgs))
+			}
+			for _, mssg := range processor.mssgs {
+				if !strings.HasPrefix(mssg.ID, "replay-") && !strings.HasPrefix(mssg.ID, "weekly-") ||
+					mssg.Queue != replayQueueName {
+					t.Fatalf("Unexpected replayed message: %+v", mssg)
+				}
+			}
+		})
+	}
+}
+
+func TestReplayFailed(t *testing.T) {
+	now := time.Now()
+	repository := &mockProcessedMssgsRepository{
+		mssgs: map[string]model.ProcessedMessage{
+			"old": {
+				Key:       "old",
+				Stage:     model.StageFailed,
+				Payload:   `{"type":"livereport","team_info":{"id":"1"}}`,
+				CreatedAt: now.Add(-48 * time.Hour),
+				UpdatedAt: now.Add(-48 * time.Hour),
+			},
+			"recent": {
+				Key:       "recent",
+				Stage:     model.StageFailed,
+				Payload:   `{"type":"livereport","team_info":{"id":"1"}}`,
+				CreatedAt: now.Add(-time.Hour),
+				UpdatedAt: now.Add(-time.Hour),
+			},
+			"stuck": {
+				Key:       "stuck",
+				Stage:     model.StageGenerated,
+				Payload:   `{"type":"livereport","team_info":{"id":"1"}}`,
+				CreatedAt: now.Add(-2 * time.Hour),
+				UpdatedAt: now.Add(-2 * time.Hour),
+			},
+			"processing": {
+				Key:       "processing",
+				Stage:     model.StageReceived,
+				Payload:   `{"type":"livereport","team_info":{"id":"1"}}`,
+				CreatedAt: now.Add(-time.Minute),
+				UpdatedAt: now.Add(-time.Minute),
+			},
+			"finished": {
+				Key:       "finished",
+				Stage:     model.StageFinished,
+				Payload:   `{"type":"livereport","team_info":{"id":"1"}}`,
+				CreatedAt: now.Add(-time.Hour),
+				UpdatedAt: now.Add(-time.Hour),
+			},
+		},
+	}
+	processor := &mockReplayProcessor{}
+	replayer := NewReplayer(log.New(), ReplayConfig{From: now.Add(-24 * time.Hour)}, processor)
+
+	result, err := replayer.Replay(context.Background(), NewFailedReplaySource(repository, now, time.Hour))
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	expectedResult := ReplayResult{Read: 3, Matched: 2, Replayed: 2}
+	if result != expectedResult {
+		t.Fatalf("Expected result: %+v\nBut got: %+v", expectedResult, result)
+	}
+
+	var ids []string
+	for _, mssg := range processor.mssgs {
+		ids = append(ids, mssg.ID)
+		req, _ := parseGenRequest(mssg.Body)
+		if req.IdempotencyKey != mssg.ID {
+			t.Fatalf("Expected idempotency key to be the message key, but got: %s", req.IdempotencyKey)
+		}
+	}
+	if !reflect.DeepEqual(ids, []string{"recent", "stuck"}) {
+		t.Fatalf("Expected replayed messages: %v\nBut got: %v", []string{"recent", "stuck"}, ids)
+	}
+}
+
+// ReplaySource mock.
+type mockReplaySource struct {
+	mssgs   []queue.Message
+	removed []bool
+}
+
+func (s *mockReplaySource) Read(ctx context.Context, fn func(ctx context.Context, mssg queue.Message) (bool, error)) error {
+	for _, mssg := range s.mssgs {
+		remove, err := fn(ctx, mssg)
+		if err != nil {
+			return err
+		}
+		s.removed = append(s.removed, remove)
+	}
+	return nil
+}
+
+func TestReplayBatch(t *testing.T) {
+	const batch = `{"type": "scan", "batch": {"teams": [{"team_info": {"id": "1"}}, {"team_info": {"id": "2"}}]}}`
+
+	testCases := []struct {
+		name            string
+		cfg             ReplayConfig
+		failTeam        string
+		expectedIDs     []string
+		expectedRemoved bool
+	}{
+		{
+			name:            "Should replay every team and remove the batch",
+			expectedIDs:     []string{"mssg-1-1", "mssg-1-2"},
+			expectedRemoved: true,
+		},
+		{
+			name:        "Should keep the batch if some team is filtered out",
+			cfg:         ReplayConfig{TeamID: "2"},
+			expectedIDs: []string{"mssg-1-2"},
+		},
+		{
+			name:        "Should keep the batch if some team fails",
+			failTeam:    "1",
+			expectedIDs: []string{"mssg-1-2"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			processor := &mockReplayProcessor{failTeam: tc.failTeam}
+			source := &mockReplaySource{mssgs: []queue.Message{{ID: "mssg-1", Body: batch}}}
+
+			if _, err := NewReplayer(log.New(), tc.cfg, processor).Replay(context.Background(), source); err != nil {
+				t.Fatalf("Expected no error, but got: %v", err)
+			}
+
+			var ids []string
+			for _, mssg := range processor.mssgs {
+				ids = append(ids, mssg.ID)
+			}
+			if !reflect.DeepEqual(ids, tc.expectedIDs) {
+				t.Fatalf("Expected replayed requests: %v\nBut got: %v", tc.expectedIDs, ids)
+			}
+			if !reflect.DeepEqual(source.removed, []bool{tc.expectedRemoved}) {
+				t.Fatalf("Expected batch removed: %v\nBut got: %v", tc.expectedRemoved, source.removed)
+			}
+		})
+	}
+}
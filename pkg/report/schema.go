@@ -0,0 +1,142 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package report
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+)
+
+const (
+	// defRequestVersion is the version of the requests
+	// that do not specify it, sent by older producers.
+	defRequestVersion = 1
+
+	schemasDir     = "schemas"
+	schemasBaseURL = "embed:///"
+)
+
+var (
+	// ErrUnsupportedVersion indicates that the requested version is not supported for the report type.
+	ErrUnsupportedVersion = errors.New("The requested version is not supported for the report type")
+
+	// schemasFS holds the JSON Schemas of the request data for each
+	// report type and version, stored as "schemas/{type}/v{version}.json".
+	//
+	//go:embed schemas
+	schemasFS embed.FS
+)
+
+// requestSchemas holds the compiled JSON Schemas
+// of the request data by report type and version.
+type requestSchemas map[model.ReportType]map[int]*jsonschema.Schema
+
+// loadRequestSchemas compiles the JSON Schemas bundled in the binary.
+func loadRequestSchemas() (requestSchemas, error) {
+	compiler := jsonschema.NewCompiler()
+	compiler.AssertFormat = true
+
+	schemas := requestSchemas{}
+	err := fs.WalkDir(schemasFS, schemasDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		typ := model.ReportType(path.Base(path.Dir(p)))
+		version, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path.Base(p), "v"), ".json"))
+		if err != nil {
+			return fmt.Errorf("invalid schema file name %s: %w", p, err)
+		}
+
+		f, err := schemasFS.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		url := schemasBaseURL + p
+		if err := compiler.AddResource(url, f); err != nil {
+			return err
+		}
+		schema, err := compiler.Compile(url)
+		if err != nil {
+			return err
+		}
+
+		if schemas[typ] == nil {
+			schemas[typ] = map[int]*jsonschema.Schema{}
+		}
+		schemas[typ][version] = schema
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return schemas, nil
+}
+
+// schema returns the schema for the report type and version, or nil
+// if there are no schemas for the report type, so it is not validated.
+func (s requestSchemas) schema(typ model.ReportType, version int) (*jsonschema.Schema, error) {
+	versions, ok := s[typ]
+	if !ok {
+		return nil, nil
+	}
+	if version == 0 {
+		version = defRequestVersion
+	}
+	schema, ok := versions[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s v%d", ErrUnsupportedVersion, typ, version)
+	}
+	return schema, nil
+}
+
+// validate validates the request data against the
+// schema for the report type and version.
+func (s requestSchemas) validate(typ model.ReportType, version int, data interface{}) error {
+	schema, err := s.schema(typ, version)
+	if err != nil || schema == nil {
+		return err
+	}
+
+	err = schema.Validate(data)
+	var verr *jsonschema.ValidationError
+	if errors.As(err, &verr) {
+		return fmt.Errorf("%w: %s", ErrInvalidRequest, validationMessage(verr))
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	return nil
+}
+
+// validationMessage returns the failed validations
+// along with the location of the invalid data, e.g.:
+// "data/high: expected integer, but got string".
+func validationMessage(err *jsonschema.ValidationError) string {
+	var mssgs []string
+	var leaves func(*jsonschema.ValidationError)
+	leaves = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			mssgs = append(mssgs, fmt.Sprintf("data%s: %s", e.InstanceLocation, e.Message))
+			return
+		}
+		for _, c := range e.Causes {
+			leaves(c)
+		}
+	}
+	leaves(err)
+	return strings.Join(mssgs, "; ")
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+	"github.com/adevinta/vulcan-reports-generator/pkg/queue"
+)
+
+const liveReportDataV2 = `{
+	"team_id": "1", "date_from": "2021-04-26", "date_to": "2021-05-03",
+	"live_report_url": "https://vulcan.example.com/1/live",
+	"info": 0, "low": 1, "medium": 2, "high": 3, "critical": 4,
+	"info_diff": 0, "low_diff": 0, "medium_diff": 1, "high_diff": 1, "critical_diff": 0,
+	"info_fixed": 0, "low_fixed": 0, "medium_fixed": 0, "high_fixed": 2, "critical_fixed": 1
+}`
+
+func TestValidateRequestData(t *testing.T) {
+	schemas, err := loadRequestSchemas()
+	if err != nil {
+		t.Fatalf("Error loading schemas: %v", err)
+	}
+
+	testCases := []struct {
+		name         string
+		typ          model.ReportType
+		version      int
+		data         string
+		expectedErr  error
+		expectedMssg string
+	}{
+		{
+			name: "Should validate v1 data by default",
+			typ:  "livereport",
+			data: `{"team_id": "1", "date_from": "2021-04-26", "date_to": "2021-05-03", "high": 3}`,
+		},
+		{
+			name:         "Should return err for v1 data with unknown fields",
+			typ:          "livereport",
+			data:         `{"team_id": "1", "date_from": "2021-04-26", "date_to": "2021-05-03", "high": 3, "extra": true}`,
+			expectedErr:  ErrInvalidRequest,
+			expectedMssg: "extra",
+		},
+		{
+			name:         "Should return err for wrong numeric type",
+			typ:          "livereport",
+			version:      1,
+			data:         `{"team_id": "1", "date_from": "2021-04-26", "date_to": "2021-05-03", "high": "3"}`,
+			expectedErr:  ErrInvalidRequest,
+			expectedMssg: "data/high: expected integer, but got string",
+		},
+		{
+			name:         "Should return err for missing required field",
+			typ:          "livereport",
+			data:         `{"team_id": "1", "date_from": "2021-04-26"}`,
+			expectedErr:  ErrInvalidRequest,
+			expectedMssg: "date_to",
+		},
+		{
+			name:    "Should validate v2 data",
+			typ:     "livereport",
+			version: 2,
+			data:    liveReportDataV2,
+		},
+		{
+			name:         "Should return err for v2 data with missing counts",
+			typ:          "livereport",
+			version:      2,
+			data:         `{"team_id": "1", "date_from": "2021-04-26", "date_to": "2021-05-03", "live_report_url": "https://vulcan.example.com/1/live"}`,
+			expectedErr:  ErrInvalidRequest,
+			expectedMssg: "critical_fixed",
+		},
+		{
+			name:         "Should return err for v2 data with invalid date",
+			typ:          "livereport",
+			version:      2,
+			data:         strings.Replace(liveReportDataV2, "2021-04-26", "26/04/2021", 1),
+			expectedErr:  ErrInvalidRequest,
+			expectedMssg: "data/date_from",
+		},
+		{
+			name:         "Should return err for v2 data with unknown fields",
+			typ:          "livereport",
+			version:      2,
+			data:         strings.Replace(liveReportDataV2, `"team_id": "1"`, `"team_id": "1", "extra": true`, 1),
+			expectedErr:  ErrInvalidRequest,
+			expectedMssg: "extra",
+		},
+		{
+			name:        "Should return err for unsupported version",
+			typ:         "livereport",
+			version:     99,
+			data:        liveReportDataV2,
+			expectedErr: ErrUnsupportedVersion,
+		},
+		{
+			name: "Should not validate report types without schemas",
+			typ:  "scan",
+			data: `{"scan_id": 1}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var data interface{}
+			if err := json.Unmarshal([]byte(tc.data), &data); err != nil {
+				t.Fatalf("Error decoding data: %v", err)
+			}
+
+			err := schemas.validate(tc.typ, tc.version, data)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected err: %v\nBut got: %v", tc.expectedErr, err)
+			}
+			if err != nil && !strings.Contains(err.Error(), tc.expectedMssg) {
+				t.Fatalf("Expected err to contain: %s\nBut got: %v", tc.expectedMssg, err)
+			}
+		})
+	}
+}
+
+func TestProcessInvalidData(t *testing.T) {
+	var genCalls int
+	generateUC := &mockGenerateUC{
+		mockGenerateFunc: func(ctx context.Context, teamInfo teamInfo, reportData interface{}) (model.Report, error) {
+			genCalls++
+			return mockReport, nil
+		},
+	}
+	processor, err := NewProcessor(log.New(), ProcessorConfig{}, map[model.ReportType]GenerateUC{"livereport": generateUC},
+		&mockNotifier{}, &mockMetricsClient{}, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Error building processor: %v", err)
+	}
+
+	input := `{"type": "livereport", "version": 2, "team_info": {"id": "1"}, "data": {"team_id": "1"}}`
+	err = processor.ProcessMessage(context.Background(), queue.Message{ID: "mssg-1", Body: input})
+	if !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("Expected err: %v\nBut got: %v", ErrInvalidRequest, err)
+	}
+	// Invalid requests are never retried.
+	if !errors.Is(err, queue.ErrUnprocessable) {
+		t.Fatalf("Expected err: %v\nBut got: %v", queue.ErrUnprocessable, err)
+	}
+	if genCalls != 0 {
+		t.Fatalf("Expected generate calls to be: %d\nBut got: %d", 0, genCalls)
+	}
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Locker represents a distributed lock shared by
+// every replica of the service.
+type Locker interface {
+	// TryLock runs fn only if the lock identified by key could be
+	// acquired, returning whether it was acquired or not.
+	TryLock(ctx context.Context, key int64, fn func(ctx context.Context) error) (bool, error)
+}
+
+// PGAdvisoryLocker implements Locker using Postgres session advisory locks.
+type PGAdvisoryLocker struct {
+	db *sql.DB
+}
+
+// NewPGAdvisoryLocker builds a new PGAdvisoryLocker.
+func NewPGAdvisoryLocker(db *sql.DB) *PGAdvisoryLocker {
+	return &PGAdvisoryLocker{
+		db: db,
+	}
+}
+
+// TryLock runs fn holding the advisory lock identified by key.
+// Session advisory locks are bound to the DB connection, so a
+// dedicated connection is kept until the lock is released.
+func (l *PGAdvisoryLocker) TryLock(ctx context.Context, key int64, fn func(ctx context.Context) error) (bool, error) {
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&acquired); err != nil {
+		return false, err
+	}
+	if !acquired {
+		return false, nil
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key) // nolint
+
+	return true, fn(ctx)
+}
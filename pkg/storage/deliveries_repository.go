@@ -0,0 +1,181 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+)
+
+const (
+	deliveryColumns = `id, report_id, report_type, team_id, recipient, channel, provider_message_id, status, error, created_at, updated_at`
+
+	insertDeliveryQuery = `INSERT INTO report_deliveries (report_id, report_type, team_id, recipient, channel, provider_message_id, status, error, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id`
+	selectDeliveriesQuery = `SELECT ` + deliveryColumns + `
+		FROM report_deliveries WHERE report_id = $1
+		ORDER BY created_at, id`
+	updateDeliveryStatusQuery = `UPDATE report_deliveries SET status = $3, error = $4, updated_at = $5
+		WHERE provider_message_id = $1 AND lower(recipient) = lower($2)`
+
+	// Only the deliveries of the notifications accepted
+	// by the provider are counted against the daily caps.
+	sentStatuses             = `('SENT', 'DELIVERED', 'BOUNCED', 'COMPLAINED')`
+	countTeamDeliveriesQuery = `SELECT COUNT(*) FROM report_deliveries
+		WHERE team_id = $1 AND created_at >= $2 AND status IN ` + sentStatuses
+	countRecipientDeliveriesQuery = `SELECT lower(recipient), COUNT(*) FROM report_deliveries
+		WHERE lower(recipient) = ANY($1) AND created_at >= $2 AND status IN ` + sentStatuses + `
+		GROUP BY lower(recipient)`
+)
+
+var (
+	// ErrDeliveryNotFound indicates that there is no delivery for the specified notification and recipient.
+	ErrDeliveryNotFound = errors.New("Delivery not found")
+)
+
+// DeliveriesRepository represents the storage for the
+// deliveries of the report notifications to each recipient.
+type DeliveriesRepository interface {
+	// SaveDeliveries records the deliveries of a send attempt.
+	SaveDeliveries(ctx context.Context, deliveries []model.Delivery) error
+	// GetDeliveries returns the deliveries of the report, oldest first.
+	GetDeliveries(ctx context.Context, reportID string) ([]model.Delivery, error)
+	// UpdateDeliveryStatus updates the status of the delivery of the
+	// notification identified by providerMessageID to the recipient.
+	UpdateDeliveryStatus(ctx context.Context, providerMessageID, recipient, status, errMssg string) error
+	// CountTeamDeliveries returns the number of notifications
+	// sent to the recipients of the team since the specified time.
+	CountTeamDeliveries(ctx context.Context, teamID string, since time.Time) (int, error)
+	// CountRecipientDeliveries returns the number of notifications sent to
+	// each one of the recipients since the specified time, by lowercased
+	// recipient. Recipients without notifications are not returned.
+	CountRecipientDeliveries(ctx context.Context, recipients []string, since time.Time) (map[string]int, error)
+}
+
+// PGDeliveriesRepository is the Postgres
+// implementation of DeliveriesRepository.
+type PGDeliveriesRepository struct {
+	db *sql.DB
+}
+
+// NewDeliveriesRepository builds a new DeliveriesRepository.
+func NewDeliveriesRepository(db *sql.DB) *PGDeliveriesRepository {
+	return &PGDeliveriesRepository{
+		db: db,
+	}
+}
+
+// SaveDeliveries inserts the deliveries within a transaction,
+// so either every recipient of the attempt is recorded or none.
+func (r *PGDeliveriesRepository) SaveDeliveries(ctx context.Context, deliveries []model.Delivery) error {
+	return Transact(ctx, r.db, func(tx *sql.Tx) error {
+		for i := range deliveries {
+			d := &deliveries[i]
+			err := tx.QueryRowContext(ctx, insertDeliveryQuery, d.ReportID, string(d.ReportType), d.TeamID, d.Recipient,
+				d.Channel, d.ProviderMessageID, d.Status, d.Error, d.CreatedAt, d.UpdatedAt).Scan(&d.ID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetDeliveries returns the deliveries of the report, oldest first.
+func (r *PGDeliveriesRepository) GetDeliveries(ctx context.Context, reportID string) ([]model.Delivery, error) {
+	rows, err := r.db.QueryContext(ctx, selectDeliveriesQuery, reportID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []model.Delivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// UpdateDeliveryStatus updates the status of the delivery of the
+// notification identified by providerMessageID to the recipient.
+// It returns ErrDeliveryNotFound if there is no such delivery.
+func (r *PGDeliveriesRepository) UpdateDeliveryStatus(ctx context.Context, providerMessageID, recipient, status, errMssg string) error {
+	res, err := r.db.ExecContext(ctx, updateDeliveryStatusQuery, providerMessageID, recipient, status, errMssg, time.Now())
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrDeliveryNotFound
+	}
+	return nil
+}
+
+// CountTeamDeliveries returns the number of notifications
+// sent to the recipients of the team since the specified time.
+func (r *PGDeliveriesRepository) CountTeamDeliveries(ctx context.Context, teamID string, since time.Time) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx, countTeamDeliveriesQuery, teamID, since).Scan(&n)
+	return n, err
+}
+
+// CountRecipientDeliveries returns the number of notifications sent to
+// each one of the recipients since the specified time, by lowercased
+// recipient. Recipients without notifications are not returned.
+func (r *PGDeliveriesRepository) CountRecipientDeliveries(ctx context.Context, recipients []string, since time.Time) (map[string]int, error) {
+	emails := make([]string, 0, len(recipients))
+	for _, rcpt := range recipients {
+		emails = append(emails, normalizeEmail(rcpt))
+	}
+
+	rows, err := r.db.QueryContext(ctx, countRecipientDeliveriesQuery, pq.Array(emails), since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var (
+			email string
+			n     int
+		)
+		if err := rows.Scan(&email, &n); err != nil {
+			return nil, err
+		}
+		counts[email] = n
+	}
+
+	return counts, rows.Err()
+}
+
+func scanDelivery(row rowScanner) (model.Delivery, error) {
+	var d model.Delivery
+	var reportType string
+
+	err := row.Scan(&d.ID, &d.ReportID, &reportType, &d.TeamID, &d.Recipient, &d.Channel,
+		&d.ProviderMessageID, &d.Status, &d.Error, &d.CreatedAt, &d.UpdatedAt)
+	if err != nil {
+		return model.Delivery{}, err
+	}
+	d.ReportType = model.ReportType(reportType)
+
+	return d, nil
+}
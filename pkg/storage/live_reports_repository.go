@@ -18,40 +18,121 @@ import (
 	"github.com/volatiletech/sqlboiler/queries/qm"
 )
 
+// LiveReportsRepository is the Postgres ReportsRepository for live reports.
+// If it is bound to a transaction, the reports read are locked until
+// the transaction ends.
 type LiveReportsRepository struct {
-	db *sql.DB
+	db   *sql.DB
+	exec boil.ContextExecutor
+	tx   *sql.Tx
 }
 
 func newLiveReportsRepository(db *sql.DB) *LiveReportsRepository {
 	return &LiveReportsRepository{
-		db: db,
+		db:   db,
+		exec: db,
 	}
 }
 
+// WithTx returns a repository whose methods run within tx.
+// If tx is nil, they run outside of any transaction.
+func (r *LiveReportsRepository) WithTx(tx *sql.Tx) ReportsRepository {
+	if tx == nil {
+		return newLiveReportsRepository(r.db)
+	}
+	return &LiveReportsRepository{
+		db:   r.db,
+		exec: tx,
+		tx:   tx,
+	}
+}
+
+// Tx returns the transaction the repository is bound to, or nil.
+func (r *LiveReportsRepository) Tx() *sql.Tx {
+	return r.tx
+}
+
+// Transact runs fn within a new transaction. If the repository
+// is already bound to a transaction, fn joins it.
+func (r *LiveReportsRepository) Transact(ctx context.Context, fn func(r ReportsRepository) error) error {
+	return r.transact(ctx, func(r *LiveReportsRepository) error {
+		return fn(r)
+	})
+}
+
+func (r *LiveReportsRepository) transact(ctx context.Context, fn func(r *LiveReportsRepository) error) error {
+	if r.tx != nil {
+		return fn(r)
+	}
+	return Transact(ctx, r.db, func(tx *sql.Tx) error {
+		return fn(r.WithTx(tx).(*LiveReportsRepository))
+	})
+}
+
+// SaveReport atomically inserts the report or updates the one
+// for the same team and date range, recording a new revision.
 func (r *LiveReportsRepository) SaveReport(ctx context.Context, report model.Report) error {
 	liveReport, ok := report.(*model.LiveReport)
 	if !ok {
 		return ErrInvalidReportData
 	}
 
-	// Check if report for teamID+dateFrom+dateTo already exists in DB.
-	dbReport, err := r.GetReportByTeamAndDateRange(ctx, liveReport.TeamID, liveReport.DateFrom, liveReport.DateTo)
-	if err != nil {
-		if err == ErrReportNotFound {
-			// If there's no report, insert.
-			return r.Insert(ctx, liveReport)
+	return r.transact(ctx, func(r *LiveReportsRepository) error {
+		now := time.Now()
+		liveReport.StatusUpdatedAt = now
+		liveReport.CreatedAt = now
+		liveReport.UpdatedAt = now
+
+		// Lock the current report, if any, to preserve its creation
+		// time, and its status update time if the status does not change.
+		current, err := r.GetReportByTeamAndDateRange(ctx, liveReport.TeamID, liveReport.DateFrom, liveReport.DateTo)
+		if err != nil && !errors.Is(err, ErrReportNotFound) {
+			return err
 		}
-		return err
-	}
+		if err == nil {
+			liveReport.ID = current.ID
+			liveReport.CreatedAt = current.CreatedAt
+			if current.Status == liveReport.Status {
+				liveReport.StatusUpdatedAt = current.StatusUpdatedAt
+			}
+		}
+
+		dbReport := toDBLiveReport(liveReport)
+		err = dbReport.Upsert(ctx, r.exec, true,
+			[]string{LiveReportColumns.TeamID, LiveReportColumns.DateFrom, LiveReportColumns.DateTo},
+			boil.Whitelist(
+				LiveReportColumns.EmailSubject, LiveReportColumns.EmailBody, LiveReportColumns.DeliveredTo,
+				LiveReportColumns.Status, LiveReportColumns.UpdateStatusAt, LiveReportColumns.UpdatedAt,
+			),
+			boil.Infer(),
+		)
+		if err != nil {
+			return err
+		}
+		liveReport.ID = dbReport.ID
+
+		return insertRevision(ctx, r.exec, &model.ReportRevision{
+			ReportID:     liveReport.ID,
+			ReportType:   model.LiveReportType,
+			Status:       liveReport.Status,
+			Notification: liveReport.Notification,
+			DeliveredTo:  liveReport.DeliveredTo,
+			CreatedAt:    liveReport.UpdatedAt,
+		})
+	})
+}
 
-	// Report exists, so update it.
-	liveReport.ID = dbReport.ID
-	liveReport.CreatedAt = time.Now()
-	return r.Update(ctx, liveReport)
+// GetReportRevisions returns the revisions of the live report, oldest first.
+func (r *LiveReportsRepository) GetReportRevisions(ctx context.Context, reportID string) ([]model.ReportRevision, error) {
+	return getRevisions(ctx, r.exec, reportID, model.NotifFmtHTML)
 }
 
 func (r *LiveReportsRepository) GetReport(ctx context.Context, reportID string) (model.Report, error) {
-	dbReport, err := FindLiveReport(ctx, r.db, reportID)
+	mods := []qm.QueryMod{LiveReportWhere.ID.EQ(reportID)}
+	if r.tx != nil {
+		mods = append(mods, qm.For("UPDATE"))
+	}
+	dbReport, err := LiveReports(mods...).One(ctx, r.exec)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrReportNotFound
@@ -61,8 +142,28 @@ func (r *LiveReportsRepository) GetReport(ctx context.Context, reportID string)
 	return toModelLiveReport(dbReport), nil
 }
 
+func (r *LiveReportsRepository) GetReportsByStatus(ctx context.Context, status string, statusUpdatedBefore time.Time) ([]model.Report, error) {
+	liveReports, err := LiveReports(
+		LiveReportWhere.Status.EQ(status),
+		LiveReportWhere.UpdateStatusAt.LT(statusUpdatedBefore),
+	).All(ctx, r.exec)
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []model.Report
+	for _, liveReport := range liveReports {
+		reports = append(reports, toModelLiveReport(liveReport))
+	}
+	return reports, nil
+}
+
 func (r *LiveReportsRepository) GetReportByTeamAndDateRange(ctx context.Context, teamID string, dateFrom string, dateTo string) (*model.LiveReport, error) {
-	liveReports, err := LiveReports(qm.Where("team_id=? AND date_from=? AND date_to=?", teamID, dateFrom, dateTo)).All(ctx, r.db)
+	mods := []qm.QueryMod{qm.Where("team_id=? AND date_from=? AND date_to=?", teamID, dateFrom, dateTo)}
+	if r.tx != nil {
+		mods = append(mods, qm.For("UPDATE"))
+	}
+	liveReports, err := LiveReports(mods...).All(ctx, r.exec)
 	if err != nil {
 		return nil, err
 	}
@@ -79,27 +180,12 @@ func (r *LiveReportsRepository) GetReportByTeamAndDateRange(ctx context.Context,
 	return toModelLiveReport(liveReports[0]), nil
 }
 
-func (r *LiveReportsRepository) Insert(ctx context.Context, report *model.LiveReport) error {
-	report.CreatedAt = time.Now()
-	dbReport := toDBLiveReport(report)
-
-	err := dbReport.Insert(ctx, r.db, boil.Infer())
-	if err != nil {
-		return err
-	}
-
-	report.ID = dbReport.ID
-	return nil
-}
-
-func (r *LiveReportsRepository) Update(ctx context.Context, report *model.LiveReport) error {
-	report.UpdatedAt = time.Now()
-	_, err := toDBLiveReport(report).Update(ctx, r.db, boil.Infer())
-	return err
-}
-
 func toModelLiveReport(dbReport *LiveReport) *model.LiveReport {
 	emailBody, _ := b64.StdEncoding.DecodeString(dbReport.EmailBody) // nolint
+	var deliveredTo []string
+	if dbReport.DeliveredTo != "" {
+		deliveredTo = strings.Split(dbReport.DeliveredTo, comma)
+	}
 	return &model.LiveReport{
 		BaseReport: model.BaseReport{
 			ID:     dbReport.ID,
@@ -109,9 +195,10 @@ func toModelLiveReport(dbReport *LiveReport) *model.LiveReport {
 				Body:    string(emailBody),
 				Fmt:     model.NotifFmtHTML,
 			},
-			DeliveredTo: strings.Split(dbReport.DeliveredTo, comma),
-			CreatedAt:   dbReport.CreatedAt,
-			UpdatedAt:   dbReport.UpdatedAt,
+			DeliveredTo:     deliveredTo,
+			StatusUpdatedAt: dbReport.UpdateStatusAt,
+			CreatedAt:       dbReport.CreatedAt,
+			UpdatedAt:       dbReport.UpdatedAt,
 		},
 		TeamID:   dbReport.TeamID,
 		DateFrom: dbReport.DateFrom,
@@ -127,10 +214,11 @@ func toDBLiveReport(modelReport *model.LiveReport) *LiveReport {
 		DateTo:       modelReport.DateTo,
 		EmailSubject: modelReport.Notification.Subject,
 		// Encode email body to b64 to comply with old versions.
-		EmailBody:   b64.StdEncoding.EncodeToString([]byte(modelReport.Notification.Body)),
-		DeliveredTo: strings.Join(modelReport.DeliveredTo[:], comma),
-		Status:      modelReport.Status,
-		CreatedAt:   modelReport.CreatedAt,
-		UpdatedAt:   modelReport.UpdatedAt,
+		EmailBody:      b64.StdEncoding.EncodeToString([]byte(modelReport.Notification.Body)),
+		DeliveredTo:    strings.Join(modelReport.DeliveredTo[:], comma),
+		Status:         modelReport.Status,
+		UpdateStatusAt: modelReport.StatusUpdatedAt,
+		CreatedAt:      modelReport.CreatedAt,
+		UpdatedAt:      modelReport.UpdatedAt,
 	}
 }
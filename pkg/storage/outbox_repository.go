@@ -0,0 +1,149 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/volatiletech/sqlboiler/boil"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+)
+
+const (
+	outboxColumns = `id, report_id, report_type, team_id, idempotency_key, subject, body, format, recipients,
+		delivered_to, status, attempts, error, next_attempt_at, created_at, updated_at`
+
+	// enqueueNotificationQuery refreshes the notification already
+	// pending for the report, if any, e.g.: if the report was generated
+	// again, so the latest content is sent.
+	enqueueNotificationQuery = `INSERT INTO notifications_outbox (report_id, report_type, team_id, idempotency_key,
+			subject, body, format, recipients, status, next_attempt_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 'PENDING', $9, $9, $9)
+		ON CONFLICT (report_id) WHERE status = 'PENDING' DO UPDATE SET
+			subject = EXCLUDED.subject,
+			body = EXCLUDED.body,
+			format = EXCLUDED.format,
+			recipients = EXCLUDED.recipients,
+			idempotency_key = EXCLUDED.idempotency_key,
+			updated_at = EXCLUDED.updated_at`
+	// lockNextNotificationQuery locks the oldest due notification,
+	// skipping those already locked by other dispatchers.
+	lockNextNotificationQuery = `SELECT ` + outboxColumns + `
+		FROM notifications_outbox
+		WHERE status = 'PENDING' AND next_attempt_at <= $1
+		ORDER BY id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED`
+	updateNotificationQuery = `UPDATE notifications_outbox
+		SET status = $2, attempts = $3, error = $4, next_attempt_at = $5, delivered_to = $6, updated_at = $7
+		WHERE id = $1`
+)
+
+// OutboxRepository represents the storage for the report
+// notifications waiting to be sent, i.e.: the outbox.
+type OutboxRepository interface {
+	// WithTx returns a repository whose methods run within tx, so the
+	// notifications are enqueued along with the rest of tx changes.
+	WithTx(tx *sql.Tx) OutboxRepository
+	// EnqueueNotification adds the notification to the outbox as pending.
+	// If there is already a pending notification for the report, its
+	// content and recipients are replaced.
+	EnqueueNotification(ctx context.Context, n model.OutboxNotification) error
+	// DispatchNext locks the oldest pending notification which is due and
+	// calls fn with it and the transaction holding the lock, which is
+	// skipped by other dispatchers. The notification, as updated by fn,
+	// is saved within the same transaction. If fn fails, the transaction
+	// is rolled back. It returns false if no notification was due.
+	DispatchNext(ctx context.Context, fn func(ctx context.Context, tx *sql.Tx, n *model.OutboxNotification) error) (bool, error)
+}
+
+// PGOutboxRepository is the Postgres
+// implementation of OutboxRepository.
+type PGOutboxRepository struct {
+	db   *sql.DB
+	exec boil.ContextExecutor
+}
+
+// NewOutboxRepository builds a new OutboxRepository.
+func NewOutboxRepository(db *sql.DB) *PGOutboxRepository {
+	return &PGOutboxRepository{
+		db:   db,
+		exec: db,
+	}
+}
+
+// WithTx returns a repository whose methods run within tx.
+// If tx is nil, they run outside of any transaction.
+func (r *PGOutboxRepository) WithTx(tx *sql.Tx) OutboxRepository {
+	if tx == nil {
+		return NewOutboxRepository(r.db)
+	}
+	return &PGOutboxRepository{
+		db:   r.db,
+		exec: tx,
+	}
+}
+
+// EnqueueNotification adds the notification to the outbox as pending.
+// If there is already a pending notification for the report, its
+// content and recipients are replaced, keeping its attempts.
+func (r *PGOutboxRepository) EnqueueNotification(ctx context.Context, n model.OutboxNotification) error {
+	_, err := r.exec.ExecContext(ctx, enqueueNotificationQuery, n.ReportID, string(n.ReportType), n.TeamID,
+		n.IdempotencyKey, n.Notification.Subject, n.Notification.Body, n.Notification.Fmt,
+		pq.Array(n.Recipients), time.Now())
+	return err
+}
+
+// DispatchNext locks the oldest pending notification which is due and
+// calls fn with it and the transaction holding the lock. The status,
+// attempts, error, next attempt time and delivered to recipients set
+// by fn are saved within the same transaction. It returns false if no
+// notification was due.
+func (r *PGOutboxRepository) DispatchNext(ctx context.Context, fn func(ctx context.Context, tx *sql.Tx, n *model.OutboxNotification) error) (bool, error) {
+	dispatched := false
+	err := Transact(ctx, r.db, func(tx *sql.Tx) error {
+		n, err := scanOutboxNotification(tx.QueryRowContext(ctx, lockNextNotificationQuery, time.Now()))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := fn(ctx, tx, &n); err != nil {
+			return err
+		}
+
+		n.UpdatedAt = time.Now()
+		_, err = tx.ExecContext(ctx, updateNotificationQuery, n.ID, n.Status, n.Attempts, n.Error,
+			n.NextAttemptAt, pq.Array(n.DeliveredTo), n.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		dispatched = true
+		return nil
+	})
+	return dispatched, err
+}
+
+func scanOutboxNotification(row rowScanner) (model.OutboxNotification, error) {
+	var n model.OutboxNotification
+	var reportType string
+
+	err := row.Scan(&n.ID, &n.ReportID, &reportType, &n.TeamID, &n.IdempotencyKey, &n.Notification.Subject,
+		&n.Notification.Body, &n.Notification.Fmt, pq.Array(&n.Recipients), pq.Array(&n.DeliveredTo), &n.Status, &n.Attempts,
+		&n.Error, &n.NextAttemptAt, &n.CreatedAt, &n.UpdatedAt)
+	if err != nil {
+		return model.OutboxNotification{}, err
+	}
+	n.ReportType = model.ReportType(reportType)
+
+	return n, nil
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+)
+
+const (
+	preferenceColumns = `email, report_type, unsubscribed, format, channel, updated_at`
+
+	selectPreferencesQuery = `SELECT ` + preferenceColumns + `
+		FROM recipient_preferences WHERE report_type = $1 AND email = ANY($2)`
+	selectRecipientPreferencesQuery = `SELECT ` + preferenceColumns + `
+		FROM recipient_preferences WHERE email = $1
+		ORDER BY report_type`
+	upsertPreferenceQuery = `INSERT INTO recipient_preferences (email, report_type, unsubscribed, format, channel, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (email, report_type) DO UPDATE SET
+			unsubscribed = EXCLUDED.unsubscribed,
+			format = EXCLUDED.format,
+			channel = EXCLUDED.channel,
+			updated_at = EXCLUDED.updated_at`
+	unsubscribeQuery = `INSERT INTO recipient_preferences (email, report_type, unsubscribed, updated_at)
+		VALUES ($1, $2, TRUE, $3)
+		ON CONFLICT (email, report_type) DO UPDATE SET
+			unsubscribed = TRUE,
+			updated_at = EXCLUDED.updated_at`
+)
+
+// PreferencesRepository represents the storage for the
+// preferences of the recipients for each report type.
+type PreferencesRepository interface {
+	// GetPreferences returns the preferences of the
+	// recipients that have any for the report type.
+	GetPreferences(ctx context.Context, typ model.ReportType, recipients []string) ([]model.Preference, error)
+	// GetRecipientPreferences returns the preferences of
+	// the recipient for every report type.
+	GetRecipientPreferences(ctx context.Context, email string) ([]model.Preference, error)
+	// SavePreference creates or replaces the preference.
+	SavePreference(ctx context.Context, pref model.Preference) error
+	// Unsubscribe unsubscribes the recipient from the report
+	// type, keeping the rest of its preferences.
+	Unsubscribe(ctx context.Context, email string, typ model.ReportType) error
+}
+
+// PGPreferencesRepository is the Postgres
+// implementation of PreferencesRepository.
+type PGPreferencesRepository struct {
+	db *sql.DB
+}
+
+// NewPreferencesRepository builds a new PreferencesRepository.
+func NewPreferencesRepository(db *sql.DB) *PGPreferencesRepository {
+	return &PGPreferencesRepository{
+		db: db,
+	}
+}
+
+// GetPreferences returns the preferences of the
+// recipients that have any for the report type.
+func (r *PGPreferencesRepository) GetPreferences(ctx context.Context, typ model.ReportType, recipients []string) ([]model.Preference, error) {
+	emails := make([]string, 0, len(recipients))
+	for _, rcpt := range recipients {
+		emails = append(emails, normalizeEmail(rcpt))
+	}
+
+	return r.queryPreferences(ctx, selectPreferencesQuery, string(typ), pq.Array(emails))
+}
+
+// GetRecipientPreferences returns the preferences
+// of the recipient for every report type.
+func (r *PGPreferencesRepository) GetRecipientPreferences(ctx context.Context, email string) ([]model.Preference, error) {
+	return r.queryPreferences(ctx, selectRecipientPreferencesQuery, normalizeEmail(email))
+}
+
+// SavePreference creates or replaces the preference.
+func (r *PGPreferencesRepository) SavePreference(ctx context.Context, pref model.Preference) error {
+	var format sql.NullInt16
+	if pref.HasFmt {
+		format = sql.NullInt16{Int16: int16(pref.Fmt), Valid: true}
+	}
+	_, err := r.db.ExecContext(ctx, upsertPreferenceQuery, normalizeEmail(pref.Email), string(pref.ReportType),
+		pref.Unsubscribed, format, pref.Channel, pref.UpdatedAt)
+	return err
+}
+
+// Unsubscribe unsubscribes the recipient from the report
+// type, keeping the rest of its preferences.
+func (r *PGPreferencesRepository) Unsubscribe(ctx context.Context, email string, typ model.ReportType) error {
+	_, err := r.db.ExecContext(ctx, unsubscribeQuery, normalizeEmail(email), string(typ), time.Now())
+	return err
+}
+
+func (r *PGPreferencesRepository) queryPreferences(ctx context.Context, query string, args ...interface{}) ([]model.Preference, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var prefs []model.Preference
+	for rows.Next() {
+		var (
+			pref       model.Preference
+			reportType string
+			format     sql.NullInt16
+		)
+		err := rows.Scan(&pref.Email, &reportType, &pref.Unsubscribed, &format, &pref.Channel, &pref.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		pref.ReportType = model.ReportType(reportType)
+		pref.HasFmt, pref.Fmt = format.Valid, model.NotifFmt(format.Int16)
+		prefs = append(prefs, pref)
+	}
+
+	return prefs, rows.Err()
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+)
+
+const (
+	processedMssgColumns = `id, report_type, report_id, stage, payload, requeues, notified, locked_until, created_at, updated_at`
+
+	selectStaleProcessedMssgsQuery = `SELECT ` + processedMssgColumns + `
+		FROM processed_messages WHERE stage = $1 AND updated_at < $2
+		ORDER BY updated_at`
+	upsertProcessedMssgQuery = `INSERT INTO processed_messages (id, report_type, report_id, stage, payload, requeues, notified, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+		ON CONFLICT (id) DO UPDATE SET
+			report_type = EXCLUDED.report_type,
+			report_id = EXCLUDED.report_id,
+			stage = EXCLUDED.stage,
+			payload = EXCLUDED.payload,
+			requeues = EXCLUDED.requeues,
+			notified = EXCLUDED.notified,
+			updated_at = EXCLUDED.updated_at
+		RETURNING created_at`
+	// claimProcessedMssgQuery inserts the message, locked, or locks
+	// it if it exists and it is not locked by another consumer. No row
+	// is returned if the lock is held, so the claim is atomic.
+	claimProcessedMssgQuery = `INSERT INTO processed_messages (id, report_type, report_id, stage, payload, requeues, locked_until, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+		ON CONFLICT (id) DO UPDATE SET locked_until = EXCLUDED.locked_until
+		WHERE processed_messages.locked_until IS NULL OR processed_messages.locked_until < $8
+		RETURNING ` + processedMssgColumns
+	releaseProcessedMssgQuery = `UPDATE processed_messages SET locked_until = NULL WHERE id = $1`
+)
+
+// ProcessedMessagesRepository represents the storage for the processing
+// state of report generation requests, used to deduplicate them.
+type ProcessedMessagesRepository interface {
+	GetStaleProcessedMessages(ctx context.Context, stage string, updatedBefore time.Time) ([]*model.ProcessedMessage, error)
+	SaveProcessedMessage(ctx context.Context, mssg *model.ProcessedMessage) error
+	// ClaimProcessedMessage locks the message for lease, inserting it if
+	// it does not exist, and sets mssg to its stored processing state.
+	// It returns false if the message is locked by another consumer.
+	ClaimProcessedMessage(ctx context.Context, mssg *model.ProcessedMessage, lease time.Duration) (bool, error)
+	// ReleaseProcessedMessage unlocks the message claimed with ClaimProcessedMessage.
+	ReleaseProcessedMessage(ctx context.Context, key string) error
+}
+
+// PGProcessedMessagesRepository is the Postgres
+// implementation of ProcessedMessagesRepository.
+type PGProcessedMessagesRepository struct {
+	db *sql.DB
+}
+
+// NewProcessedMessagesRepository builds a new ProcessedMessagesRepository.
+func NewProcessedMessagesRepository(db *sql.DB) *PGProcessedMessagesRepository {
+	return &PGProcessedMessagesRepository{
+		db: db,
+	}
+}
+
+// GetStaleProcessedMessages returns the messages that are in the
+// specified stage and have not been updated since updatedBefore.
+func (r *PGProcessedMessagesRepository) GetStaleProcessedMessages(ctx context.Context, stage string, updatedBefore time.Time) ([]*model.ProcessedMessage, error) {
+	rows, err := r.db.QueryContext(ctx, selectStaleProcessedMssgsQuery, stage, updatedBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mssgs []*model.ProcessedMessage
+	for rows.Next() {
+		mssg, err := scanProcessedMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		mssgs = append(mssgs, mssg)
+	}
+
+	return mssgs, rows.Err()
+}
+
+// SaveProcessedMessage inserts or updates the processing state for a message.
+func (r *PGProcessedMessagesRepository) SaveProcessedMessage(ctx context.Context, mssg *model.ProcessedMessage) error {
+	mssg.UpdatedAt = time.Now()
+	return r.db.QueryRowContext(ctx, upsertProcessedMssgQuery, mssg.Key, string(mssg.ReportType),
+		mssg.ReportID, mssg.Stage, mssg.Payload, mssg.Requeues, pq.Array(mssg.Notified), mssg.UpdatedAt).Scan(&mssg.CreatedAt)
+}
+
+// ClaimProcessedMessage locks the message for lease, inserting it if it
+// does not exist. The lock expires after lease, so the messages whose
+// consumer died can be processed again. It returns false if the message
+// is locked by another consumer.
+func (r *PGProcessedMessagesRepository) ClaimProcessedMessage(ctx context.Context, mssg *model.ProcessedMessage, lease time.Duration) (bool, error) {
+	now := time.Now()
+	claimed, err := scanProcessedMessage(r.db.QueryRowContext(ctx, claimProcessedMssgQuery, mssg.Key,
+		string(mssg.ReportType), mssg.ReportID, mssg.Stage, mssg.Payload, mssg.Requeues, now.Add(lease), now))
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	*mssg = *claimed
+	return true, nil
+}
+
+// ReleaseProcessedMessage unlocks the message, so it can be claimed again.
+func (r *PGProcessedMessagesRepository) ReleaseProcessedMessage(ctx context.Context, key string) error {
+	_, err := r.db.ExecContext(ctx, releaseProcessedMssgQuery, key)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanProcessedMessage(row rowScanner) (*model.ProcessedMessage, error) {
+	var mssg model.ProcessedMessage
+	var reportType string
+	var lockedUntil sql.NullTime
+
+	err := row.Scan(&mssg.Key, &reportType, &mssg.ReportID, &mssg.Stage,
+		&mssg.Payload, &mssg.Requeues, pq.Array(&mssg.Notified), &lockedUntil, &mssg.CreatedAt, &mssg.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	mssg.ReportType = model.ReportType(reportType)
+	mssg.LockedUntil = lockedUntil.Time
+
+	return &mssg, nil
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package storage
+
+import (
+	"context"
+	"strings"
+
+	"github.com/volatiletech/sqlboiler/boil"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+)
+
+const (
+	// insertRevisionQuery records the next revision of the report. It must
+	// run in the same transaction that saved the report, so the report row
+	// lock serializes the revision numbers.
+	insertRevisionQuery = `INSERT INTO report_revisions (report_id, revision, report_type, status, email_subject, email_body, delivered_to, created_at)
+		SELECT $1, COALESCE(MAX(revision), 0) + 1, $2, $3, $4, $5, $6, $7
+		FROM report_revisions WHERE report_id = $1
+		RETURNING revision`
+	selectRevisionsQuery = `SELECT report_id, revision, report_type, status, email_subject, email_body, delivered_to, created_at
+		FROM report_revisions WHERE report_id = $1
+		ORDER BY revision`
+)
+
+// insertRevision records the current state of the report as a new revision.
+func insertRevision(ctx context.Context, exec boil.ContextExecutor, rev *model.ReportRevision) error {
+	return exec.QueryRowContext(ctx, insertRevisionQuery,
+		rev.ReportID, rev.ReportType, rev.Status, rev.Notification.Subject, rev.Notification.Body,
+		strings.Join(rev.DeliveredTo, comma), rev.CreatedAt,
+	).Scan(&rev.Revision)
+}
+
+// getRevisions returns the revisions of the report, oldest first.
+func getRevisions(ctx context.Context, exec boil.ContextExecutor, reportID string, fmt model.NotifFmt) ([]model.ReportRevision, error) {
+	rows, err := exec.QueryContext(ctx, selectRevisionsQuery, reportID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []model.ReportRevision
+	for rows.Next() {
+		var (
+			rev         model.ReportRevision
+			deliveredTo string
+		)
+		err := rows.Scan(&rev.ReportID, &rev.Revision, &rev.ReportType, &rev.Status,
+			&rev.Notification.Subject, &rev.Notification.Body, &deliveredTo, &rev.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		rev.Notification.Fmt = fmt
+		if deliveredTo != "" {
+			rev.DeliveredTo = strings.Split(deliveredTo, comma)
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
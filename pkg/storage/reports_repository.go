@@ -7,6 +7,7 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/friendsofgo/errors"
 
@@ -30,7 +31,24 @@ var (
 // for a generic report repository.
 type ReportsRepository interface {
 	GetReport(ctx context.Context, reportID string) (model.Report, error)
+	// GetReportsByStatus returns the reports in the specified status
+	// whose status has not been updated since statusUpdatedBefore.
+	GetReportsByStatus(ctx context.Context, status string, statusUpdatedBefore time.Time) ([]model.Report, error)
+	// SaveReport inserts the report or, if there is already a report
+	// for the same key, e.g.: team and date range, updates it.
+	// Every save is recorded as a new revision of the report.
 	SaveReport(ctx context.Context, report model.Report) error
+	// GetReportRevisions returns the revisions of the report, oldest first.
+	GetReportRevisions(ctx context.Context, reportID string) ([]model.ReportRevision, error)
+	// WithTx returns a repository whose methods run within tx.
+	// Reports read through it are locked until tx ends.
+	WithTx(tx *sql.Tx) ReportsRepository
+	// Tx returns the transaction the repository is bound to, or nil.
+	Tx() *sql.Tx
+	// Transact runs fn within a new transaction, passing the
+	// repository bound to it. The transaction is committed
+	// if fn succeeds and rolled back otherwise.
+	Transact(ctx context.Context, fn func(r ReportsRepository) error) error
 }
 
 // NewReportsRepository builds and returns a new ReportsRepository
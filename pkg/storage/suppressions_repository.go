@@ -0,0 +1,137 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/adevinta/vulcan-reports-generator/pkg/model"
+)
+
+const (
+	suppressionColumns = `email, reason, detail, provider_message_id, created_at`
+
+	selectSuppressionsQuery = `SELECT ` + suppressionColumns + `
+		FROM suppressed_recipients ORDER BY created_at, email`
+	selectSuppressedQuery = `SELECT email FROM suppressed_recipients WHERE email = ANY($1)`
+	// insertSuppressionQuery keeps the original reason
+	// if the recipient is already suppressed.
+	insertSuppressionQuery = `INSERT INTO suppressed_recipients (email, reason, detail, provider_message_id, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (email) DO NOTHING`
+	deleteSuppressionQuery = `DELETE FROM suppressed_recipients WHERE email = $1`
+)
+
+var (
+	// ErrSuppressionNotFound indicates that the recipient is not in the suppression list.
+	ErrSuppressionNotFound = errors.New("Suppression not found")
+)
+
+// SuppressionsRepository represents the storage
+// for the list of recipients that must not be notified.
+type SuppressionsRepository interface {
+	// GetSuppressions returns the whole suppression list, oldest first.
+	GetSuppressions(ctx context.Context) ([]model.Suppression, error)
+	// GetSuppressed returns the recipients that are suppressed, lowercased.
+	GetSuppressed(ctx context.Context, recipients []string) ([]string, error)
+	// SaveSuppression adds the recipient to the suppression list.
+	SaveSuppression(ctx context.Context, suppression model.Suppression) error
+	// DeleteSuppression removes the recipient from the suppression list.
+	DeleteSuppression(ctx context.Context, email string) error
+}
+
+// PGSuppressionsRepository is the Postgres
+// implementation of SuppressionsRepository.
+type PGSuppressionsRepository struct {
+	db *sql.DB
+}
+
+// NewSuppressionsRepository builds a new SuppressionsRepository.
+func NewSuppressionsRepository(db *sql.DB) *PGSuppressionsRepository {
+	return &PGSuppressionsRepository{
+		db: db,
+	}
+}
+
+// GetSuppressions returns the whole suppression list, oldest first.
+func (r *PGSuppressionsRepository) GetSuppressions(ctx context.Context) ([]model.Suppression, error) {
+	rows, err := r.db.QueryContext(ctx, selectSuppressionsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var suppressions []model.Suppression
+	for rows.Next() {
+		var s model.Suppression
+		if err := rows.Scan(&s.Email, &s.Reason, &s.Detail, &s.ProviderMessageID, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		suppressions = append(suppressions, s)
+	}
+
+	return suppressions, rows.Err()
+}
+
+// GetSuppressed returns the recipients that are suppressed, lowercased.
+func (r *PGSuppressionsRepository) GetSuppressed(ctx context.Context, recipients []string) ([]string, error) {
+	emails := make([]string, 0, len(recipients))
+	for _, rcpt := range recipients {
+		emails = append(emails, normalizeEmail(rcpt))
+	}
+
+	rows, err := r.db.QueryContext(ctx, selectSuppressedQuery, pq.Array(emails))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var suppressed []string
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+		suppressed = append(suppressed, email)
+	}
+
+	return suppressed, rows.Err()
+}
+
+// SaveSuppression adds the recipient to the suppression list.
+// If the recipient is already suppressed, it is left unchanged.
+func (r *PGSuppressionsRepository) SaveSuppression(ctx context.Context, suppression model.Suppression) error {
+	_, err := r.db.ExecContext(ctx, insertSuppressionQuery, normalizeEmail(suppression.Email),
+		suppression.Reason, suppression.Detail, suppression.ProviderMessageID, suppression.CreatedAt)
+	return err
+}
+
+// DeleteSuppression removes the recipient from the suppression list.
+// It returns ErrSuppressionNotFound if the recipient is not suppressed.
+func (r *PGSuppressionsRepository) DeleteSuppression(ctx context.Context, email string) error {
+	res, err := r.db.ExecContext(ctx, deleteSuppressionQuery, normalizeEmail(email))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSuppressionNotFound
+	}
+	return nil
+}
+
+// normalizeEmail returns the form in which
+// emails are stored in the suppression list.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2021 Adevinta
+*/
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Transact runs fn within a new transaction, which is committed
+// if fn succeeds and rolled back otherwise.
+func Transact(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w: rollback: %v", err, rbErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// Savepoint runs fn within a savepoint of tx, which is rolled back
+// if fn fails, so tx can go on with the rest of its changes.
+// If tx is nil, fn runs as is.
+func Savepoint(ctx context.Context, tx *sql.Tx, name string, fn func() error) error {
+	if tx == nil {
+		return fn()
+	}
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		if _, rbErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); rbErr != nil {
+			return fmt.Errorf("%w: rollback: %v", err, rbErr)
+		}
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+	return err
+}
@@ -5,7 +5,39 @@
 set -e
 
 export PATH_STYLE="${PATH_STYLE:-false}"
+export QUEUE_BACKEND="${QUEUE_BACKEND:-sqs}"
 export SQS_NUM_PROCESSORS="${SQS_NUM_PROCESSORS:-2}"
+export SQS_AUTOSCALE="${SQS_AUTOSCALE:-false}"
+export SQS_MIN_PROCESSORS="${SQS_MIN_PROCESSORS:-1}"
+export SQS_MAX_PROCESSORS="${SQS_MAX_PROCESSORS:-10}"
+export SQS_ENVELOPE="${SQS_ENVELOPE:-auto}"
+export SQS_SNS_VERIFY="${SQS_SNS_VERIFY:-false}"
+export SQS_SNS_ALLOWED_TOPICS="${SQS_SNS_ALLOWED_TOPICS:-[]}"
+export SQS_FIFO="${SQS_FIFO:-false}"
+export PROCESSOR_TEAM_LOCK="${PROCESSOR_TEAM_LOCK:-false}"
+export PROCESSOR_BATCH_PARALLELISM="${PROCESSOR_BATCH_PARALLELISM:-4}"
+export PROCESSOR_CLAIM_LEASE="${PROCESSOR_CLAIM_LEASE:-3600}"
+export REAPER_ENABLED="${REAPER_ENABLED:-false}"
+export REAPER_INTERVAL="${REAPER_INTERVAL:-300}"
+export REAPER_STALE_AFTER="${REAPER_STALE_AFTER:-7200}"
+export REAPER_ACTION="${REAPER_ACTION:-fail}"
+export OUTBOX_ENABLED="${OUTBOX_ENABLED:-false}"
+export OUTBOX_INTERVAL="${OUTBOX_INTERVAL:-10}"
+export OUTBOX_MAX_ATTEMPTS="${OUTBOX_MAX_ATTEMPTS:-5}"
+export OUTBOX_RETRY_BACKOFF="${OUTBOX_RETRY_BACKOFF:-60}"
+export FEEDBACK_ENABLED="${FEEDBACK_ENABLED:-false}"
+export NOTIFIER_BACKEND="${NOTIFIER_BACKEND:-ses}"
+export NOTIFIER_SANDBOX_ENABLED="${NOTIFIER_SANDBOX_ENABLED:-false}"
+export NOTIFIER_SANDBOX_ALLOWLIST="${NOTIFIER_SANDBOX_ALLOWLIST:-[]}"
+export SES_ALLOWED_DOMAINS="${SES_ALLOWED_DOMAINS:-[]}"
+export SES_MAX_RECIPIENTS="${SES_MAX_RECIPIENTS:-50}"
+export SES_RATE_LIMIT_ENABLED="${SES_RATE_LIMIT_ENABLED:-false}"
+export SES_MAX_SEND_RATE="${SES_MAX_SEND_RATE:-0}"
+export SES_REPLICAS="${SES_REPLICAS:-1}"
+export SES_MAX_RETRIES="${SES_MAX_RETRIES:-3}"
+export CAPS_TEAM_DAILY="${CAPS_TEAM_DAILY:-0}"
+export CAPS_RECIPIENT_DAILY="${CAPS_RECIPIENT_DAILY:-0}"
+export UNSUBSCRIBE_ENABLED="${UNSUBSCRIBE_ENABLED:-false}"
 export GOMEMLIMIT=${GOMEMLIMIT:-1GiB}
 
 envsubst < config.toml > run.toml